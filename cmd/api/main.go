@@ -1,40 +1,76 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"log"
-	"net/http"
 	"os"
 
 	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/internal/mqttbridge"
+	"github.com/varjangn/tuya-middleware/internal/server"
 	"github.com/varjangn/tuya-middleware/pkg/logger"
 	"github.com/varjangn/tuya-middleware/pkg/tuya"
+	"github.com/varjangn/tuya-middleware/pkg/tuya/events"
 	"github.com/varjangn/tuya-middleware/utils"
 )
 
 func main() {
 
 	configPath := utils.GetConfigPath(os.Getenv("config"))
-	cfgFile, err := config.LoadConfig(configPath)
+	cfg, err := config.Load(configPath)
 	if err != nil {
-		log.Fatalf("LoadConfig: %v", err)
+		log.Fatalf("Load config: %v", err)
 	}
-	cfg, err := config.ParseConfig(cfgFile)
-	if err != nil {
-		log.Fatalf("ParseConfig: %v", err)
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid config %s:\n%v", configPath, err)
 	}
 
 	appLogger := logger.NewAppLogger(cfg)
 	appLogger.InitLogger()
+	appLogger.Debugf("config: %v", cfg)
 
 	tuyaClient := tuya.NewTuyaClient(appLogger, cfg)
 
 	// run goroutine to auto refresh tuya token
 	go tuyaClient.AutoRefreshToken()
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintf(w, "running...\n")
+	reloader := newReloader(appLogger, cfg, tuyaClient)
+	err = config.Watch(configPath, reloader.apply, func(err error) {
+		appLogger.Errorf("config reload: keeping the running config:\n%v", err)
 	})
-	http.ListenAndServe(cfg.Server.Port, nil)
+	if err != nil {
+		appLogger.Errorf("watch config: %v", err)
+	}
+
+	// every event source publishes on the bus
+	bus := events.NewBus(cfg.Events.BufferSize)
+
+	if cfg.Events.Enabled {
+		subscriber := events.NewSubscriber(appLogger, cfg, tuyaClient)
+		subscriber.AddConsumer(bus)
+		go subscriber.Run(context.Background())
+	}
+
+	if cfg.Poller.Enabled {
+		poller := events.NewPoller(appLogger, cfg, tuyaClient)
+		poller.AddConsumer(bus)
+		go poller.Run(context.Background())
+	}
+
+	s := server.NewServer(appLogger, cfg, tuyaClient, bus)
+
+	if cfg.Mqtt.Enabled {
+		// set commands go over the LAN first, like those of the REST API
+		bridge := mqttbridge.NewBridge(appLogger, cfg, tuyaClient, s.Router(), bus)
+		go func() {
+			if err := bridge.Run(context.Background()); err != nil {
+				appLogger.Errorf("mqtt bridge: %v", err)
+			}
+		}()
+	}
+
+	if err := s.Run(); err != nil {
+		appLogger.Fatal(err)
+	}
 
 }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/pkg/logger"
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+)
+
+// reloader applies the config changes that can be made live: the logger
+// level and encoding, and the Tuya credentials. Other changes are only
+// warned about, they need a restart.
+type reloader struct {
+	mu         sync.Mutex
+	logger     *logger.AppLogger
+	tuyaClient *tuya.TuyaClient
+	// the config in effect, a copy so the shared one is never written
+	running config.Config
+}
+
+func newReloader(logger *logger.AppLogger, cfg *config.Config, tuyaClient *tuya.TuyaClient) *reloader {
+	return &reloader{logger: logger, tuyaClient: tuyaClient, running: *cfg}
+}
+
+func (r *reloader) apply(next *config.Config) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed := config.Changed(&r.running, next)
+	if len(changed) == 0 {
+		return
+	}
+
+	loggerChanged, tuyaChanged := false, false
+	restart := []string{}
+	for _, key := range changed {
+		switch key {
+		case "logger.Level", "logger.Encoding":
+			loggerChanged = true
+		case "tuya.Host", "tuya.ClientId", "tuya.Secret", "tuya.SecondarySecret":
+			tuyaChanged = true
+		default:
+			restart = append(restart, key)
+		}
+	}
+	if len(restart) > 0 {
+		r.logger.Warnf("config reload: %s can't change without a restart, keeping the running values",
+			strings.Join(restart, ", "))
+	}
+
+	if loggerChanged {
+		r.logger.Reload(next.Logger)
+		r.running.Logger.Level = next.Logger.Level
+		r.running.Logger.Encoding = next.Logger.Encoding
+		r.logger.Infof("config reload: logger level %q, encoding %q", next.Logger.Level, next.Logger.Encoding)
+	}
+
+	if tuyaChanged {
+		if err := r.tuyaClient.UpdateCredentials(next.Tuya); err != nil {
+			r.logger.Errorf("config reload: keeping the running Tuya credentials, no token with the new ones: %v", err)
+			return
+		}
+		// events are decrypted with the new secrets right away, the
+		// subscription only follows a new client id once it reconnects
+		if r.running.Events.Enabled && r.running.Tuya.ClientId != next.Tuya.ClientId {
+			r.logger.Warn("config reload: the message service stays subscribed with the previous client id until it reconnects")
+		}
+		r.running.Tuya = next.Tuya
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/internal/fakecloud"
+	"github.com/varjangn/tuya-middleware/pkg/logger"
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+)
+
+// testReloader returns a reloader against a fake cloud checking the secret,
+// with the logs written to the returned file
+func testReloader(t *testing.T) (*reloader, *config.Config, *fakecloud.Server, *os.File) {
+	t.Helper()
+	cloud := fakecloud.NewServer("client")
+	cloud.SetSecret("secret")
+	server := httptest.NewServer(cloud)
+	t.Cleanup(server.Close)
+
+	cfg := &config.Config{
+		Server: config.Server{Port: ":5000"},
+		Logger: config.Logger{Level: "warn", Encoding: "console"},
+		Tuya:   config.Tuya{Host: server.URL, ClientId: "client", Secret: "secret"},
+	}
+
+	// the logger writes to the os.Stderr of its creation
+	logs, err := os.Create(filepath.Join(t.TempDir(), "logs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { logs.Close() })
+	stderr := os.Stderr
+	os.Stderr = logs
+	appLogger := logger.NewAppLogger(cfg)
+	appLogger.InitLogger()
+	os.Stderr = stderr
+
+	tuyaClient := tuya.NewTuyaClient(appLogger, cfg)
+	t.Cleanup(func() { tuya.SetActiveToken(&tuya.TokenResponse{}) })
+	if err := tuyaClient.FetchToken(); err != nil {
+		t.Fatalf("FetchToken: %v", err)
+	}
+	return newReloader(appLogger, cfg, tuyaClient), cfg, cloud, logs
+}
+
+func readLogs(t *testing.T, logs *os.File) string {
+	t.Helper()
+	data, err := os.ReadFile(logs.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestReloadKeepsRestartOnlyKeys(t *testing.T) {
+	r, cfg, _, logs := testReloader(t)
+
+	next := *cfg
+	next.Server.Port = ":6000"
+	next.Mqtt.Enabled = true
+	next.Logger.Level = "info"
+	r.apply(&next)
+
+	if r.running.Server.Port != ":5000" || r.running.Mqtt.Enabled {
+		t.Fatalf("restart-only keys applied: %+v", r.running)
+	}
+	if r.running.Logger.Level != "info" {
+		t.Fatalf("logger.Level = %q, want info", r.running.Logger.Level)
+	}
+	output := readLogs(t, logs)
+	if !strings.Contains(output, "server.Port, mqtt.Enabled can't change without a restart") {
+		t.Fatalf("no warning for the restart-only keys:\n%s", output)
+	}
+	// logged at the new info level
+	if !strings.Contains(output, `logger level "info"`) {
+		t.Fatalf("the new logger level isn't in effect:\n%s", output)
+	}
+	if cfg.Server.Port != ":5000" || cfg.Logger.Level != "warn" {
+		t.Fatalf("the shared config was written: %+v", cfg)
+	}
+}
+
+func TestReloadTuyaCredentials(t *testing.T) {
+	r, cfg, cloud, logs := testReloader(t)
+
+	rejected := *cfg
+	rejected.Tuya.Secret = "wrong"
+	r.apply(&rejected)
+	if r.running.Tuya.Secret != "secret" {
+		t.Fatalf("running secret = %q after a rejected one", r.running.Tuya.Secret)
+	}
+	if _, secrets := r.tuyaClient.Secrets(); secrets[0] != "secret" {
+		t.Fatalf("client secrets = %v, want the running one", secrets)
+	}
+	if output := readLogs(t, logs); !strings.Contains(output, "keeping the running Tuya credentials") {
+		t.Fatalf("the rejected credentials weren't reported:\n%s", output)
+	}
+
+	cloud.SetSecret("rotated")
+	rotated := *cfg
+	rotated.Tuya.Secret = "rotated"
+	r.apply(&rotated)
+	if r.running.Tuya.Secret != "rotated" {
+		t.Fatalf("running secret = %q, want rotated", r.running.Tuya.Secret)
+	}
+	if _, secrets := r.tuyaClient.Secrets(); secrets[0] != "rotated" {
+		t.Fatalf("client secrets = %v, want rotated", secrets)
+	}
+	if _, err := r.tuyaClient.GetDevices(1, 10, map[string]string{}); err != nil {
+		t.Fatalf("GetDevices with the new secret: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/varjangn/tuya-middleware/internal/fakecloud"
+	"github.com/varjangn/tuya-middleware/pkg/tuyalocal"
+)
+
+// tuya-sim runs virtual devices speaking the LAN protocol, and optionally a
+// fake cloud with the same devices registered, for development without hardware
+func main() {
+	specPath := flag.String("spec", "devices.yml", "YAML spec of the devices")
+	host := flag.String("host", "127.0.0.1", "address the devices listen on")
+	port := flag.Int("port", 6668, "port of the first device, the next ones use the following ports")
+	broadcastAddr := flag.String("broadcast", "255.255.255.255", "address discovery broadcasts are sent to")
+	interval := flag.Duration("interval", 5*time.Second, "interval between discovery broadcasts, 0 disables them")
+	cloudAddr := flag.String("cloud", "", "listen address of the fake cloud, e.g. :9000, empty disables it")
+	clientId := flag.String("client-id", "tuyasim", "client id accepted by the fake cloud")
+	secret := flag.String("secret", "", "secret the fake cloud verifies signatures with, empty skips the check")
+	flag.Parse()
+
+	spec, err := loadSpec(*specPath)
+	if err != nil {
+		log.Fatalf("spec: %v", err)
+	}
+	devices := buildDevices(spec, *port)
+
+	for _, device := range devices {
+		listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", *host, device.port))
+		if err != nil {
+			log.Fatalf("device %s: %v", device.sim.Id, err)
+		}
+		go device.sim.Serve(listener)
+		log.Printf("device %s %q (%s) on %s, local key %s",
+			device.sim.Id, device.name, device.sim.Version, listener.Addr(), device.sim.LocalKey)
+	}
+
+	if *interval > 0 {
+		go broadcast(devices, *host, *broadcastAddr, *interval)
+	}
+
+	if *cloudAddr != "" {
+		cloud := fakecloud.NewServer(*clientId)
+		cloud.SetSecret(*secret)
+		for _, device := range devices {
+			cloud.Register(device.cloudDevice(*host))
+		}
+		go func() {
+			log.Printf("fake cloud listening on %s with client id %s", *cloudAddr, *clientId)
+			log.Fatal(http.ListenAndServe(*cloudAddr, cloud))
+		}()
+	}
+
+	select {}
+}
+
+// broadcast sends the discovery packet of every device on its version's port
+func broadcast(devices []*virtualDevice, host, addr string, interval time.Duration) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		log.Fatalf("broadcast: %v", err)
+	}
+	defer conn.Close()
+
+	packets := make([][]byte, len(devices))
+	targets := make([]*net.UDPAddr, len(devices))
+	for i, device := range devices {
+		if packets[i], err = tuyalocal.EncodeBroadcast(device.broadcast(host)); err != nil {
+			log.Fatalf("broadcast %s: %v", device.sim.Id, err)
+		}
+		targets[i] = &net.UDPAddr{IP: net.ParseIP(addr), Port: tuyalocal.BroadcastPort(device.sim.Version)}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for i := range devices {
+			if _, err := conn.WriteTo(packets[i], targets[i]); err != nil {
+				log.Printf("broadcast %s: %v", devices[i].sim.Id, err)
+			}
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/varjangn/tuya-middleware/internal/fakecloud"
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+	"github.com/varjangn/tuya-middleware/pkg/tuyalocal"
+	"gopkg.in/yaml.v3"
+)
+
+// Spec lists the device templates to simulate, Count devices are created
+// from each template
+type Spec struct {
+	Devices []DeviceSpec `yaml:"devices"`
+}
+
+type DeviceSpec struct {
+	Name        string   `yaml:"name"`
+	Count       int      `yaml:"count"`
+	Version     string   `yaml:"version"`
+	Category    string   `yaml:"category"`
+	ProductId   string   `yaml:"product_id"`
+	ProductName string   `yaml:"product_name"`
+	Dps         []DpSpec `yaml:"dps"`
+}
+
+// DpSpec is a data point with its initial Value, Type and Values are
+// reported in the cloud specification
+type DpSpec struct {
+	Id     int         `yaml:"id"`
+	Code   string      `yaml:"code"`
+	Type   string      `yaml:"type"`
+	Values string      `yaml:"values"`
+	Value  interface{} `yaml:"value"`
+}
+
+// virtualDevice is a simulated device and its cloud registration
+type virtualDevice struct {
+	sim       *tuyalocal.SimulatedDevice
+	spec      DeviceSpec
+	name      string
+	productId string
+	port      int
+}
+
+func loadSpec(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec := &Spec{}
+	if err := yaml.Unmarshal(data, spec); err != nil {
+		return nil, err
+	}
+	if len(spec.Devices) == 0 {
+		return nil, fmt.Errorf("spec has no devices")
+	}
+	for i, device := range spec.Devices {
+		switch device.Version {
+		case tuyalocal.Version31, tuyalocal.Version33, tuyalocal.Version34, tuyalocal.Version35:
+		default:
+			return nil, fmt.Errorf("devices[%d]: unsupported version %q", i, device.Version)
+		}
+		if len(device.Dps) == 0 {
+			return nil, fmt.Errorf("devices[%d]: no dps", i)
+		}
+	}
+	return spec, nil
+}
+
+// deviceId and localKey are derived from the device number so they are stable
+// across restarts
+func deviceId(n int) string {
+	return fmt.Sprintf("tuyasim%013d", n)
+}
+
+func localKey(id string) string {
+	sum := md5.Sum([]byte(id))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// buildDevices creates the devices of every template, device n listens on basePort+n
+func buildDevices(spec *Spec, basePort int) []*virtualDevice {
+	devices := []*virtualDevice{}
+	for _, template := range spec.Devices {
+		count := template.Count
+		if count <= 0 {
+			count = 1
+		}
+		dps := map[string]interface{}{}
+		for _, dp := range template.Dps {
+			dps[strconv.Itoa(dp.Id)] = dp.Value
+		}
+		productId := template.ProductId
+		if productId == "" {
+			productId = "tuyasimproduct00"
+		}
+
+		for i := 0; i < count; i++ {
+			id := deviceId(len(devices) + 1)
+			name := template.Name
+			if count > 1 {
+				name = fmt.Sprintf("%s %d", template.Name, i+1)
+			}
+			devices = append(devices, &virtualDevice{
+				sim:       tuyalocal.NewSimulatedDevice(id, localKey(id), template.Version, dps),
+				spec:      template,
+				name:      name,
+				productId: productId,
+				port:      basePort + len(devices),
+			})
+		}
+	}
+	return devices
+}
+
+// address is what the device broadcasts as its ip, the port is only added
+// when it isn't the default one
+func (d *virtualDevice) address(host string) string {
+	if strconv.Itoa(d.port) == tuyalocal.DefaultPort {
+		return host
+	}
+	return fmt.Sprintf("%s:%d", host, d.port)
+}
+
+func (d *virtualDevice) broadcast(host string) tuyalocal.Broadcast {
+	return tuyalocal.Broadcast{
+		Ip:         d.address(host),
+		GwId:       d.sim.Id,
+		Active:     2,
+		Encrypt:    d.sim.Version != tuyalocal.Version31,
+		ProductKey: d.productId,
+		Version:    d.sim.Version,
+	}
+}
+
+func (d *virtualDevice) cloudDevice(host string) fakecloud.Device {
+	dataPoints := make([]fakecloud.DataPoint, 0, len(d.spec.Dps))
+	for _, dp := range d.spec.Dps {
+		dataPoints = append(dataPoints, fakecloud.DataPoint{Id: dp.Id, Code: dp.Code, Type: dp.Type, Values: dp.Values})
+	}
+	return fakecloud.Device{
+		Info: tuya.Device{
+			Id:          d.sim.Id,
+			Name:        d.name,
+			LocalKey:    d.sim.LocalKey,
+			Category:    d.spec.Category,
+			ProductId:   d.productId,
+			ProductName: d.spec.ProductName,
+			Ip:          d.address(host),
+		},
+		DataPoints: dataPoints,
+		State:      d.sim.DPs,
+		Set:        d.sim.SetDPs,
+	}
+}
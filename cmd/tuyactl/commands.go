@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+)
+
+// subcommand splits args into the subcommand name and its args
+func subcommand(args []string, names ...string) (string, []string, error) {
+	if len(args) == 0 {
+		return "", nil, fmt.Errorf("missing subcommand, one of %s", strings.Join(names, ", "))
+	}
+	for _, name := range names {
+		if args[0] == name {
+			return name, args[1:], nil
+		}
+	}
+	return "", nil, fmt.Errorf("unknown subcommand %q, one of %s", args[0], strings.Join(names, ", "))
+}
+
+// needArgs checks the count of positional args
+func needArgs(args []string, names ...string) error {
+	if len(args) != len(names) {
+		return fmt.Errorf("expected arguments: %s", strings.Join(names, " "))
+	}
+	return nil
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	return flags
+}
+
+type actionResult struct {
+	Id      string `json:"id"`
+	Success bool   `json:"success"`
+}
+
+func devicesCommand(a *app, args []string) error {
+	name, args, err := subcommand(args, "list", "get", "rename", "delete", "reset")
+	if err != nil {
+		return err
+	}
+
+	switch name {
+	case "list":
+		flags := newFlagSet("devices list")
+		pageSize := flags.Int("page-size", 100, "devices fetched per request")
+		deviceIds := flags.String("device-ids", "", "comma separated device ids")
+		productIds := flags.String("product-ids", "", "comma separated product ids")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		query := map[string]string{}
+		if *deviceIds != "" {
+			query["device_ids"] = *deviceIds
+		}
+		if *productIds != "" {
+			query["product_ids"] = *productIds
+		}
+
+		devices := []tuya.Device{}
+		for pageNo := 1; ; pageNo++ {
+			page, err := a.client.GetDevices(pageNo, *pageSize, query)
+			if err != nil {
+				return err
+			}
+			devices = append(devices, page.Devices...)
+			if len(page.Devices) < *pageSize || int64(len(devices)) >= page.Total {
+				break
+			}
+		}
+		return a.print(devices, "id", "name", "category", "product_name", "online", "ip")
+	case "get":
+		if err := needArgs(args, "<device-id>"); err != nil {
+			return err
+		}
+		device, err := a.client.GetDevice(args[0])
+		if err != nil {
+			return err
+		}
+		return a.print(device)
+	case "rename":
+		if err := needArgs(args, "<device-id>", "<name>"); err != nil {
+			return err
+		}
+		ok, err := a.client.SetDeviceName(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		return a.print(actionResult{Id: args[0], Success: ok})
+	case "delete":
+		if err := needArgs(args, "<device-id>"); err != nil {
+			return err
+		}
+		ok, err := a.client.DeleteDevice(args[0])
+		if err != nil {
+			return err
+		}
+		return a.print(actionResult{Id: args[0], Success: ok})
+	default:
+		if err := needArgs(args, "<device-id>"); err != nil {
+			return err
+		}
+		ok, err := a.client.FactoryResetDevice(args[0])
+		if err != nil {
+			return err
+		}
+		return a.print(actionResult{Id: args[0], Success: ok})
+	}
+}
+
+func subdevicesCommand(a *app, args []string) error {
+	if err := needArgs(args, "<device-id>"); err != nil {
+		return err
+	}
+	subDevices, err := a.client.GetSubDevices(args[0])
+	if err != nil {
+		return err
+	}
+	return a.print(subDevices)
+}
+
+func usersCommand(a *app, args []string) error {
+	name, args, err := subcommand(args, "list", "add", "remove")
+	if err != nil {
+		return err
+	}
+
+	switch name {
+	case "list":
+		if err := needArgs(args, "<device-id>"); err != nil {
+			return err
+		}
+		users, err := a.client.GetDeviceUsers(args[0])
+		if err != nil {
+			return err
+		}
+		return a.print(users)
+	case "add":
+		if len(args) == 0 {
+			return fmt.Errorf("expected arguments: <device-id>")
+		}
+		deviceId := args[0]
+		flags := newFlagSet("users add")
+		nickName := flags.String("nick-name", "", "nick name of the user")
+		sex := flags.Int("sex", -1, "0 for male, 1 for female")
+		contact := flags.String("contact", "", "contact of the user")
+		height := flags.Int("height", 0, "height in cm")
+		weight := flags.Int("weight", 0, "weight in kg")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *nickName == "" || *sex < 0 {
+			return fmt.Errorf("--nick-name and --sex are required")
+		}
+		userInfo := map[string]interface{}{"nick_name": *nickName, "sex": *sex}
+		if *contact != "" {
+			userInfo["contact"] = *contact
+		}
+		if *height > 0 {
+			userInfo["height"] = *height
+		}
+		if *weight > 0 {
+			userInfo["weight"] = *weight
+		}
+		userId, err := a.client.AddUser(deviceId, userInfo)
+		if err != nil {
+			return err
+		}
+		return a.print(actionResult{Id: userId, Success: true})
+	default:
+		if err := needArgs(args, "<device-id>", "<user-id>"); err != nil {
+			return err
+		}
+		ok, err := a.client.DeleteDeviceUser(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		return a.print(actionResult{Id: args[1], Success: ok})
+	}
+}
+
+func factoryInfoCommand(a *app, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected arguments: <device-id>...")
+	}
+	infos, err := a.client.GetFactoryInfo(strings.Join(args, ","))
+	if err != nil {
+		return err
+	}
+	return a.print(infos)
+}
+
+type tokenInfo struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	UID          string    `json:"uid"`
+	ExpireTime   int       `json:"expire_time"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+func tokenCommand(a *app, args []string) error {
+	name, args, err := subcommand(args, "show", "refresh")
+	if err != nil {
+		return err
+	}
+	if err := needArgs(args); err != nil {
+		return err
+	}
+	if name == "refresh" {
+		if err := a.client.RefreshToken(); err != nil {
+			return err
+		}
+	}
+
+	token := a.client.GetActiveToken()
+	return a.print(tokenInfo{
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		UID:          token.UID,
+		ExpireTime:   token.ExpireTime,
+		ExpiresAt:    tuya.TokenExpiringAt(),
+	})
+}
+
+// headerFlags collects the repeated -H key:value flags
+type headerFlags map[string]string
+
+func (h headerFlags) String() string {
+	return fmt.Sprint(map[string]string(h))
+}
+
+func (h headerFlags) Set(value string) error {
+	key, val, ok := strings.Cut(value, ":")
+	if !ok || key == "" {
+		return fmt.Errorf("header %q is not key:value", value)
+	}
+	h[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	return nil
+}
+
+// rawCommand sends a signed request to any path of the Host and prints the
+// response body, a body of - is read from stdin
+func rawCommand(a *app, args []string) error {
+	flags := newFlagSet("raw")
+	headers := headerFlags{}
+	flags.Var(headers, "H", "signed header key:value, repeatable")
+	nonce := flags.Bool("nonce", false, "sign with a random nonce")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+	if len(args) != 2 && len(args) != 3 {
+		return fmt.Errorf("expected arguments: [-H key:value]... [-nonce] <method> <path> [body|-]")
+	}
+	var body []byte
+	if len(args) == 3 {
+		body = []byte(args[2])
+		if args[2] == "-" {
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return err
+			}
+			body = data
+		}
+	}
+
+	opts := tuya.SignOptions{Headers: headers}
+	if *nonce {
+		opts.Nonce = tuya.NewNonce()
+	}
+	resp, err := a.client.Do(strings.ToUpper(args[0]), args[1], body, opts)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		_, err = a.out.Write(data)
+		return err
+	}
+	if a.output == outputTable {
+		// a raw response has no known columns
+		return printResult(a.out, outputJSON, v)
+	}
+	return a.print(v)
+}
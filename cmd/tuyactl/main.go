@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/pkg/logger"
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+	"github.com/varjangn/tuya-middleware/utils"
+)
+
+const usage = `tuyactl talks to the Tuya cloud with the credentials of the middleware config.
+
+Usage:
+  tuyactl [flags] <command> [args]
+
+Commands:
+  devices list [--page-size n] [--device-ids a,b] [--product-ids a,b]
+  devices get <device-id>
+  devices rename <device-id> <name>
+  devices delete <device-id>
+  devices reset <device-id>
+  subdevices <device-id>
+  users list <device-id>
+  users add <device-id> --nick-name <name> --sex <0|1> [--contact c] [--height n] [--weight n]
+  users remove <device-id> <user-id>
+  factory-info <device-id>...
+  token show
+  token refresh
+  raw [-H key:value]... [-nonce] <method> <path> [body|-]
+
+Flags:
+`
+
+type app struct {
+	client *tuya.TuyaClient
+	output string
+	out    io.Writer
+}
+
+type command func(a *app, args []string) error
+
+var commands = map[string]command{
+	"devices":      devicesCommand,
+	"subdevices":   subdevicesCommand,
+	"users":        usersCommand,
+	"factory-info": factoryInfoCommand,
+	"token":        tokenCommand,
+	"raw":          rawCommand,
+}
+
+func main() {
+	flags := flag.NewFlagSet("tuyactl", flag.ExitOnError)
+	env := flags.String("config", os.Getenv("config"), "config environment, reads config/config-<env>.yml")
+	project := flags.String("project", "", "project of the config Projects to use instead of tuya")
+	output := flags.String("o", outputTable, "output format: table, json or yaml")
+	verbose := flags.Bool("v", false, "log the requests made")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "tuyactl: unknown command %q\n\n", flags.Arg(0))
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load(utils.GetConfigPath(*env))
+	if err != nil {
+		fail(err)
+	}
+	if *project != "" {
+		if err := cfg.UseProject(*project); err != nil {
+			fail(err)
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		fail(fmt.Errorf("invalid config:\n%w", err))
+	}
+	cfg.Logger.Level = "error"
+	if *verbose {
+		cfg.Logger.Level = "info"
+	}
+	appLogger := logger.NewAppLogger(cfg)
+	appLogger.InitLogger()
+
+	client := tuya.NewTuyaClient(appLogger, cfg)
+	if err := client.FetchToken(); err != nil {
+		fail(fmt.Errorf("fetch token: %w", err))
+	}
+	if client.GetActiveToken().AccessToken == "" {
+		fail(fmt.Errorf("fetch token: no access token returned, check the Host, ClientId and Secret"))
+	}
+
+	a := &app{client: client, output: *output, out: os.Stdout}
+	if err := cmd(a, flags.Args()[1:]); err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "tuyactl: %v\n", err)
+	os.Exit(1)
+}
+
+func (a *app) print(v interface{}, columns ...string) error {
+	return printResult(a.out, a.output, v, columns...)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// printResult writes v in the requested format, tables list the scalar
+// fields of a slice of structs, or only columns when given, or the fields of
+// a single struct as rows
+func printResult(w io.Writer, format string, v interface{}, columns ...string) error {
+	switch format {
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case outputYAML:
+		// round trip through JSON so the keys are the JSON field names
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		var generic interface{}
+		if err := json.Unmarshal(data, &generic); err != nil {
+			return err
+		}
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		defer enc.Close()
+		return enc.Encode(generic)
+	case outputTable:
+		return printTable(w, v, columns)
+	default:
+		return fmt.Errorf("unknown output %q, use table, json or yaml", format)
+	}
+}
+
+func printTable(w io.Writer, v interface{}, columns []string) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+
+	value := reflect.Indirect(reflect.ValueOf(v))
+	switch value.Kind() {
+	case reflect.Slice:
+		elem := value.Type().Elem()
+		for elem.Kind() == reflect.Ptr {
+			elem = elem.Elem()
+		}
+		if elem.Kind() != reflect.Struct {
+			for i := 0; i < value.Len(); i++ {
+				fmt.Fprintln(tw, cell(value.Index(i)))
+			}
+			return nil
+		}
+		fields := tableFields(elem, columns)
+		headers := make([]string, len(fields))
+		for i, field := range fields {
+			headers[i] = strings.ToUpper(fieldName(elem.Field(field)))
+		}
+		fmt.Fprintln(tw, strings.Join(headers, "\t"))
+		for i := 0; i < value.Len(); i++ {
+			row := reflect.Indirect(value.Index(i))
+			cells := make([]string, len(fields))
+			for j, field := range fields {
+				cells[j] = cell(row.Field(field))
+			}
+			fmt.Fprintln(tw, strings.Join(cells, "\t"))
+		}
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if !field.IsExported() || field.Anonymous {
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%s\n", strings.ToUpper(fieldName(field)), cell(value.Field(i)))
+		}
+	default:
+		fmt.Fprintln(tw, cell(value))
+	}
+	return nil
+}
+
+// tableFields are the exported fields of a struct that fit in a cell,
+// restricted to columns when given
+func tableFields(t reflect.Type, columns []string) []int {
+	if len(columns) > 0 {
+		fields := []int{}
+		for _, column := range columns {
+			for i := 0; i < t.NumField(); i++ {
+				if t.Field(i).IsExported() && fieldName(t.Field(i)) == column {
+					fields = append(fields, i)
+				}
+			}
+		}
+		return fields
+	}
+
+	fields := []int{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() || field.Anonymous {
+			continue
+		}
+		switch field.Type.Kind() {
+		case reflect.Slice, reflect.Map, reflect.Struct, reflect.Interface:
+			continue
+		}
+		fields = append(fields, i)
+	}
+	return fields
+}
+
+func fieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+// cell formats scalars as is and anything else as compact JSON
+func cell(v reflect.Value) string {
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.Format(time.RFC3339)
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Struct, reflect.Interface, reflect.Ptr:
+		data, err := json.Marshal(v.Interface())
+		if err != nil {
+			return fmt.Sprint(v.Interface())
+		}
+		return string(data)
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
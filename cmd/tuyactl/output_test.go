@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testRow struct {
+	Id      string            `json:"id"`
+	Name    string            `json:"name,omitempty"`
+	Online  bool              `json:"online"`
+	Count   int               `json:"count"`
+	Tags    []string          `json:"tags"`
+	Attrs   map[string]string `json:"attrs"`
+	Value   interface{}       `json:"value"`
+	Seen    time.Time         `json:"seen"`
+	NoTag   string
+	private string
+}
+
+func lines(s ...string) string {
+	return strings.Join(s, "\n") + "\n"
+}
+
+func TestPrintTable(t *testing.T) {
+	seen := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	rows := []testRow{
+		{Id: "d1", Name: "plug", Online: true, Count: 3, Tags: []string{"a", "b"}, Attrs: map[string]string{"k": "v"}, Value: "on", Seen: seen, NoTag: "x"},
+		{Id: "device-2", Count: 12, Value: 25.5},
+	}
+
+	for _, tt := range []struct {
+		name    string
+		v       interface{}
+		columns []string
+		want    string
+	}{
+		{
+			// nested values and times are left out of the default columns
+			name: "scalar fields",
+			v:    rows,
+			want: lines(
+				"ID        NAME  ONLINE  COUNT  NOTAG",
+				"d1        plug  true    3      x",
+				"device-2        false   12     ",
+			),
+		},
+		{
+			name: "pointer elements",
+			v:    []*testRow{&rows[0]},
+			want: lines(
+				"ID  NAME  ONLINE  COUNT  NOTAG",
+				"d1  plug  true    3      x",
+			),
+		},
+		{
+			name:    "columns in order with nested values and times",
+			v:       rows,
+			columns: []string{"count", "id", "tags", "attrs", "value", "seen", "unknown"},
+			want: lines(
+				"COUNT  ID        TAGS       ATTRS      VALUE  SEEN",
+				`3      d1        ["a","b"]  {"k":"v"}  "on"   2024-03-01T12:30:00Z`,
+				"12     device-2  null       null       25.5   0001-01-01T00:00:00Z",
+			),
+		},
+		{
+			name:    "unexported field",
+			v:       rows[:1],
+			columns: []string{"id", "private"},
+			want: lines(
+				"ID",
+				"d1",
+			),
+		},
+		{
+			name: "single struct as rows",
+			v:    &rows[0],
+			want: lines(
+				"ID      d1",
+				"NAME    plug",
+				"ONLINE  true",
+				"COUNT   3",
+				`TAGS    ["a","b"]`,
+				`ATTRS   {"k":"v"}`,
+				`VALUE   "on"`,
+				"SEEN    2024-03-01T12:30:00Z",
+				"NOTAG   x",
+			),
+		},
+		{
+			name: "scalars",
+			v:    []string{"a", "b"},
+			want: lines("a", "b"),
+		},
+		{
+			name: "scalar",
+			v:    true,
+			want: lines("true"),
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := printTable(&out, tt.v, tt.columns); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Fatalf("printTable =\n%s\nwant\n%s", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintResult(t *testing.T) {
+	v := []testRow{{Id: "d1", Count: 1}}
+	for _, tt := range []struct {
+		format string
+		want   string
+	}{
+		{outputJSON, `"id": "d1"`},
+		{outputYAML, "  count: 1\n  id: d1\n"},
+		{outputTable, "ID  NAME  ONLINE  COUNT  NOTAG\n"},
+	} {
+		var out bytes.Buffer
+		if err := printResult(&out, tt.format, v); err != nil {
+			t.Fatalf("%s: %v", tt.format, err)
+		}
+		if !strings.Contains(out.String(), tt.want) {
+			t.Fatalf("%s output %q doesn't contain %q", tt.format, out.String(), tt.want)
+		}
+	}
+
+	if err := printResult(&bytes.Buffer{}, "xml", v); err == nil {
+		t.Fatal("unknown format accepted")
+	}
+}
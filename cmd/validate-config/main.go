@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/varjangn/tuya-middleware/config"
+	"gopkg.in/yaml.v3"
+)
+
+// validate-config checks config files the way the server does at startup,
+// printing every problem, for use in CI. Secret references are only checked
+// for their syntax, CI usually has neither the secret files nor variables.
+func main() {
+	dump := flag.Bool("print", false, "print the config, secrets masked")
+	resolve := flag.Bool("resolve", false, "resolve the secret references, reading the files and environment variables")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: validate-config [-print] [-resolve] <config file>...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, filename := range flag.Args() {
+		cfg, err := config.LoadFile(filename, *resolve)
+		if err == nil {
+			err = cfg.Validate()
+		}
+		if err != nil {
+			failed = true
+			fmt.Printf("%s: invalid\n", filename)
+			var joined interface{ Unwrap() []error }
+			if errors.As(err, &joined) {
+				for _, problem := range joined.Unwrap() {
+					fmt.Printf("  %v\n", problem)
+				}
+			} else {
+				fmt.Printf("  %v\n", err)
+			}
+			continue
+		}
+		fmt.Printf("%s: ok\n", filename)
+		if *dump {
+			data, err := yaml.Marshal(cfg.Masked())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "print %s: %v\n", filename, err)
+				os.Exit(1)
+			}
+			fmt.Print(string(data))
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
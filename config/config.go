@@ -2,15 +2,30 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	Server Server
-	Logger Logger
-	Tuya   Tuya
+	Server   Server
+	Logger   Logger
+	Tuya     Tuya
+	Camera   Camera
+	Events   Events
+	Poller   Poller
+	Webhooks Webhooks
+	Mqtt     Mqtt
+	Local    Local
+	Proxy    Proxy
+	Admin    Admin
+	// extra Tuya projects selectable with tuyactl --project
+	Projects map[string]Tuya
 }
 
 type Server struct {
@@ -27,10 +42,109 @@ type Logger struct {
 	Level             string
 }
 
+// ClientId and the secrets may be references such as
+// file:///run/secrets/tuya_secret or env:TUYA_SECRET, resolved at load time.
+// SecondarySecret is tried when Secret is rejected, while rotating it.
+// A local clock further than ClockSkewWarning from the Tuya one is warned about.
+// Nonce signs every request with a random nonce.
 type Tuya struct {
-	Host     string
-	ClientId string
-	Secret   string
+	Host             string
+	ClientId         string `secret:"true"`
+	Secret           string `secret:"true"`
+	SecondarySecret  string `secret:"true"`
+	ClockSkewWarning time.Duration
+	Nonce            bool
+}
+
+type Camera struct {
+	StreamTTL time.Duration
+}
+
+// Tuya message service (pulsar) subscription
+type Events struct {
+	Enabled         bool
+	Url             string
+	Env             string
+	AckTimeout      time.Duration
+	MaxRedeliveries int
+	// events kept in memory for Last-Event-ID resume
+	BufferSize        int
+	HeartbeatInterval time.Duration
+}
+
+// polling based change detection, scoped to DeviceIds, ProductIds and
+// Categories when set. QPS caps the Tuya requests made by the poller.
+type Poller struct {
+	Enabled    bool
+	Interval   time.Duration
+	PageSize   int
+	QPS        float64
+	DeviceIds  []string
+	ProductIds []string
+	Categories []string
+}
+
+// outbound webhook delivery, a delivery is retried MaxAttempts times with
+// exponential backoff starting at InitialBackoff before it is dead lettered
+type Webhooks struct {
+	Workers        int
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	Timeout        time.Duration
+	DeadLetterSize int
+}
+
+// optional MQTT bridge, Broker is a url such as tcp://localhost:1883
+type Mqtt struct {
+	Enabled     bool
+	Broker      string
+	ClientId    string
+	Username    string
+	Password    string `secret:"true"`
+	TopicPrefix string
+	Qos         byte
+	Discovery   Discovery
+}
+
+// Home Assistant MQTT discovery, Products overrides the generated entities
+// per product id (lower case, as viper lower cases map keys)
+type Discovery struct {
+	Enabled  bool
+	Prefix   string
+	Products map[string]DiscoveryProduct
+}
+
+// override for a product: map it as another Category, Ignore it, or list its Entities
+type DiscoveryProduct struct {
+	Category string
+	Ignore   bool
+	Entities []DiscoveryEntity
+}
+
+// Component is switch, light, sensor or binary_sensor. Scale defaults to the
+// DP scale from the specification, OnValue is the JSON state of an "on" binary sensor.
+type DiscoveryEntity struct {
+	Component   string
+	Code        string
+	Name        string
+	DeviceClass string
+	Unit        string
+	Scale       int
+	OnValue     string
+}
+
+// LAN discovery and control. Devices not heard from for StaleAfter are
+// considered gone from the LAN and forgotten after ExpireAfter, the device
+// inventory used to match broadcasts is refreshed every InventoryInterval.
+// Local requests time out after Timeout, a path that failed is skipped for
+// SkipFor, doubled on consecutive failures.
+type Local struct {
+	Enabled           bool
+	StaleAfter        time.Duration
+	ExpireAfter       time.Duration
+	InventoryInterval time.Duration
+	Timeout           time.Duration
+	SkipFor           time.Duration
 }
 
 func LoadConfig(filename string) (*viper.Viper, error) {
@@ -38,7 +152,7 @@ func LoadConfig(filename string) (*viper.Viper, error) {
 
 	v.SetConfigName(filename)
 	v.AddConfigPath(".")
-	v.AutomaticEnv()
+	readEnv(v)
 
 	if err := v.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
@@ -51,7 +165,71 @@ func LoadConfig(filename string) (*viper.Viper, error) {
 
 }
 
+// LoadFile reads and parses a config file by its path, extension included.
+// Unless resolve is set the secret references are only checked for their
+// syntax and kept as is, to validate a config away from its secrets.
+func LoadFile(filename string, resolve bool) (*Config, error) {
+	v := viper.New()
+	v.SetConfigFile(filename)
+	readEnv(v)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	return parseConfig(v, resolve)
+}
+
+// readEnv lets environment variables override the config keys, TUYA_SECRET
+// overrides tuya.Secret
+func readEnv(v *viper.Viper) {
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+	bindEnvs(v, reflect.TypeOf(Config{}), "")
+}
+
+// signing proxy for the endpoints the client doesn't wrap. Allow lists the
+// forwarded requests as "METHOD /v1.0/path" patterns, where * matches a path
+// segment, a trailing /** any sub path and the * method any method. Nothing
+// is forwarded when it is empty.
+type Proxy struct {
+	Enabled bool
+	Allow   []string
+}
+
+// Token is required as "Authorization: Bearer <Token>" by the admin endpoints,
+// which are disabled while it is empty
+type Admin struct {
+	Token string `secret:"true"`
+}
+
+// Load reads and parses the config file, as shared by the binaries
+func Load(filename string) (*Config, error) {
+	v, err := LoadConfig(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(v)
+}
+
+// UseProject replaces the Tuya credentials with those of a project of Projects
+func (c *Config) UseProject(name string) error {
+	project, ok := c.Projects[strings.ToLower(name)]
+	if !ok {
+		names := make([]string, 0, len(c.Projects))
+		for key := range c.Projects {
+			names = append(names, key)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("project %q not found, configured projects: %v", name, names)
+	}
+	c.Tuya = project
+	return nil
+}
+
 func ParseConfig(v *viper.Viper) (*Config, error) {
+	return parseConfig(v, true)
+}
+
+func parseConfig(v *viper.Viper, resolve bool) (*Config, error) {
 	var c Config
 
 	err := v.Unmarshal(&c)
@@ -59,6 +237,13 @@ func ParseConfig(v *viper.Viper) (*Config, error) {
 		log.Printf("Unable to decode into sturct, %v", err)
 		return nil, err
 	}
+	check := c.resolveSecrets
+	if !resolve {
+		check = c.checkSecrets
+	}
+	if err := check(); err != nil {
+		return nil, err
+	}
 
 	return &c, nil
 }
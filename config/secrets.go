@@ -0,0 +1,180 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	secretFilePrefix = "file://"
+	secretEnvPrefix  = "env:"
+	secretMask       = "******"
+)
+
+// ResolveSecret returns the value of a secret reference, file:///run/secrets/x
+// reads a file and env:NAME an environment variable, anything else is the
+// secret itself
+func ResolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, secretFilePrefix):
+		filename := strings.TrimPrefix(value, secretFilePrefix)
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return "", err
+		}
+		// secret files usually end with a newline
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case strings.HasPrefix(value, secretEnvPrefix):
+		name := strings.TrimPrefix(value, secretEnvPrefix)
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return secret, nil
+	default:
+		return value, nil
+	}
+}
+
+// CheckSecretReference checks the syntax of a secret reference without
+// reading the file or environment variable it names
+func CheckSecretReference(value string) error {
+	switch {
+	case strings.HasPrefix(value, secretFilePrefix):
+		if strings.TrimPrefix(value, secretFilePrefix) == "" {
+			return fmt.Errorf("%s names no file, such as file:///run/secrets/name", value)
+		}
+	case strings.HasPrefix(value, secretEnvPrefix):
+		if name := strings.TrimPrefix(value, secretEnvPrefix); !validEnvName(name) {
+			return fmt.Errorf("%q is not an environment variable name", name)
+		}
+	}
+	return nil
+}
+
+func validEnvName(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for _, r := range name {
+		if r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// checkSecrets checks the references of the fields tagged secret, leaving
+// them unresolved
+func (c *Config) checkSecrets() error {
+	errs := []error{}
+	walkSecrets(reflect.ValueOf(c).Elem(), "", func(key string, field reflect.Value) {
+		if err := CheckSecretReference(field.String()); err != nil {
+			errs = append(errs, &ValidationError{Key: key, Problem: err.Error()})
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// resolveSecrets replaces the references of the fields tagged secret with
+// their values
+func (c *Config) resolveSecrets() error {
+	errs := []error{}
+	walkSecrets(reflect.ValueOf(c).Elem(), "", func(key string, field reflect.Value) {
+		secret, err := ResolveSecret(field.String())
+		if err != nil {
+			errs = append(errs, &ValidationError{Key: key, Problem: err.Error()})
+			return
+		}
+		field.SetString(secret)
+	})
+	return errors.Join(errs...)
+}
+
+// Masked returns a copy of the config with the secrets masked, to log or dump it
+func (c *Config) Masked() *Config {
+	masked := *c
+	walkSecrets(reflect.ValueOf(&masked).Elem(), "", func(key string, field reflect.Value) {
+		if field.String() != "" {
+			field.SetString(secretMask)
+		}
+	})
+	return &masked
+}
+
+// String prints the config with the secrets masked
+func (c *Config) String() string {
+	return fmt.Sprintf("%+v", *c.Masked())
+}
+
+// walkSecrets calls fn with the key of every string field tagged secret. Maps
+// are replaced by copies so the values can be set, which keeps the maps of a
+// shallow copy of the config untouched.
+func walkSecrets(v reflect.Value, prefix string, fn func(key string, field reflect.Value)) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			key := configKey(prefix, field.Name)
+			if field.Type.Kind() == reflect.String && field.Tag.Get("secret") == "true" {
+				fn(key, v.Field(i))
+				continue
+			}
+			walkSecrets(v.Field(i), key, fn)
+		}
+	case reflect.Map:
+		if v.IsNil() || v.Type().Elem().Kind() != reflect.Struct {
+			return
+		}
+		copied := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(iter.Value())
+			walkSecrets(elem, configKey(prefix, fmt.Sprint(iter.Key().Interface())), fn)
+			copied.SetMapIndex(iter.Key(), elem)
+		}
+		v.Set(copied)
+	}
+}
+
+// configKey joins the keys as in the errors of Validate, the top level
+// sections are lower case as in the config files
+func configKey(prefix, name string) string {
+	if prefix == "" {
+		return strings.ToLower(name)
+	}
+	return prefix + "." + name
+}
+
+// bindEnvs binds every key of the config to its environment variable, so
+// TUYA_SECRET or LOCAL_TIMEOUT override nested keys even when they are
+// missing from the config file
+func bindEnvs(v *viper.Viper, t reflect.Type, prefix string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		key := strings.ToLower(field.Name)
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			bindEnvs(v, field.Type, key)
+		case reflect.Map:
+			// map entries are only read from the config file
+		default:
+			v.BindEnv(key)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(filename, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestCheckSecretReference(t *testing.T) {
+	for _, tt := range []struct {
+		value string
+		ok    bool
+	}{
+		{"plain-secret", true},
+		{"", true},
+		{"file:///run/secrets/tuya_secret", true},
+		{"file://", false},
+		{"env:TUYA_SECRET", true},
+		{"env:_private2", true},
+		{"env:", false},
+		{"env:1SECRET", false},
+		{"env:TUYA-SECRET", false},
+	} {
+		if err := CheckSecretReference(tt.value); (err == nil) != tt.ok {
+			t.Errorf("CheckSecretReference(%q) = %v, want ok %v", tt.value, err, tt.ok)
+		}
+	}
+}
+
+func TestLoadFileUnresolved(t *testing.T) {
+	filename := writeConfig(t, `
+tuya:
+  ClientId: env:CONFIG_TEST_UNSET_CLIENT_ID
+  Secret: file:///nonexistent/tuya_secret
+admin:
+  Token: env:1BAD
+`)
+	_, err := LoadFile(filename, false)
+	var problem *ValidationError
+	if !errors.As(err, &problem) || problem.Key != "admin.Token" {
+		t.Fatalf("LoadFile = %v, want only the admin.Token reference reported", err)
+	}
+
+	filename = writeConfig(t, `
+tuya:
+  ClientId: env:CONFIG_TEST_UNSET_CLIENT_ID
+  Secret: file:///nonexistent/tuya_secret
+`)
+	cfg, err := LoadFile(filename, false)
+	if err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+	if cfg.Tuya.ClientId != "env:CONFIG_TEST_UNSET_CLIENT_ID" || cfg.Tuya.Secret != "file:///nonexistent/tuya_secret" {
+		t.Fatalf("references resolved: %+v", cfg.Tuya)
+	}
+
+	if _, err := LoadFile(filename, true); err == nil {
+		t.Fatal("LoadFile resolved references to a missing file and variable")
+	}
+}
+
+func TestResolveSecret(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "tuya_secret")
+	if err := os.WriteFile(filename, []byte("from-file\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_TEST_SECRET", "from-env")
+
+	for _, tt := range []struct {
+		value string
+		want  string
+		ok    bool
+	}{
+		{"plain-secret", "plain-secret", true},
+		{"", "", true},
+		{"file://" + filename, "from-file", true},
+		{"file://" + filename + ".missing", "", false},
+		{"env:CONFIG_TEST_SECRET", "from-env", true},
+		{"env:CONFIG_TEST_UNSET_SECRET", "", false},
+	} {
+		got, err := ResolveSecret(tt.value)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ResolveSecret(%q) = %q, %v, want %q, ok %v", tt.value, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLoadFileResolvesSecrets(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "tuya_secret")
+	if err := os.WriteFile(secretFile, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_TEST_CLIENT_ID", "from-env")
+
+	filename := writeConfig(t, `
+tuya:
+  ClientId: env:CONFIG_TEST_CLIENT_ID
+  Secret: file://`+secretFile+`
+projects:
+  eu:
+    Secret: env:CONFIG_TEST_CLIENT_ID
+`)
+	cfg, err := LoadFile(filename, true)
+	if err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+	if cfg.Tuya.ClientId != "from-env" || cfg.Tuya.Secret != "from-file" || cfg.Projects["eu"].Secret != "from-env" {
+		t.Fatalf("secrets not resolved: %+v %+v", cfg.Tuya, cfg.Projects)
+	}
+}
+
+func TestEnvOverridesNestedKeys(t *testing.T) {
+	filename := writeConfig(t, `
+tuya:
+  Host: https://openapi.tuyain.com
+  Secret: from-file
+`)
+	t.Setenv("TUYA_SECRET", "from-env")
+	t.Setenv("LOCAL_TIMEOUT", "3s")
+	cfg, err := LoadFile(filename, true)
+	if err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+	if cfg.Tuya.Secret != "from-env" {
+		t.Fatalf("tuya.Secret = %q, want TUYA_SECRET", cfg.Tuya.Secret)
+	}
+	if cfg.Local.Timeout != 3*time.Second {
+		t.Fatalf("local.Timeout = %v, want LOCAL_TIMEOUT missing from the file", cfg.Local.Timeout)
+	}
+	if cfg.Tuya.Host != "https://openapi.tuyain.com" {
+		t.Fatalf("tuya.Host = %q, want the file value", cfg.Tuya.Host)
+	}
+}
+
+func TestMasked(t *testing.T) {
+	c := &Config{
+		Tuya:  Tuya{Host: "https://openapi.tuyain.com", ClientId: "client", Secret: "secret"},
+		Admin: Admin{Token: "token"},
+		Projects: map[string]Tuya{
+			"eu": {Host: "https://openapi.tuyaeu.com", Secret: "eu-secret"},
+		},
+	}
+
+	masked := c.Masked()
+	if masked.Tuya.ClientId != secretMask || masked.Tuya.Secret != secretMask || masked.Admin.Token != secretMask {
+		t.Fatalf("secrets not masked: %+v", masked)
+	}
+	if masked.Tuya.SecondarySecret != "" || masked.Projects["eu"].ClientId != "" {
+		t.Fatal("empty secrets masked")
+	}
+	if masked.Tuya.Host != c.Tuya.Host || masked.Projects["eu"].Secret != secretMask {
+		t.Fatalf("masked projects = %+v", masked.Projects)
+	}
+
+	if c.Tuya.Secret != "secret" || c.Admin.Token != "token" || c.Projects["eu"].Secret != "eu-secret" {
+		t.Fatalf("Masked changed the config: %+v", c)
+	}
+	if s := c.String(); strings.Contains(s, "Secret:secret") || strings.Contains(s, "eu-secret") || strings.Contains(s, "Token:token") {
+		t.Fatalf("String leaks a secret: %s", s)
+	}
+}
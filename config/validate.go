@@ -0,0 +1,186 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoggerLevels are the accepted Logger.Level values
+var LoggerLevels = []string{"debug", "info", "warn", "error", "dpanic", "panic", "fatal"}
+
+// ValidationError is a problem of one config key, such as tuya.Secret
+type ValidationError struct {
+	Key     string
+	Problem string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Key + ": " + e.Problem
+}
+
+type validator struct {
+	errs []error
+}
+
+func (v *validator) add(key, format string, args ...interface{}) {
+	v.errs = append(v.errs, &ValidationError{Key: key, Problem: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks the config and returns every problem found joined in one
+// error, each a *ValidationError naming the key
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	validateAddress(v, "server.Port", c.Server.Port)
+
+	if c.Logger.Level != "" && !contains(LoggerLevels, c.Logger.Level) {
+		v.add("logger.Level", "unknown level %q, one of %s", c.Logger.Level, strings.Join(LoggerLevels, ", "))
+	}
+	if c.Logger.Encoding != "" && c.Logger.Encoding != "json" && c.Logger.Encoding != "console" {
+		v.add("logger.Encoding", "unknown encoding %q, json or console", c.Logger.Encoding)
+	}
+
+	validateTuya(v, "tuya", c.Tuya)
+	projects := make([]string, 0, len(c.Projects))
+	for name := range c.Projects {
+		projects = append(projects, name)
+	}
+	sort.Strings(projects)
+	for _, name := range projects {
+		validateTuya(v, "projects."+name, c.Projects[name])
+	}
+
+	validateDuration(v, "camera.StreamTTL", c.Camera.StreamTTL)
+
+	if c.Events.Enabled {
+		validateUrl(v, "events.Url", c.Events.Url, "ws", "wss", "pulsar", "pulsar+ssl")
+		if c.Events.Env == "" {
+			v.add("events.Env", "must not be empty, event or event-test")
+		}
+	}
+	validateDuration(v, "events.AckTimeout", c.Events.AckTimeout)
+	validateDuration(v, "events.HeartbeatInterval", c.Events.HeartbeatInterval)
+	validateCount(v, "events.MaxRedeliveries", c.Events.MaxRedeliveries)
+	validateCount(v, "events.BufferSize", c.Events.BufferSize)
+
+	validateDuration(v, "poller.Interval", c.Poller.Interval)
+	validateCount(v, "poller.PageSize", c.Poller.PageSize)
+	if c.Poller.QPS < 0 {
+		v.add("poller.QPS", "must not be negative")
+	}
+
+	validateCount(v, "webhooks.Workers", c.Webhooks.Workers)
+	validateCount(v, "webhooks.MaxAttempts", c.Webhooks.MaxAttempts)
+	validateDuration(v, "webhooks.InitialBackoff", c.Webhooks.InitialBackoff)
+	validateDuration(v, "webhooks.Timeout", c.Webhooks.Timeout)
+	validateCount(v, "webhooks.DeadLetterSize", c.Webhooks.DeadLetterSize)
+
+	if c.Mqtt.Enabled {
+		validateUrl(v, "mqtt.Broker", c.Mqtt.Broker, "tcp", "ssl", "tls", "mqtt", "mqtts", "ws", "wss")
+	}
+	if c.Mqtt.Qos > 2 {
+		v.add("mqtt.Qos", "must be 0, 1 or 2")
+	}
+
+	validateDuration(v, "local.StaleAfter", c.Local.StaleAfter)
+	validateDuration(v, "local.ExpireAfter", c.Local.ExpireAfter)
+	validateDuration(v, "local.InventoryInterval", c.Local.InventoryInterval)
+	validateDuration(v, "local.Timeout", c.Local.Timeout)
+	validateDuration(v, "local.SkipFor", c.Local.SkipFor)
+
+	for i, rule := range c.Proxy.Allow {
+		key := fmt.Sprintf("proxy.Allow[%d]", i)
+		method, pattern, ok := strings.Cut(strings.TrimSpace(rule), " ")
+		pattern = strings.TrimSpace(pattern)
+		if !ok || method == "" || !strings.HasPrefix(pattern, "/") {
+			v.add(key, "%q is not in the form \"METHOD /path\"", rule)
+			continue
+		}
+		if _, err := path.Match(strings.TrimSuffix(pattern, "/**"), ""); err != nil {
+			v.add(key, "bad pattern %q: %v", pattern, err)
+		}
+	}
+
+	return errors.Join(v.errs...)
+}
+
+func validateTuya(v *validator, key string, tuya Tuya) {
+	validateUrl(v, key+".Host", tuya.Host, "http", "https")
+	if tuya.ClientId == "" {
+		v.add(key+".ClientId", "must not be empty")
+	}
+	if tuya.Secret == "" {
+		v.add(key+".Secret", "must not be empty")
+	}
+	if tuya.SecondarySecret != "" && tuya.SecondarySecret == tuya.Secret {
+		v.add(key+".SecondarySecret", "must differ from Secret")
+	}
+	validateDuration(v, key+".ClockSkewWarning", tuya.ClockSkewWarning)
+}
+
+// validateAddress checks a listen address such as :5000 or 127.0.0.1:5000
+func validateAddress(v *validator, key, address string) {
+	if address == "" {
+		v.add(key, "must not be empty, e.g. :5000")
+		return
+	}
+	if !strings.Contains(address, ":") {
+		v.add(key, "%q has no colon, use :%s to listen on all interfaces", address, address)
+		return
+	}
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		v.add(key, "%q is not a host:port address: %v", address, err)
+		return
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		v.add(key, "%q has an invalid port", address)
+	}
+}
+
+func validateUrl(v *validator, key, value string, schemes ...string) {
+	if value == "" {
+		v.add(key, "must not be empty")
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		v.add(key, "%q is not a url: %v", value, err)
+		return
+	}
+	if !contains(schemes, u.Scheme) {
+		v.add(key, "%q must start with %s://", value, strings.Join(schemes, ":// or "))
+		return
+	}
+	if u.Host == "" {
+		v.add(key, "%q has no host", value)
+	}
+}
+
+func validateDuration(v *validator, key string, d time.Duration) {
+	if d < 0 {
+		v.add(key, "must not be negative")
+	}
+}
+
+func validateCount(v *validator, key string, n int) {
+	if n < 0 {
+		v.add(key, "must not be negative")
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func validConfig() *Config {
+	return &Config{
+		Server: Server{Port: ":5000"},
+		Logger: Logger{Level: "info", Encoding: "json"},
+		Tuya:   Tuya{Host: "https://openapi.tuyain.com", ClientId: "client", Secret: "secret"},
+		Events: Events{Enabled: true, Url: "wss://mqe.tuyain.com:8285/", Env: "event"},
+		Mqtt:   Mqtt{Enabled: true, Broker: "tcp://localhost:1883", Qos: 1},
+		Proxy:  Proxy{Allow: []string{"GET /v1.0/devices/*/logs", "* /v2.0/cloud/thing/**"}},
+		Projects: map[string]Tuya{
+			"eu": {Host: "https://openapi.tuyaeu.com", ClientId: "eu-client", Secret: "eu-secret"},
+		},
+	}
+}
+
+// problemKeys returns the keys of the problems joined in err
+func problemKeys(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) {
+		t.Fatalf("Validate = %v, want joined problems", err)
+	}
+	keys := []string{}
+	for _, problem := range joined.Unwrap() {
+		var validationErr *ValidationError
+		if !errors.As(problem, &validationErr) {
+			t.Fatalf("problem %v is not a *ValidationError", problem)
+		}
+		keys = append(keys, validationErr.Key)
+	}
+	return keys
+}
+
+func TestValidate(t *testing.T) {
+	if err := validConfig().Validate(); err != nil {
+		t.Fatalf("Validate of a valid config: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		modify func(c *Config)
+		want   []string
+	}{
+		{"port without colon", func(c *Config) { c.Server.Port = "5000" }, []string{"server.Port"}},
+		{"port out of range", func(c *Config) { c.Server.Port = ":70000" }, []string{"server.Port"}},
+		{"empty port", func(c *Config) { c.Server.Port = "" }, []string{"server.Port"}},
+		{"unknown level", func(c *Config) { c.Logger.Level = "verbose" }, []string{"logger.Level"}},
+		{"unknown encoding", func(c *Config) { c.Logger.Encoding = "xml" }, []string{"logger.Encoding"}},
+		{"host scheme", func(c *Config) { c.Tuya.Host = "openapi.tuyain.com" }, []string{"tuya.Host"}},
+		{"same secondary", func(c *Config) { c.Tuya.SecondarySecret = "secret" }, []string{"tuya.SecondarySecret"}},
+		{"project", func(c *Config) { c.Projects["eu"] = Tuya{Host: "https://openapi.tuyaeu.com"} }, []string{"projects.eu.ClientId", "projects.eu.Secret"}},
+		{"events", func(c *Config) { c.Events.Url = "https://mqe.tuyain.com"; c.Events.Env = "" }, []string{"events.Url", "events.Env"}},
+		{"events disabled", func(c *Config) { c.Events = Events{} }, nil},
+		{"mqtt broker", func(c *Config) { c.Mqtt.Broker = "localhost:1883" }, []string{"mqtt.Broker"}},
+		{"mqtt qos", func(c *Config) { c.Mqtt.Qos = 3 }, []string{"mqtt.Qos"}},
+		{"negative values", func(c *Config) {
+			c.Local.Timeout = -time.Second
+			c.Poller.QPS = -1
+			c.Webhooks.Workers = -1
+		}, []string{"poller.QPS", "webhooks.Workers", "local.Timeout"}},
+		{"proxy rules", func(c *Config) {
+			c.Proxy.Allow = []string{"GET", "GET devices", "GET /v1.0/[", "GET /v1.0/devices"}
+		}, []string{"proxy.Allow[0]", "proxy.Allow[1]", "proxy.Allow[2]"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			tt.modify(c)
+			if got := problemKeys(t, c.Validate()); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("problems = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateCollectsEveryProblem(t *testing.T) {
+	got := problemKeys(t, (&Config{}).Validate())
+	want := []string{"server.Port", "tuya.Host", "tuya.ClientId", "tuya.Secret"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("problems of an empty config = %v, want %v", got, want)
+	}
+
+	var problem *ValidationError
+	if err := (&Config{}).Validate(); !errors.As(err, &problem) || problem.Error() != "server.Port: must not be empty, e.g. :5000" {
+		t.Fatalf("first problem = %v", problem)
+	}
+}
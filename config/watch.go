@@ -0,0 +1,175 @@
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch calls onChange with the config loaded again every time the file or
+// one of its file:// secrets changes, or onError when it doesn't load or
+// validate. The env: secrets are only read again along with the files.
+func Watch(filename string, onChange func(*Config), onError func(error)) error {
+	v, err := LoadConfig(filename)
+	if err != nil {
+		return err
+	}
+	secrets, err := newSecretWatcher()
+	if err != nil {
+		return err
+	}
+	if raw, err := parseConfig(v, false); err == nil {
+		secrets.watch(secretFiles(raw))
+	}
+
+	// the watched viper only notifies, the file is loaded again from scratch
+	// so read errors are reported instead of keeping the previous values
+	var mu sync.Mutex
+	reload := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		cfg, files, err := loadWithSecretFiles(filename)
+		if files != nil {
+			secrets.watch(files)
+		}
+		if err == nil {
+			err = cfg.Validate()
+		}
+		if err != nil {
+			onError(err)
+			return
+		}
+		onChange(cfg)
+	}
+	v.OnConfigChange(func(fsnotify.Event) { reload() })
+	v.WatchConfig()
+	go secrets.run(reload)
+	return nil
+}
+
+// loadWithSecretFiles loads the config and lists its file:// secrets, which
+// are listed as long as the file parses even when they don't resolve
+func loadWithSecretFiles(filename string) (*Config, []string, error) {
+	v, err := LoadConfig(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	raw, err := parseConfig(v, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg, err := parseConfig(v, true)
+	return cfg, secretFiles(raw), err
+}
+
+// secretFiles lists the files of the file:// secret references
+func secretFiles(c *Config) []string {
+	files := []string{}
+	walkSecrets(reflect.ValueOf(c).Elem(), "", func(key string, field reflect.Value) {
+		if filename, ok := strings.CutPrefix(field.String(), secretFilePrefix); ok {
+			files = append(files, filepath.Clean(filename))
+		}
+	})
+	return files
+}
+
+// secretWatcher watches the directories of the secret files, as mounted
+// secrets are usually replaced rather than written, such as the ..data
+// symlink swapped by Kubernetes
+type secretWatcher struct {
+	watcher *fsnotify.Watcher
+
+	mu    sync.Mutex
+	dirs  map[string]bool
+	files map[string]bool
+}
+
+func newSecretWatcher() (*secretWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &secretWatcher{watcher: watcher, dirs: map[string]bool{}, files: map[string]bool{}}, nil
+}
+
+// watch replaces the watched files, directories that can't be watched are
+// skipped, their secrets are still read on the next config change
+func (s *secretWatcher) watch(files []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.files = map[string]bool{}
+	dirs := map[string]bool{}
+	for _, filename := range files {
+		s.files[filename] = true
+		dirs[filepath.Dir(filename)] = true
+	}
+	for dir := range s.dirs {
+		if !dirs[dir] {
+			s.watcher.Remove(dir)
+			delete(s.dirs, dir)
+		}
+	}
+	for dir := range dirs {
+		if !s.dirs[dir] && s.watcher.Add(dir) == nil {
+			s.dirs[dir] = true
+		}
+	}
+}
+
+// changed tells whether an event in a watched directory may change a secret
+func (s *secretWatcher) changed(event fsnotify.Event) bool {
+	if event.Op == fsnotify.Chmod {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := filepath.Clean(event.Name)
+	return s.files[name] || strings.HasPrefix(filepath.Base(name), "..")
+}
+
+func (s *secretWatcher) run(reload func()) {
+	for {
+		select {
+		case event, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+			if s.changed(event) {
+				reload()
+			}
+		case _, ok := <-s.watcher.Errors:
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+// Changed lists the keys that differ between two configs, such as
+// server.Port, maps are compared as a whole
+func Changed(old, next *Config) []string {
+	return changedKeys(reflect.ValueOf(old).Elem(), reflect.ValueOf(next).Elem(), "")
+}
+
+func changedKeys(old, next reflect.Value, prefix string) []string {
+	keys := []string{}
+	for i := 0; i < old.NumField(); i++ {
+		field := old.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		key := configKey(prefix, field.Name)
+		if field.Type.Kind() == reflect.Struct {
+			keys = append(keys, changedKeys(old.Field(i), next.Field(i), key)...)
+			continue
+		}
+		if !reflect.DeepEqual(old.Field(i).Interface(), next.Field(i).Interface()) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChanged(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		modify func(c *Config)
+		want   []string
+	}{
+		{"unchanged", func(c *Config) {}, []string{}},
+		{"nested keys", func(c *Config) {
+			c.Server.Port = ":6000"
+			c.Logger.Level = "debug"
+			c.Tuya.Secret = "rotated"
+		}, []string{"server.Port", "logger.Level", "tuya.Secret"}},
+		{"slice", func(c *Config) { c.Proxy.Allow = append(c.Proxy.Allow, "GET /v1.0/users") }, []string{"proxy.Allow"}},
+		{"map as a whole", func(c *Config) {
+			c.Projects = map[string]Tuya{"eu": {Host: "https://openapi.tuyaeu.com", ClientId: "eu-client", Secret: "rotated"}}
+		}, []string{"projects"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			next := validConfig()
+			tt.modify(next)
+			if got := Changed(validConfig(), next); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Changed = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// chdir moves to dir for the test, as LoadConfig looks up the config in the
+// working directory
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestWatchSecretFile(t *testing.T) {
+	dir := t.TempDir()
+	secretDir := filepath.Join(dir, "secrets")
+	if err := os.Mkdir(secretDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	secretFile := filepath.Join(secretDir, "tuya_secret")
+	if err := os.WriteFile(secretFile, []byte("old-secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config := `
+server:
+  Port: ":5000"
+tuya:
+  Host: https://openapi.tuyain.com
+  ClientId: client
+  Secret: file://` + secretFile + `
+`
+	if err := os.WriteFile(filepath.Join(dir, "config.yml"), []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	chdir(t, dir)
+
+	changes := make(chan *Config, 10)
+	errs := make(chan error, 10)
+	if err := Watch("config", func(c *Config) { changes <- c }, func(err error) { errs <- err }); err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+
+	if err := os.WriteFile(secretFile, []byte("new-secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(5 * time.Second)
+	for reloaded := false; !reloaded; {
+		select {
+		case c := <-changes:
+			reloaded = c.Tuya.Secret == "new-secret"
+		case err := <-errs:
+			t.Logf("reload while the file is written: %v", err)
+		case <-timeout:
+			t.Fatal("the secret file change wasn't reloaded")
+		}
+	}
+
+	// a missing secret file is reported, the running config is kept
+	if err := os.Remove(secretFile); err != nil {
+		t.Fatal(err)
+	}
+	timeout = time.After(5 * time.Second)
+	for reported := false; !reported; {
+		select {
+		case err := <-errs:
+			reported = true
+			t.Log(err)
+		case c := <-changes:
+			// late events of the write
+			if c.Tuya.Secret != "new-secret" {
+				t.Fatalf("reloaded without the secret file: %+v", c.Tuya)
+			}
+		case <-timeout:
+			t.Fatal("the removed secret file wasn't reported")
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package fakecloud
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+)
+
+const tokenExpireTime = 7200
+
+// DataPoint describes a DP of a registered device, Values is the JSON range
+// of the specification
+type DataPoint struct {
+	Id     int
+	Code   string
+	Type   string
+	Values string
+}
+
+// Device is a device registered in the fake cloud. State and Set read and
+// write its DPs by id, so the cloud shares the state of a simulated device.
+type Device struct {
+	Info       tuya.Device
+	DataPoints []DataPoint
+	State      func() map[string]interface{}
+	Set        func(dps map[string]interface{})
+}
+
+// Server is a minimal stand-in for the Tuya OpenAPI serving the token and
+// device endpoints the middleware uses. Requests must carry the configured
+// client id, signatures are only verified once a secret is set.
+type Server struct {
+	clientId string
+	mux      *http.ServeMux
+	secret   atomic.Value
+
+	mu      sync.RWMutex
+	devices map[string]*Device
+}
+
+func NewServer(clientId string) *Server {
+	s := &Server{clientId: clientId, mux: http.NewServeMux(), devices: map[string]*Device{}}
+	s.mux.HandleFunc("GET /v1.0/token", s.token)
+	s.mux.HandleFunc("GET /v1.0/token/{refreshToken}", s.token)
+	s.mux.HandleFunc("GET /v1.0/devices", s.getDevices)
+	s.mux.HandleFunc("GET /v1.0/devices/{deviceId}", s.getDevice)
+	s.mux.HandleFunc("GET /v1.0/devices/{deviceId}/status", s.getDeviceStatus)
+	s.mux.HandleFunc("GET /v1.0/devices/{deviceId}/specifications", s.getSpecification)
+	s.mux.HandleFunc("POST /v1.0/devices/{deviceId}/commands", s.sendCommands)
+	s.mux.HandleFunc("GET /v2.0/cloud/thing/{deviceId}/shadow/properties", s.getProperties)
+	return s
+}
+
+// Register adds a device, or replaces the one with the same id
+func (s *Server) Register(device Device) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.devices[device.Info.Id] = &device
+}
+
+// SetSecret makes the signatures verified with secret, an empty one turns the
+// verification off. Changing it simulates a rotation on the Tuya side.
+func (s *Server) SetSecret(secret string) {
+	s.secret.Store(secret)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("client_id") != s.clientId {
+		writeError(w, 1005, "clientId is invalid")
+		return
+	}
+	if secret, _ := s.secret.Load().(string); secret != "" && !validSign(r, secret) {
+		writeError(w, 1004, "sign invalid")
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// validSign checks the sign header as the Tuya OpenAPI does
+func validSign(r *http.Request, secret string) bool {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return r.Header.Get("sign") == tuya.Sign(r, body, secret)
+}
+
+type response struct {
+	Success bool        `json:"success"`
+	Result  interface{} `json:"result,omitempty"`
+	Code    int         `json:"code,omitempty"`
+	Msg     string      `json:"msg,omitempty"`
+	T       int64       `json:"t"`
+}
+
+func writeResult(w http.ResponseWriter, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response{Success: true, Result: result, T: time.Now().UnixMilli()})
+}
+
+// errors are reported with status 200 like the real API
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response{Code: code, Msg: msg, T: time.Now().UnixMilli()})
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	writeResult(w, tuya.Token{
+		AccessToken:  newToken(),
+		ExpireTime:   tokenExpireTime,
+		RefreshToken: newToken(),
+		UID:          "fakecloud",
+	})
+}
+
+func (s *Server) device(r *http.Request) (*Device, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	device, ok := s.devices[r.PathValue("deviceId")]
+	return device, ok
+}
+
+// status maps the DPs of a device to codes
+func status(device *Device) []tuya.DeviceStatus {
+	state := device.State()
+	status := make([]tuya.DeviceStatus, 0, len(device.DataPoints))
+	for _, dp := range device.DataPoints {
+		if value, ok := state[strconv.Itoa(dp.Id)]; ok {
+			status = append(status, tuya.DeviceStatus{Code: dp.Code, Value: value})
+		}
+	}
+	return status
+}
+
+func info(device *Device) tuya.Device {
+	d := device.Info
+	d.Online = true
+	d.Status = status(device)
+	return d
+}
+
+func (s *Server) getDevices(w http.ResponseWriter, r *http.Request) {
+	pageNo, _ := strconv.Atoi(r.URL.Query().Get("page_no"))
+	pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+	if pageNo <= 0 {
+		pageNo = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 20
+	}
+
+	s.mu.RLock()
+	ids := make([]string, 0, len(s.devices))
+	for id := range s.devices {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	devices := []tuya.Device{}
+	for i := (pageNo - 1) * pageSize; i < len(ids) && i < pageNo*pageSize; i++ {
+		devices = append(devices, info(s.devices[ids[i]]))
+	}
+	s.mu.RUnlock()
+
+	result := tuya.DevicesResult{Total: int64(len(ids)), Devices: devices}
+	if len(devices) > 0 {
+		result.LastId = devices[len(devices)-1].Id
+	}
+	writeResult(w, result)
+}
+
+func (s *Server) getDevice(w http.ResponseWriter, r *http.Request) {
+	device, ok := s.device(r)
+	if !ok {
+		writeError(w, 2001, "device is offline")
+		return
+	}
+	writeResult(w, info(device))
+}
+
+func (s *Server) getDeviceStatus(w http.ResponseWriter, r *http.Request) {
+	device, ok := s.device(r)
+	if !ok {
+		writeError(w, 2001, "device is offline")
+		return
+	}
+	writeResult(w, status(device))
+}
+
+func (s *Server) getSpecification(w http.ResponseWriter, r *http.Request) {
+	device, ok := s.device(r)
+	if !ok {
+		writeError(w, 2001, "device is offline")
+		return
+	}
+	spec := tuya.Specification{Category: device.Info.Category}
+	for _, dp := range device.DataPoints {
+		item := tuya.SpecificationItem{Code: dp.Code, Type: dp.Type, Values: dp.Values}
+		spec.Functions = append(spec.Functions, item)
+		spec.Status = append(spec.Status, item)
+	}
+	writeResult(w, spec)
+}
+
+func (s *Server) getProperties(w http.ResponseWriter, r *http.Request) {
+	device, ok := s.device(r)
+	if !ok {
+		writeError(w, 2001, "device is offline")
+		return
+	}
+	state := device.State()
+	properties := tuya.DeviceProperties{Properties: []tuya.DeviceProperty{}}
+	for _, dp := range device.DataPoints {
+		properties.Properties = append(properties.Properties, tuya.DeviceProperty{
+			Code:  dp.Code,
+			DpId:  dp.Id,
+			Time:  time.Now().UnixMilli(),
+			Type:  dp.Type,
+			Value: state[strconv.Itoa(dp.Id)],
+		})
+	}
+	writeResult(w, properties)
+}
+
+func (s *Server) sendCommands(w http.ResponseWriter, r *http.Request) {
+	device, ok := s.device(r)
+	if !ok {
+		writeError(w, 2001, "device is offline")
+		return
+	}
+	req := struct {
+		Commands []tuya.Command `json:"commands"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Commands) == 0 {
+		writeError(w, 1109, "param is illegal")
+		return
+	}
+
+	dps := map[string]interface{}{}
+	for _, command := range req.Commands {
+		found := false
+		for _, dp := range device.DataPoints {
+			if dp.Code == command.Code {
+				dps[strconv.Itoa(dp.Id)] = command.Value
+				found = true
+			}
+		}
+		if !found {
+			writeError(w, 2008, "command or value not support")
+			return
+		}
+	}
+	device.Set(dps)
+	writeResult(w, true)
+}
@@ -0,0 +1,355 @@
+package mqttbridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/internal/router"
+	"github.com/varjangn/tuya-middleware/pkg/logger"
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+	"github.com/varjangn/tuya-middleware/pkg/tuya/events"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultTopicPrefix = "tuya"
+	defaultClientId    = "tuya-middleware"
+	defaultPageSize    = 100
+	publishTimeout     = 10 * time.Second
+	// set messages waiting for the command worker, more are dropped
+	setQueueSize = 64
+
+	availabilityOnline  = "online"
+	availabilityOffline = "offline"
+)
+
+// DeviceClient is the part of TuyaClient the bridge needs
+type DeviceClient interface {
+	GetDevices(pageNo, pageSize int, queryParams map[string]string) (*tuya.DevicesResult, error)
+	GetDeviceSpecification(deviceId string) (*tuya.Specification, error)
+}
+
+// Commander sends the set commands, router.Router implements it so they go
+// over the LAN first
+type Commander interface {
+	SendCommands(ctx context.Context, deviceId string, commands []tuya.Command) (*router.CommandResult, error)
+}
+
+// Bridge publishes device state to an MQTT broker and forwards commands
+// published on the set topics to the devices, over the LAN or the cloud.
+//
+// Topics, relative to the configured prefix:
+//
+//	<prefix>/<device_id>/state/<code>  retained JSON value of a DP
+//	<prefix>/<device_id>/availability  retained "online" or "offline"
+//	<prefix>/<device_id>/set/<code>    JSON value to send to a DP
+//	<prefix>/bridge/availability       bridge status, "offline" as last will
+type Bridge struct {
+	logger    *logger.AppLogger
+	cfg       *config.Mqtt
+	client    DeviceClient
+	commander Commander
+	bus       *events.Bus
+	conn      mqtt.Client
+	// set messages are forwarded by one worker, in the order received, so
+	// a slow SendCommands doesn't hold the paho message handler
+	sets chan setMessage
+
+	mu sync.Mutex
+	// specifications per product id, devices of a product share it
+	specs map[string]*tuya.Specification
+	// discovery topics published per device, cleared when it is removed
+	discovered map[string][]string
+}
+
+func NewBridge(logger *logger.AppLogger, cfg *config.Config, client DeviceClient, commander Commander, bus *events.Bus) *Bridge {
+	return &Bridge{
+		logger:     logger,
+		cfg:        &cfg.Mqtt,
+		client:     client,
+		commander:  commander,
+		bus:        bus,
+		sets:       make(chan setMessage, setQueueSize),
+		specs:      map[string]*tuya.Specification{},
+		discovered: map[string][]string{},
+	}
+}
+
+func (b *Bridge) prefix() string {
+	if b.cfg.TopicPrefix == "" {
+		return defaultTopicPrefix
+	}
+	return strings.TrimSuffix(b.cfg.TopicPrefix, "/")
+}
+
+func (b *Bridge) StateTopic(deviceId, code string) string {
+	return fmt.Sprintf("%s/%s/state/%s", b.prefix(), deviceId, code)
+}
+
+func (b *Bridge) AvailabilityTopic(deviceId string) string {
+	return fmt.Sprintf("%s/%s/availability", b.prefix(), deviceId)
+}
+
+func (b *Bridge) SetTopic(deviceId, code string) string {
+	return fmt.Sprintf("%s/%s/set/%s", b.prefix(), deviceId, code)
+}
+
+func (b *Bridge) BridgeAvailabilityTopic() string {
+	return b.prefix() + "/bridge/availability"
+}
+
+// Run connects to the broker, publishes the current state of every device
+// and keeps it up to date from the event bus until ctx is done
+func (b *Bridge) Run(ctx context.Context) error {
+	clientId := b.cfg.ClientId
+	if clientId == "" {
+		clientId = defaultClientId
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(b.cfg.Broker).
+		SetClientID(clientId).
+		SetUsername(b.cfg.Username).
+		SetPassword(b.cfg.Password).
+		SetAutoReconnect(true).
+		SetWill(b.BridgeAvailabilityTopic(), availabilityOffline, b.cfg.Qos, true).
+		SetOnConnectHandler(b.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			b.logger.Warnw("mqtt_connection_lost", zap.String("error", err.Error()))
+		})
+
+	b.conn = mqtt.NewClient(opts)
+	token := b.conn.Connect()
+	token.Wait()
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("mqtt connect: %w", err)
+	}
+	defer b.conn.Disconnect(250)
+	go b.setWorker(ctx)
+
+	if err := b.SyncDevices(); err != nil {
+		b.logger.Warnw("mqtt_sync_err", zap.String("error", err.Error()))
+	}
+
+	var lastId uint64
+	for {
+		sub, backlog := b.bus.Subscribe(lastId, nil)
+		for _, record := range backlog {
+			b.HandleEvent(record.Event)
+			lastId = record.Id
+		}
+	loop:
+		for {
+			select {
+			case <-ctx.Done():
+				sub.Close()
+				b.publish(b.BridgeAvailabilityTopic(), availabilityOffline)
+				return ctx.Err()
+			case record, ok := <-sub.C:
+				if !ok {
+					b.logger.Warn("mqtt bridge fell behind the event bus, resuming")
+					break loop
+				}
+				b.HandleEvent(record.Event)
+				lastId = record.Id
+			}
+		}
+	}
+}
+
+// onConnect (re)subscribes to the set topics, it runs after every reconnect
+func (b *Bridge) onConnect(conn mqtt.Client) {
+	b.logger.Infof("connected to mqtt broker %s", b.cfg.Broker)
+	b.publish(b.BridgeAvailabilityTopic(), availabilityOnline)
+
+	token := conn.Subscribe(b.SetTopic("+", "+"), b.cfg.Qos, b.onSetMessage)
+	if token.WaitTimeout(publishTimeout) && token.Error() != nil {
+		b.logger.Errorw("mqtt_subscribe_err", zap.String("error", token.Error().Error()))
+	}
+}
+
+// SyncDevices pages through every device and publishes its state and
+// availability, and its discovery configs when discovery is enabled
+func (b *Bridge) SyncDevices() error {
+	seen := 0
+	for pageNo := 1; ; pageNo++ {
+		page, err := b.client.GetDevices(pageNo, defaultPageSize, map[string]string{})
+		if err != nil {
+			return err
+		}
+		for _, device := range page.Devices {
+			if b.cfg.Discovery.Enabled {
+				b.publishDiscovery(device)
+			}
+			b.publishAvailability(device.Id, device.Online)
+			for _, status := range device.Status {
+				b.publishState(device.Id, status.Code, status.Value)
+			}
+		}
+		seen += len(page.Devices)
+		if len(page.Devices) < defaultPageSize || int64(seen) >= page.Total {
+			return nil
+		}
+	}
+}
+
+// HandleEvent publishes the state changes carried by an event
+func (b *Bridge) HandleEvent(event events.Event) {
+	deviceId := event.Meta().DeviceId
+	switch e := event.(type) {
+	case *events.StatusReportEvent:
+		for _, status := range e.Status {
+			b.publishState(deviceId, status.Code, status.Value)
+		}
+	case *events.DpChangedEvent:
+		b.publishState(deviceId, e.Code, e.NewValue)
+	case *events.OnlineEvent, *events.WentOnlineEvent:
+		b.publishAvailability(deviceId, true)
+	case *events.OfflineEvent, *events.WentOfflineEvent:
+		b.publishAvailability(deviceId, false)
+	case *events.DeleteEvent, *events.RemovedEvent:
+		// empty retained messages clear the retained availability and discovery configs
+		b.publishRaw(b.AvailabilityTopic(deviceId), []byte{})
+		b.mu.Lock()
+		topics := b.discovered[deviceId]
+		delete(b.discovered, deviceId)
+		b.mu.Unlock()
+		for _, topic := range topics {
+			b.publishRaw(topic, []byte{})
+		}
+	}
+}
+
+func (b *Bridge) specification(device tuya.Device) (*tuya.Specification, error) {
+	b.mu.Lock()
+	spec, ok := b.specs[device.ProductId]
+	b.mu.Unlock()
+	if ok {
+		return spec, nil
+	}
+
+	spec, err := b.client.GetDeviceSpecification(device.Id)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	b.specs[device.ProductId] = spec
+	b.mu.Unlock()
+	return spec, nil
+}
+
+func (b *Bridge) publishDiscovery(device tuya.Device) {
+	spec, err := b.specification(device)
+	if err != nil {
+		b.logger.Warnw("mqtt_discovery_err",
+			zap.String("device_id", device.Id),
+			zap.String("error", err.Error()))
+		return
+	}
+
+	messages := b.DiscoveryMessages(device, spec)
+	topics := make([]string, 0, len(messages))
+	for _, message := range messages {
+		b.publishRaw(message.Topic, message.Payload)
+		topics = append(topics, message.Topic)
+	}
+	b.mu.Lock()
+	b.discovered[device.Id] = topics
+	b.mu.Unlock()
+}
+
+type setMessage struct {
+	topic   string
+	payload []byte
+}
+
+// onSetMessage queues a set message for the worker, paho delivers the
+// messages one at a time so the handler must not block
+func (b *Bridge) onSetMessage(_ mqtt.Client, msg mqtt.Message) {
+	select {
+	case b.sets <- setMessage{topic: msg.Topic(), payload: msg.Payload()}:
+	default:
+		b.logger.Warnf("mqtt command queue full, dropping message on %s", msg.Topic())
+	}
+}
+
+func (b *Bridge) setWorker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-b.sets:
+			b.HandleSet(ctx, msg.topic, msg.payload)
+		}
+	}
+}
+
+// HandleSet forwards a message published on a set topic to the device
+func (b *Bridge) HandleSet(ctx context.Context, topic string, payload []byte) {
+	parts := strings.Split(strings.TrimPrefix(topic, b.prefix()+"/"), "/")
+	if len(parts) != 3 || parts[1] != "set" {
+		b.logger.Warnf("mqtt ignoring message on %s", topic)
+		return
+	}
+	deviceId, code := parts[0], parts[2]
+
+	// payloads are JSON values, anything else is sent as a string
+	var value interface{}
+	if err := json.Unmarshal(payload, &value); err != nil {
+		value = string(payload)
+	}
+
+	result, err := b.commander.SendCommands(ctx, deviceId, []tuya.Command{{Code: code, Value: value}})
+	if err != nil {
+		b.logger.Errorw("mqtt_command_err",
+			zap.String("device_id", deviceId),
+			zap.String("code", code),
+			zap.String("error", err.Error()))
+		return
+	}
+	b.logger.Debugf("mqtt command %s of %s sent over the %s path", code, deviceId, result.Path)
+}
+
+func (b *Bridge) publishState(deviceId, code string, value interface{}) {
+	// JSON DPs such as colour_data_v2 are reported as JSON strings, they are
+	// published as JSON objects so templates can use value_json
+	if str, ok := value.(string); ok && strings.HasPrefix(str, "{") && json.Valid([]byte(str)) {
+		b.publishRaw(b.StateTopic(deviceId, code), []byte(str))
+		return
+	}
+	payload, err := json.Marshal(value)
+	if err != nil {
+		b.logger.Errorw("json_encode_err", zap.String("error", err.Error()))
+		return
+	}
+	b.publishRaw(b.StateTopic(deviceId, code), payload)
+}
+
+func (b *Bridge) publishAvailability(deviceId string, online bool) {
+	availability := availabilityOffline
+	if online {
+		availability = availabilityOnline
+	}
+	b.publish(b.AvailabilityTopic(deviceId), availability)
+}
+
+func (b *Bridge) publish(topic, payload string) {
+	b.publishRaw(topic, []byte(payload))
+}
+
+// publishRaw publishes a retained message
+func (b *Bridge) publishRaw(topic string, payload []byte) {
+	token := b.conn.Publish(topic, b.cfg.Qos, true, payload)
+	if !token.WaitTimeout(publishTimeout) {
+		b.logger.Warnf("mqtt publish to %s timed out", topic)
+		return
+	}
+	if err := token.Error(); err != nil {
+		b.logger.Errorw("mqtt_publish_err", zap.String("topic", topic), zap.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,340 @@
+package mqttbridge
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/internal/router"
+	"github.com/varjangn/tuya-middleware/pkg/logger"
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+	"github.com/varjangn/tuya-middleware/pkg/tuya/events"
+	"github.com/varjangn/tuya-middleware/pkg/tuyalocal"
+)
+
+const testDeviceId = "bf1234567890abcdef"
+
+// fakeDevices serves one plug and blocks its first command until release
+// is closed
+type fakeDevices struct {
+	entered chan struct{}
+	release chan struct{}
+
+	mu       sync.Mutex
+	commands []tuya.Command
+}
+
+func newFakeDevices() *fakeDevices {
+	return &fakeDevices{entered: make(chan struct{}, 1), release: make(chan struct{})}
+}
+
+func (f *fakeDevices) GetDevices(pageNo, pageSize int, queryParams map[string]string) (*tuya.DevicesResult, error) {
+	return &tuya.DevicesResult{Total: 1, Devices: []tuya.Device{{
+		Id:        testDeviceId,
+		Name:      "plug",
+		Category:  "cz",
+		ProductId: "p1",
+		Online:    true,
+		Status:    []tuya.DeviceStatus{{Code: "switch_1", Value: true}},
+	}}}, nil
+}
+
+func (f *fakeDevices) GetDeviceSpecification(deviceId string) (*tuya.Specification, error) {
+	return &tuya.Specification{Category: "cz", Functions: []tuya.SpecificationItem{
+		{Code: "switch_1", Type: "Boolean", Values: "{}"},
+		{Code: "switch_2", Type: "Boolean", Values: "{}"},
+	}}, nil
+}
+
+func (f *fakeDevices) SendCommands(ctx context.Context, deviceId string, commands []tuya.Command) (*router.CommandResult, error) {
+	select {
+	case f.entered <- struct{}{}:
+		<-f.release
+	default:
+	}
+	f.mu.Lock()
+	f.commands = append(f.commands, commands...)
+	f.mu.Unlock()
+	return &router.CommandResult{Path: router.PathCloud, Success: true}, nil
+}
+
+func (f *fakeDevices) sent() []tuya.Command {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]tuya.Command(nil), f.commands...)
+}
+
+// testBroker is an embedded broker recording the last payload of every topic
+type testBroker struct {
+	server *mochi.Server
+	addr   string
+
+	mu       sync.Mutex
+	messages map[string]string
+	changed  chan struct{}
+}
+
+func startBroker(t *testing.T) *testBroker {
+	t.Helper()
+	server := mochi.New(&mochi.Options{
+		InlineClient: true,
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+	tcp := listeners.NewTCP("tcp", "127.0.0.1:0", nil)
+	if err := server.AddListener(tcp); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	broker := &testBroker{server: server, addr: "tcp://" + tcp.Address(), messages: map[string]string{}, changed: make(chan struct{}, 1)}
+	err := server.Subscribe("#", 1, func(_ *mochi.Client, _ packets.Subscription, pk packets.Packet) {
+		broker.mu.Lock()
+		broker.messages[pk.TopicName] = string(pk.Payload)
+		broker.mu.Unlock()
+		select {
+		case broker.changed <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return broker
+}
+
+// waitFor waits until topic was last published with payload
+func (b *testBroker) waitFor(t *testing.T, topic, payload string, timeout time.Duration) {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		b.mu.Lock()
+		got, ok := b.messages[topic]
+		b.mu.Unlock()
+		if ok && got == payload {
+			return
+		}
+		select {
+		case <-b.changed:
+		case <-deadline:
+			t.Fatalf("%s = %q (published %v), want %q", topic, got, ok, payload)
+		}
+	}
+}
+
+// publishUntil publishes event on the bus until topic shows payload, the
+// bridge only subscribes to the bus once it synced the devices
+func (b *testBroker) publishUntil(t *testing.T, bus *events.Bus, event events.Event, topic, payload string, timeout time.Duration) {
+	t.Helper()
+	deadline := time.After(timeout)
+	tick := time.NewTicker(50 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		bus.Publish(event)
+		select {
+		case <-tick.C:
+		case <-deadline:
+			b.mu.Lock()
+			got := b.messages[topic]
+			b.mu.Unlock()
+			t.Fatalf("%s = %q, want %q", topic, got, payload)
+		}
+		b.mu.Lock()
+		got := b.messages[topic]
+		b.mu.Unlock()
+		if got == payload {
+			return
+		}
+	}
+}
+
+func startBridge(t *testing.T, broker *testBroker, devices *fakeDevices, bus *events.Bus) *Bridge {
+	t.Helper()
+	cfg := &config.Config{
+		Logger: config.Logger{Level: "fatal", Encoding: "console"},
+		Mqtt: config.Mqtt{
+			Broker:    broker.addr,
+			Qos:       1,
+			Discovery: config.Discovery{Enabled: true},
+		},
+	}
+	appLogger := logger.NewAppLogger(cfg)
+	appLogger.InitLogger()
+	bridge := NewBridge(appLogger, cfg, devices, devices, bus)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		bridge.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return bridge
+}
+
+func TestBridgePublishesDevices(t *testing.T) {
+	broker := startBroker(t)
+	bus := events.NewBus(16)
+	bridge := startBridge(t, broker, newFakeDevices(), bus)
+
+	broker.waitFor(t, bridge.BridgeAvailabilityTopic(), availabilityOnline, 5*time.Second)
+	broker.waitFor(t, bridge.AvailabilityTopic(testDeviceId), availabilityOnline, time.Second)
+	broker.waitFor(t, bridge.StateTopic(testDeviceId, "switch_1"), "true", time.Second)
+	broker.mu.Lock()
+	_, ok := broker.messages["homeassistant/switch/"+testDeviceId+"/switch_2/config"]
+	broker.mu.Unlock()
+	if !ok {
+		t.Fatal("no discovery config published for switch_2")
+	}
+
+	changed := &events.DpChangedEvent{EventMeta: events.EventMeta{DeviceId: testDeviceId}, Code: "switch_1", NewValue: false}
+	broker.publishUntil(t, bus, changed, bridge.StateTopic(testDeviceId, "switch_1"), "false", time.Second)
+	offline := &events.OfflineEvent{EventMeta: events.EventMeta{DeviceId: testDeviceId}}
+	broker.publishUntil(t, bus, offline, bridge.AvailabilityTopic(testDeviceId), availabilityOffline, time.Second)
+}
+
+func TestBridgeSlowCommandDoesNotBlock(t *testing.T) {
+	broker := startBroker(t)
+	bus := events.NewBus(16)
+	devices := newFakeDevices()
+	bridge := startBridge(t, broker, devices, bus)
+	broker.waitFor(t, bridge.StateTopic(testDeviceId, "switch_1"), "true", 5*time.Second)
+
+	broker.server.Publish(bridge.SetTopic(testDeviceId, "switch_1"), []byte("false"), false, 0)
+	select {
+	case <-devices.entered:
+	case <-time.After(time.Second):
+		t.Fatal("set message not forwarded")
+	}
+
+	// the first command is still pending, the bridge keeps receiving and publishing
+	for i := 0; i < 20; i++ {
+		broker.server.Publish(bridge.SetTopic(testDeviceId, "switch_2"), []byte("true"), false, 0)
+	}
+	changed := &events.DpChangedEvent{EventMeta: events.EventMeta{DeviceId: testDeviceId}, Code: "switch_1", NewValue: false}
+	broker.publishUntil(t, bus, changed, bridge.StateTopic(testDeviceId, "switch_1"), "false", 2*time.Second)
+
+	close(devices.release)
+	deadline := time.Now().Add(time.Second)
+	for len(devices.sent()) < 21 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	sent := devices.sent()
+	if len(sent) != 21 || sent[0].Code != "switch_1" || sent[0].Value != false || sent[20].Code != "switch_2" || sent[20].Value != true {
+		t.Fatalf("commands = %+v, want switch_1 false then switch_2 true", sent)
+	}
+}
+
+// fakeMessage is a set message as paho delivers it
+type fakeMessage struct {
+	mqtt.Message
+	topic   string
+	payload []byte
+}
+
+func (m fakeMessage) Topic() string   { return m.topic }
+func (m fakeMessage) Payload() []byte { return m.payload }
+
+func TestOnSetMessageDoesNotBlock(t *testing.T) {
+	cfg := &config.Config{Logger: config.Logger{Level: "fatal", Encoding: "console"}}
+	appLogger := logger.NewAppLogger(cfg)
+	appLogger.InitLogger()
+	devices := newFakeDevices()
+	bridge := NewBridge(appLogger, cfg, devices, devices, events.NewBus(16))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go bridge.setWorker(ctx)
+
+	message := fakeMessage{topic: bridge.SetTopic(testDeviceId, "switch_1"), payload: []byte("true")}
+	bridge.onSetMessage(nil, message)
+	select {
+	case <-devices.entered:
+	case <-time.After(time.Second):
+		t.Fatal("set message not forwarded")
+	}
+
+	// the first command is pending, the next ones fill the queue then are dropped
+	returned := make(chan struct{})
+	go func() {
+		defer close(returned)
+		for i := 0; i < setQueueSize+10; i++ {
+			bridge.onSetMessage(nil, message)
+		}
+	}()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("onSetMessage blocked on the pending command")
+	}
+
+	close(devices.release)
+	deadline := time.Now().Add(time.Second)
+	for len(devices.sent()) < setQueueSize+1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if sent := len(devices.sent()); sent != setQueueSize+1 {
+		t.Fatalf("%d commands sent, want %d", sent, setQueueSize+1)
+	}
+}
+
+// cloudDevices is the cloud behind a router, recording the commands sent
+type cloudDevices struct {
+	mu       sync.Mutex
+	commands []tuya.Command
+}
+
+func (c *cloudDevices) GetDeviceStatus(deviceId string) ([]tuya.DeviceStatus, error) {
+	return nil, nil
+}
+
+func (c *cloudDevices) SendCommands(deviceId string, commands []tuya.Command) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.commands = append(c.commands, commands...)
+	return true, nil
+}
+
+func (c *cloudDevices) GetDeviceProperties(deviceId string) ([]tuya.DeviceProperty, error) {
+	return nil, nil
+}
+
+// noLocalDevices is a LAN where no device was heard
+type noLocalDevices struct{}
+
+func (noLocalDevices) Device(id string) (tuyalocal.LocalDevice, bool) {
+	return tuyalocal.LocalDevice{}, false
+}
+
+func TestHandleSetGoesThroughTheRouter(t *testing.T) {
+	cfg := &config.Config{Logger: config.Logger{Level: "fatal", Encoding: "console"}}
+	appLogger := logger.NewAppLogger(cfg)
+	appLogger.InitLogger()
+	cloud := &cloudDevices{}
+	r := router.NewRouter(appLogger, cfg, cloud, noLocalDevices{})
+	bridge := NewBridge(appLogger, cfg, newFakeDevices(), r, events.NewBus(16))
+
+	bridge.HandleSet(context.Background(), bridge.SetTopic(testDeviceId, "switch_1"), []byte("true"))
+	if len(cloud.commands) != 1 || cloud.commands[0].Code != "switch_1" || cloud.commands[0].Value != true {
+		t.Fatalf("cloud commands = %+v", cloud.commands)
+	}
+	if health := r.Health()[testDeviceId]; health.Cloud.Successes != 1 {
+		t.Fatalf("router health = %+v, want the command routed", health)
+	}
+}
@@ -0,0 +1,346 @@
+package mqttbridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+	"go.uber.org/zap"
+)
+
+const defaultDiscoveryPrefix = "homeassistant"
+
+// Home Assistant entity components generated from Tuya categories
+const (
+	componentSwitch       = "switch"
+	componentLight        = "light"
+	componentSensor       = "sensor"
+	componentBinarySensor = "binary_sensor"
+	componentCover        = "cover"
+)
+
+// Tuya categories mapped to Home Assistant entities
+var (
+	switchCategories = map[string]bool{"kg": true, "cz": true, "pc": true, "tdq": true, "dlq": true}
+	lightCategories  = map[string]bool{"dj": true, "dd": true, "xdd": true, "fwd": true, "dc": true}
+	sensorCategories = map[string]bool{"wsdcg": true, "wnykq": true, "co2bj": true}
+)
+
+// sensor DP codes with their Home Assistant device class and unit
+var sensorCodes = []struct {
+	code        string
+	deviceClass string
+	unit        string
+}{
+	{"va_temperature", "temperature", "°C"},
+	{"temp_current", "temperature", "°C"},
+	{"va_humidity", "humidity", "%"},
+	{"humidity_value", "humidity", "%"},
+	{"co2_value", "carbon_dioxide", "ppm"},
+	{"battery_percentage", "battery", "%"},
+}
+
+// DiscoveryMessage is a retained Home Assistant discovery config
+type DiscoveryMessage struct {
+	Topic   string
+	Payload []byte
+}
+
+type integerValues struct {
+	Min   int    `json:"min"`
+	Max   int    `json:"max"`
+	Scale int    `json:"scale"`
+	Step  int    `json:"step"`
+	Unit  string `json:"unit"`
+}
+
+func (b *Bridge) discoveryPrefix() string {
+	if b.cfg.Discovery.Prefix == "" {
+		return defaultDiscoveryPrefix
+	}
+	return strings.TrimSuffix(b.cfg.Discovery.Prefix, "/")
+}
+
+// specItem returns the first data point of the specification matching one of
+// the codes, functions (writable) are preferred over status (read only)
+func specItem(spec *tuya.Specification, codes ...string) (tuya.SpecificationItem, bool) {
+	for _, code := range codes {
+		for _, item := range spec.Functions {
+			if item.Code == code {
+				return item, true
+			}
+		}
+		for _, item := range spec.Status {
+			if item.Code == code {
+				return item, true
+			}
+		}
+	}
+	return tuya.SpecificationItem{}, false
+}
+
+func parseIntegerValues(item tuya.SpecificationItem) integerValues {
+	values := integerValues{}
+	json.Unmarshal([]byte(item.Values), &values)
+	return values
+}
+
+// scaledTemplate renders an integer DP with its scale, e.g. 215 with scale 1 as 21.5
+func scaledTemplate(scale int) string {
+	if scale <= 0 {
+		return "{{ value | float }}"
+	}
+	return fmt.Sprintf("{{ (value | float / %g) | round(%d) }}", math.Pow10(scale), scale)
+}
+
+func humanize(code string) string {
+	words := strings.Split(code, "_")
+	for i, word := range words {
+		if word != "" {
+			words[i] = strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// entityBase holds the fields shared by every entity of a device
+func (b *Bridge) entityBase(device tuya.Device, objectId string) map[string]interface{} {
+	return map[string]interface{}{
+		"unique_id":          fmt.Sprintf("tuya_%s_%s", device.Id, objectId),
+		"object_id":          fmt.Sprintf("%s_%s", device.Id, objectId),
+		"availability_topic": b.AvailabilityTopic(device.Id),
+		"device": map[string]interface{}{
+			"identifiers":  []string{"tuya_" + device.Id},
+			"name":         device.Name,
+			"model":        device.ProductName,
+			"manufacturer": "Tuya",
+		},
+	}
+}
+
+func (b *Bridge) switchEntity(device tuya.Device, code, name string) map[string]interface{} {
+	entity := b.entityBase(device, code)
+	entity["name"] = name
+	entity["command_topic"] = b.SetTopic(device.Id, code)
+	entity["state_topic"] = b.StateTopic(device.Id, code)
+	entity["payload_on"] = "true"
+	entity["payload_off"] = "false"
+	entity["state_on"] = "true"
+	entity["state_off"] = "false"
+	return entity
+}
+
+func (b *Bridge) sensorEntity(device tuya.Device, code, name, deviceClass, unit string, scale int) map[string]interface{} {
+	entity := b.entityBase(device, code)
+	entity["name"] = name
+	entity["state_topic"] = b.StateTopic(device.Id, code)
+	entity["value_template"] = scaledTemplate(scale)
+	entity["state_class"] = "measurement"
+	if deviceClass != "" {
+		entity["device_class"] = deviceClass
+	}
+	if unit != "" {
+		entity["unit_of_measurement"] = unit
+	}
+	return entity
+}
+
+func (b *Bridge) binarySensorEntity(device tuya.Device, code, name, deviceClass, onValue string) map[string]interface{} {
+	entity := b.entityBase(device, code)
+	entity["name"] = name
+	entity["state_topic"] = b.StateTopic(device.Id, code)
+	// states are JSON encoded, so string values arrive quoted
+	entity["value_template"] = fmt.Sprintf("{{ 'ON' if value_json == %s else 'OFF' }}", onValue)
+	if deviceClass != "" {
+		entity["device_class"] = deviceClass
+	}
+	return entity
+}
+
+func (b *Bridge) lightEntity(device tuya.Device, spec *tuya.Specification) (map[string]interface{}, bool) {
+	power, ok := specItem(spec, "switch_led", "switch_led_1", "switch")
+	if !ok {
+		return nil, false
+	}
+	entity := b.switchEntity(device, power.Code, "")
+	entity["unique_id"] = fmt.Sprintf("tuya_%s_light", device.Id)
+	entity["object_id"] = fmt.Sprintf("%s_light", device.Id)
+	// a null name uses the device name
+	entity["name"] = nil
+	delete(entity, "state_on")
+	delete(entity, "state_off")
+
+	if bright, ok := specItem(spec, "bright_value_v2", "bright_value", "bright_value_1"); ok {
+		values := parseIntegerValues(bright)
+		entity["brightness_command_topic"] = b.SetTopic(device.Id, bright.Code)
+		entity["brightness_state_topic"] = b.StateTopic(device.Id, bright.Code)
+		if values.Max > 0 {
+			entity["brightness_scale"] = values.Max
+		}
+	}
+	if temp, ok := specItem(spec, "temp_value_v2", "temp_value"); ok {
+		// tuya uses 0 (warm) to max (cold), home assistant uses mireds 500 (warm) to 153 (cold)
+		max := parseIntegerValues(temp).Max
+		if max <= 0 {
+			max = 1000
+		}
+		entity["color_temp_command_topic"] = b.SetTopic(device.Id, temp.Code)
+		entity["color_temp_state_topic"] = b.StateTopic(device.Id, temp.Code)
+		entity["color_temp_command_template"] = fmt.Sprintf("{{ ((500 - value) / 347 * %d) | round(0) | int }}", max)
+		entity["color_temp_value_template"] = fmt.Sprintf("{{ (500 - (value | float) / %d * 347) | round(0) | int }}", max)
+	}
+	if colour, ok := specItem(spec, "colour_data_v2", "colour_data"); ok {
+		// v2 colour data uses 0-1000 for saturation and value, v1 uses 0-255
+		saturation := 1000.0
+		if colour.Code == "colour_data" {
+			saturation = 255.0
+		}
+		entity["hs_command_topic"] = b.SetTopic(device.Id, colour.Code)
+		entity["hs_state_topic"] = b.StateTopic(device.Id, colour.Code)
+		entity["hs_command_template"] = fmt.Sprintf(`{"h": {{ h | int }}, "s": {{ (s * %g) | int }}, "v": %d}`, saturation/100, int(saturation))
+		entity["hs_value_template"] = fmt.Sprintf("{{ value_json.h }},{{ (value_json.s / %g) | round(0) }}", saturation/100)
+	}
+	return entity, true
+}
+
+func (b *Bridge) coverEntity(device tuya.Device, spec *tuya.Specification) (map[string]interface{}, bool) {
+	control, ok := specItem(spec, "control", "mach_operate")
+	if !ok {
+		return nil, false
+	}
+	entity := b.entityBase(device, "cover")
+	entity["name"] = nil
+	entity["device_class"] = "curtain"
+	entity["command_topic"] = b.SetTopic(device.Id, control.Code)
+	entity["payload_open"] = `"open"`
+	entity["payload_close"] = `"close"`
+	entity["payload_stop"] = `"stop"`
+	if position, ok := specItem(spec, "percent_control", "position"); ok {
+		entity["set_position_topic"] = b.SetTopic(device.Id, position.Code)
+		entity["position_topic"] = b.StateTopic(device.Id, position.Code)
+	}
+	return entity, true
+}
+
+// overrideEntities builds the entities configured for a product
+func (b *Bridge) overrideEntities(device tuya.Device, spec *tuya.Specification, overrides []config.DiscoveryEntity) map[string]map[string]interface{} {
+	entities := map[string]map[string]interface{}{}
+	for _, override := range overrides {
+		name := override.Name
+		if name == "" {
+			name = humanize(override.Code)
+		}
+		scale := override.Scale
+		if item, ok := specItem(spec, override.Code); ok && scale == 0 {
+			scale = parseIntegerValues(item).Scale
+		}
+		key := override.Component + "/" + override.Code
+		switch override.Component {
+		case componentSwitch:
+			entities[key] = b.switchEntity(device, override.Code, name)
+		case componentSensor:
+			entities[key] = b.sensorEntity(device, override.Code, name, override.DeviceClass, override.Unit, scale)
+		case componentBinarySensor:
+			onValue := override.OnValue
+			if onValue == "" {
+				onValue = "true"
+			}
+			entities[key] = b.binarySensorEntity(device, override.Code, name, override.DeviceClass, onValue)
+		case componentLight:
+			if light, ok := b.lightEntity(device, spec); ok {
+				entities[componentLight+"/light"] = light
+			}
+		default:
+			b.logger.Warnf("discovery: unsupported component %q for product %s", override.Component, device.ProductId)
+		}
+	}
+	return entities
+}
+
+// DiscoveryMessages generates the Home Assistant discovery configs of a
+// device from its category and specification, or from the product override
+func (b *Bridge) DiscoveryMessages(device tuya.Device, spec *tuya.Specification) []DiscoveryMessage {
+	// viper lower cases map keys
+	override := b.cfg.Discovery.Products[strings.ToLower(device.ProductId)]
+	if override.Ignore {
+		return nil
+	}
+
+	var entities map[string]map[string]interface{}
+	if len(override.Entities) > 0 {
+		entities = b.overrideEntities(device, spec, override.Entities)
+	} else {
+		category := override.Category
+		if category == "" {
+			category = device.Category
+		}
+		entities = b.categoryEntities(device, spec, category)
+	}
+
+	messages := make([]DiscoveryMessage, 0, len(entities))
+	for key, entity := range entities {
+		component, objectId, _ := strings.Cut(key, "/")
+		payload, err := json.Marshal(entity)
+		if err != nil {
+			b.logger.Errorw("json_encode_err", zap.String("error", err.Error()))
+			continue
+		}
+		messages = append(messages, DiscoveryMessage{
+			Topic:   fmt.Sprintf("%s/%s/%s/%s/config", b.discoveryPrefix(), component, device.Id, objectId),
+			Payload: payload,
+		})
+	}
+	return messages
+}
+
+// categoryEntities maps a Tuya category to entities, keyed by component/object_id
+func (b *Bridge) categoryEntities(device tuya.Device, spec *tuya.Specification, category string) map[string]map[string]interface{} {
+	entities := map[string]map[string]interface{}{}
+	switch {
+	case switchCategories[category]:
+		for _, item := range spec.Functions {
+			if item.Type == "Boolean" && strings.HasPrefix(item.Code, "switch") {
+				entities[componentSwitch+"/"+item.Code] = b.switchEntity(device, item.Code, humanize(item.Code))
+			}
+		}
+	case lightCategories[category]:
+		if light, ok := b.lightEntity(device, spec); ok {
+			entities[componentLight+"/light"] = light
+		}
+	case category == "mcs":
+		if item, ok := specItem(spec, "doorcontact_state"); ok {
+			entities[componentBinarySensor+"/"+item.Code] = b.binarySensorEntity(device, item.Code, "Door", "door", "true")
+		}
+	case category == "pir":
+		if item, ok := specItem(spec, "pir"); ok {
+			entities[componentBinarySensor+"/"+item.Code] = b.binarySensorEntity(device, item.Code, "Motion", "motion", `"pir"`)
+		}
+	case category == "cl":
+		if cover, ok := b.coverEntity(device, spec); ok {
+			entities[componentCover+"/cover"] = cover
+		}
+	}
+
+	// measurements are added for any category reporting them
+	if sensorCategories[category] || len(entities) > 0 {
+		for _, sensor := range sensorCodes {
+			item, ok := specItem(spec, sensor.code)
+			if !ok {
+				continue
+			}
+			values := parseIntegerValues(item)
+			unit := sensor.unit
+			if values.Unit != "" && sensor.deviceClass != "temperature" {
+				unit = values.Unit
+			}
+			entities[componentSensor+"/"+item.Code] = b.sensorEntity(device, item.Code, humanize(sensor.deviceClass), sensor.deviceClass, unit, values.Scale)
+		}
+	}
+	if len(entities) == 0 {
+		b.logger.Debugf("discovery: no entities for %s (category %s)", device.Id, category)
+	}
+	return entities
+}
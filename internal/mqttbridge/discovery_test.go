@@ -0,0 +1,241 @@
+package mqttbridge
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/pkg/logger"
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+	"github.com/varjangn/tuya-middleware/pkg/tuya/events"
+)
+
+const discoveryDeviceId = "dev1"
+
+func discoveryBridge(products map[string]config.DiscoveryProduct) *Bridge {
+	cfg := &config.Config{
+		Logger: config.Logger{Level: "fatal", Encoding: "console"},
+		Mqtt:   config.Mqtt{Discovery: config.Discovery{Enabled: true, Products: products}},
+	}
+	appLogger := logger.NewAppLogger(cfg)
+	appLogger.InitLogger()
+	return NewBridge(appLogger, cfg, nil, nil, events.NewBus(16))
+}
+
+// discovered returns the discovery configs of a device by topic
+func discovered(t *testing.T, b *Bridge, device tuya.Device, spec *tuya.Specification) map[string]map[string]interface{} {
+	t.Helper()
+	configs := map[string]map[string]interface{}{}
+	for _, message := range b.DiscoveryMessages(device, spec) {
+		entity := map[string]interface{}{}
+		if err := json.Unmarshal(message.Payload, &entity); err != nil {
+			t.Fatalf("%s: %v", message.Topic, err)
+		}
+		configs[message.Topic] = entity
+	}
+	return configs
+}
+
+func topics(configs map[string]map[string]interface{}) []string {
+	topics := []string{}
+	for topic := range configs {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// expectFields checks some fields of the config published on topic
+func expectFields(t *testing.T, configs map[string]map[string]interface{}, topic string, fields map[string]interface{}) {
+	t.Helper()
+	entity, ok := configs[topic]
+	if !ok {
+		t.Fatalf("no config on %s, topics %v", topic, topics(configs))
+	}
+	for key, want := range fields {
+		if got := entity[key]; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s %s = %#v, want %#v", topic, key, got, want)
+		}
+	}
+}
+
+func item(code, kind, values string) tuya.SpecificationItem {
+	return tuya.SpecificationItem{Code: code, Type: kind, Values: values}
+}
+
+func TestDiscoveryCategories(t *testing.T) {
+	b := discoveryBridge(nil)
+	device := func(category string) tuya.Device {
+		return tuya.Device{Id: discoveryDeviceId, Name: "device", ProductId: "p1", Category: category}
+	}
+
+	t.Run("light", func(t *testing.T) {
+		spec := &tuya.Specification{Functions: []tuya.SpecificationItem{
+			item("switch_led", "Boolean", "{}"),
+			item("bright_value_v2", "Integer", `{"min":10,"max":1000,"scale":0,"step":1}`),
+			item("temp_value_v2", "Integer", `{"min":0,"max":1000,"scale":0,"step":1}`),
+			item("colour_data_v2", "Json", "{}"),
+		}}
+		configs := discovered(t, b, device("dj"), spec)
+		if got := topics(configs); !reflect.DeepEqual(got, []string{"homeassistant/light/dev1/light/config"}) {
+			t.Fatalf("topics = %v", got)
+		}
+		expectFields(t, configs, "homeassistant/light/dev1/light/config", map[string]interface{}{
+			"unique_id":                   "tuya_dev1_light",
+			"name":                        nil,
+			"command_topic":               "tuya/dev1/set/switch_led",
+			"state_topic":                 "tuya/dev1/state/switch_led",
+			"availability_topic":          "tuya/dev1/availability",
+			"brightness_command_topic":    "tuya/dev1/set/bright_value_v2",
+			"brightness_scale":            float64(1000),
+			"color_temp_command_template": "{{ ((500 - value) / 347 * 1000) | round(0) | int }}",
+			"hs_command_template":         `{"h": {{ h | int }}, "s": {{ (s * 10) | int }}, "v": 1000}`,
+			"state_on":                    nil,
+		})
+	})
+
+	t.Run("light v1 colour", func(t *testing.T) {
+		spec := &tuya.Specification{Functions: []tuya.SpecificationItem{
+			item("switch_led", "Boolean", "{}"),
+			item("colour_data", "Json", "{}"),
+		}}
+		expectFields(t, discovered(t, b, device("dj"), spec), "homeassistant/light/dev1/light/config", map[string]interface{}{
+			"hs_command_template":      `{"h": {{ h | int }}, "s": {{ (s * 2.55) | int }}, "v": 255}`,
+			"brightness_command_topic": nil,
+		})
+	})
+
+	t.Run("sensor with scales", func(t *testing.T) {
+		spec := &tuya.Specification{Status: []tuya.SpecificationItem{
+			item("va_temperature", "Integer", `{"unit":"℃","min":-200,"max":600,"scale":1,"step":1}`),
+			item("va_humidity", "Integer", `{"unit":"%","min":0,"max":1000,"scale":0,"step":1}`),
+			item("co2_value", "Integer", `{"unit":"ppm","min":0,"max":5000,"scale":0,"step":1}`),
+		}}
+		configs := discovered(t, b, device("wsdcg"), spec)
+		expectFields(t, configs, "homeassistant/sensor/dev1/va_temperature/config", map[string]interface{}{
+			"device_class":        "temperature",
+			"unit_of_measurement": "°C",
+			"value_template":      "{{ (value | float / 10) | round(1) }}",
+			"state_topic":         "tuya/dev1/state/va_temperature",
+			"state_class":         "measurement",
+		})
+		expectFields(t, configs, "homeassistant/sensor/dev1/va_humidity/config", map[string]interface{}{
+			"device_class":   "humidity",
+			"value_template": "{{ value | float }}",
+		})
+		expectFields(t, configs, "homeassistant/sensor/dev1/co2_value/config", map[string]interface{}{
+			"device_class":        "carbon_dioxide",
+			"unit_of_measurement": "ppm",
+		})
+		if len(configs) != 3 {
+			t.Fatalf("topics = %v", topics(configs))
+		}
+	})
+
+	t.Run("door contact", func(t *testing.T) {
+		spec := &tuya.Specification{Status: []tuya.SpecificationItem{
+			item("doorcontact_state", "Boolean", "{}"),
+			item("battery_percentage", "Integer", `{"unit":"%","min":0,"max":100,"scale":0,"step":1}`),
+		}}
+		configs := discovered(t, b, device("mcs"), spec)
+		expectFields(t, configs, "homeassistant/binary_sensor/dev1/doorcontact_state/config", map[string]interface{}{
+			"device_class":   "door",
+			"value_template": "{{ 'ON' if value_json == true else 'OFF' }}",
+		})
+		expectFields(t, configs, "homeassistant/sensor/dev1/battery_percentage/config", map[string]interface{}{
+			"device_class": "battery",
+		})
+	})
+
+	t.Run("motion", func(t *testing.T) {
+		spec := &tuya.Specification{Status: []tuya.SpecificationItem{item("pir", "Enum", `{"range":["pir","none"]}`)}}
+		expectFields(t, discovered(t, b, device("pir"), spec), "homeassistant/binary_sensor/dev1/pir/config", map[string]interface{}{
+			"device_class":   "motion",
+			"value_template": `{{ 'ON' if value_json == "pir" else 'OFF' }}`,
+		})
+	})
+
+	t.Run("cover", func(t *testing.T) {
+		spec := &tuya.Specification{Functions: []tuya.SpecificationItem{
+			item("control", "Enum", `{"range":["open","stop","close"]}`),
+			item("percent_control", "Integer", `{"min":0,"max":100,"scale":0,"step":1}`),
+		}}
+		expectFields(t, discovered(t, b, device("cl"), spec), "homeassistant/cover/dev1/cover/config", map[string]interface{}{
+			"device_class":       "curtain",
+			"command_topic":      "tuya/dev1/set/control",
+			"payload_open":       `"open"`,
+			"set_position_topic": "tuya/dev1/set/percent_control",
+			"position_topic":     "tuya/dev1/state/percent_control",
+		})
+	})
+
+	t.Run("unknown category", func(t *testing.T) {
+		spec := &tuya.Specification{Functions: []tuya.SpecificationItem{item("switch_1", "Boolean", "{}")}}
+		if configs := discovered(t, b, device("xyz"), spec); len(configs) != 0 {
+			t.Fatalf("topics = %v, want none", topics(configs))
+		}
+	})
+}
+
+func TestDiscoveryProductOverrides(t *testing.T) {
+	b := discoveryBridge(map[string]config.DiscoveryProduct{
+		"remapped": {Category: "kg"},
+		"ignored":  {Ignore: true},
+		"listed": {Entities: []config.DiscoveryEntity{
+			{Component: "sensor", Code: "cur_power", DeviceClass: "power", Unit: "W"},
+			{Component: "sensor", Code: "cur_voltage", Name: "Voltage", Scale: 2},
+			{Component: "binary_sensor", Code: "fault", OnValue: `"alarm"`},
+			{Component: "switch", Code: "child_lock"},
+			{Component: "climate", Code: "temp_set"},
+		}},
+	})
+	spec := &tuya.Specification{
+		Functions: []tuya.SpecificationItem{item("switch_1", "Boolean", "{}"), item("child_lock", "Boolean", "{}")},
+		Status: []tuya.SpecificationItem{
+			item("cur_power", "Integer", `{"unit":"W","min":0,"max":50000,"scale":1,"step":1}`),
+			item("cur_voltage", "Integer", `{"unit":"V","min":0,"max":5000,"scale":1,"step":1}`),
+		},
+	}
+	device := func(productId string) tuya.Device {
+		return tuya.Device{Id: discoveryDeviceId, Name: "device", ProductId: productId, Category: "xyz"}
+	}
+
+	// product ids are matched lower case, as viper lower cases the keys
+	configs := discovered(t, b, device("Remapped"), spec)
+	want := []string{"homeassistant/switch/dev1/switch_1/config"}
+	if got := topics(configs); !reflect.DeepEqual(got, want) {
+		t.Fatalf("remapped topics = %v, want %v", got, want)
+	}
+	expectFields(t, configs, want[0], map[string]interface{}{"name": "Switch 1", "command_topic": "tuya/dev1/set/switch_1"})
+
+	if messages := b.DiscoveryMessages(device("ignored"), spec); messages != nil {
+		t.Fatalf("ignored product published %d configs", len(messages))
+	}
+
+	configs = discovered(t, b, device("listed"), spec)
+	want = []string{
+		"homeassistant/binary_sensor/dev1/fault/config",
+		"homeassistant/sensor/dev1/cur_power/config",
+		"homeassistant/sensor/dev1/cur_voltage/config",
+		"homeassistant/switch/dev1/child_lock/config",
+	}
+	if got := topics(configs); !reflect.DeepEqual(got, want) {
+		t.Fatalf("listed topics = %v, want %v", got, want)
+	}
+	expectFields(t, configs, "homeassistant/sensor/dev1/cur_power/config", map[string]interface{}{
+		"name":                "Cur Power",
+		"device_class":        "power",
+		"unit_of_measurement": "W",
+		// the scale of the specification
+		"value_template": "{{ (value | float / 10) | round(1) }}",
+	})
+	expectFields(t, configs, "homeassistant/sensor/dev1/cur_voltage/config", map[string]interface{}{
+		"name":           "Voltage",
+		"value_template": "{{ (value | float / 100) | round(2) }}",
+	})
+	expectFields(t, configs, "homeassistant/binary_sensor/dev1/fault/config", map[string]interface{}{
+		"value_template": `{{ 'ON' if value_json == "alarm" else 'OFF' }}`,
+	})
+}
@@ -0,0 +1,392 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/pkg/logger"
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+	"github.com/varjangn/tuya-middleware/pkg/tuyalocal"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultTimeout = 3 * time.Second
+	defaultSkipFor = time.Minute
+	// the skip period stops doubling after this many consecutive failures
+	maxSkipDoublings = 4
+)
+
+type Path string
+
+const (
+	PathLocal Path = "local"
+	PathCloud Path = "cloud"
+)
+
+// CloudClient is the part of TuyaClient the router needs
+type CloudClient interface {
+	GetDeviceStatus(deviceId string) ([]tuya.DeviceStatus, error)
+	SendCommands(deviceId string, commands []tuya.Command) (bool, error)
+	GetDeviceProperties(deviceId string) ([]tuya.DeviceProperty, error)
+}
+
+// Directory finds the devices on the LAN, tuyalocal.Discovery implements it
+type Directory interface {
+	Device(id string) (tuyalocal.LocalDevice, bool)
+}
+
+// Dialer opens a local connection, tuyalocal.Dial unless replaced to reach
+// simulated devices
+type Dialer func(ctx context.Context, cfg tuyalocal.DeviceConfig) (*tuyalocal.Client, error)
+
+// PathHealth tracks the outcome of the requests made over one path, Failures
+// counts the consecutive failures
+type PathHealth struct {
+	Successes     int       `json:"successes"`
+	Failures      int       `json:"failures"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastSuccessAt time.Time `json:"last_success_at,omitempty"`
+	LastFailureAt time.Time `json:"last_failure_at,omitempty"`
+	SkipUntil     time.Time `json:"skip_until,omitempty"`
+}
+
+type DeviceHealth struct {
+	Local PathHealth `json:"local"`
+	Cloud PathHealth `json:"cloud"`
+}
+
+// StatusResult and CommandResult report the path that served the request,
+// Errors holds the error of every path tried before it
+type StatusResult struct {
+	Path   Path                `json:"path"`
+	Status []tuya.DeviceStatus `json:"status"`
+	Errors map[Path]string     `json:"errors,omitempty"`
+}
+
+type CommandResult struct {
+	Path    Path            `json:"path"`
+	Success bool            `json:"success"`
+	Errors  map[Path]string `json:"errors,omitempty"`
+}
+
+type localConn struct {
+	client  *tuyalocal.Client
+	address string
+}
+
+// Router sends status queries and commands over the LAN when the device was
+// heard there, and through the cloud otherwise or when the local path fails.
+// A path that failed is skipped for a while, it is only tried as a last
+// resort once the other paths failed.
+type Router struct {
+	logger    *logger.AppLogger
+	cfg       *config.Local
+	cloud     CloudClient
+	directory Directory
+	dial      Dialer
+
+	mu    sync.Mutex
+	conns map[string]*localConn
+	// held while connecting, so concurrent requests share one connection
+	dialMu map[string]*sync.Mutex
+	dpIds  map[string]map[string]string
+	health map[string]*DeviceHealth
+}
+
+func NewRouter(logger *logger.AppLogger, cfg *config.Config, cloud CloudClient, directory Directory) *Router {
+	return &Router{
+		logger:    logger,
+		cfg:       &cfg.Local,
+		cloud:     cloud,
+		directory: directory,
+		dial:      tuyalocal.Dial,
+		conns:     map[string]*localConn{},
+		dialMu:    map[string]*sync.Mutex{},
+		dpIds:     map[string]map[string]string{},
+		health:    map[string]*DeviceHealth{},
+	}
+}
+
+// SetDialer replaces the dialer of local connections
+func (r *Router) SetDialer(dial Dialer) {
+	r.dial = dial
+}
+
+func (r *Router) timeout() time.Duration {
+	if r.cfg.Timeout <= 0 {
+		return defaultTimeout
+	}
+	return r.cfg.Timeout
+}
+
+// Status returns the status of a device by code
+func (r *Router) Status(ctx context.Context, deviceId string) (*StatusResult, error) {
+	result := &StatusResult{}
+	path, errs, err := r.route(ctx, deviceId, func(ctx context.Context, device tuyalocal.LocalDevice) error {
+		status, err := r.localStatus(ctx, device)
+		result.Status = status
+		return err
+	}, func() error {
+		status, err := r.cloud.GetDeviceStatus(deviceId)
+		result.Status = status
+		return err
+	})
+	result.Path, result.Errors = path, errs
+	return result, err
+}
+
+// SendCommands sends commands by code
+func (r *Router) SendCommands(ctx context.Context, deviceId string, commands []tuya.Command) (*CommandResult, error) {
+	if len(commands) == 0 {
+		return nil, fmt.Errorf("commands can not be empty")
+	}
+	result := &CommandResult{}
+	path, errs, err := r.route(ctx, deviceId, func(ctx context.Context, device tuyalocal.LocalDevice) error {
+		return r.localCommands(ctx, device, commands)
+	}, func() error {
+		ok, err := r.cloud.SendCommands(deviceId, commands)
+		if err == nil && !ok {
+			err = errors.New("command was not accepted")
+		}
+		return err
+	})
+	result.Path, result.Errors, result.Success = path, errs, err == nil
+	return result, err
+}
+
+// Health returns the path health of the devices routed so far
+func (r *Router) Health() map[string]DeviceHealth {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	health := make(map[string]DeviceHealth, len(r.health))
+	for id, h := range r.health {
+		health[id] = *h
+	}
+	return health
+}
+
+// route tries the paths available for the device in order until one succeeds
+func (r *Router) route(ctx context.Context, deviceId string, local func(context.Context, tuyalocal.LocalDevice) error, cloud func() error) (Path, map[Path]string, error) {
+	paths := []Path{PathCloud}
+	device, onLan := r.directory.Device(deviceId)
+	if r.cfg.Enabled && onLan && device.Online && device.LocalKey != "" {
+		paths = []Path{PathLocal, PathCloud}
+	}
+
+	// the paths that failed recently go last, tried only when the others fail
+	now := time.Now()
+	healthy, skipped := []Path{}, []Path{}
+	for _, path := range paths {
+		if now.After(r.pathHealth(deviceId, path).SkipUntil) {
+			healthy = append(healthy, path)
+		} else {
+			skipped = append(skipped, path)
+		}
+	}
+	paths = append(healthy, skipped...)
+
+	var errs map[Path]string
+	var failures []error
+	for _, path := range paths {
+		var err error
+		if path == PathLocal {
+			localCtx, cancel := context.WithTimeout(ctx, r.timeout())
+			err = local(localCtx, device)
+			cancel()
+		} else {
+			err = cloud()
+		}
+		r.record(deviceId, path, err)
+		if err == nil {
+			return path, errs, nil
+		}
+		if errs == nil {
+			errs = map[Path]string{}
+		}
+		errs[path] = err.Error()
+		failures = append(failures, fmt.Errorf("%s: %w", path, err))
+		r.logger.Warnw("route_err",
+			zap.String("device_id", deviceId),
+			zap.String("path", string(path)),
+			zap.String("error", err.Error()))
+	}
+	return paths[len(paths)-1], errs, errors.Join(failures...)
+}
+
+func (r *Router) pathHealth(deviceId string, path Path) PathHealth {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, ok := r.health[deviceId]
+	if !ok {
+		return PathHealth{}
+	}
+	if path == PathLocal {
+		return h.Local
+	}
+	return h.Cloud
+}
+
+func (r *Router) record(deviceId string, path Path, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, ok := r.health[deviceId]
+	if !ok {
+		h = &DeviceHealth{}
+		r.health[deviceId] = h
+	}
+	ph := &h.Cloud
+	if path == PathLocal {
+		ph = &h.Local
+	}
+
+	now := time.Now()
+	if err == nil {
+		ph.Successes++
+		ph.Failures = 0
+		ph.LastSuccessAt = now
+		ph.SkipUntil = time.Time{}
+		return
+	}
+	ph.Failures++
+	ph.LastError = err.Error()
+	ph.LastFailureAt = now
+	skipFor := r.cfg.SkipFor
+	if skipFor <= 0 {
+		skipFor = defaultSkipFor
+	}
+	ph.SkipUntil = now.Add(skipFor << min(ph.Failures-1, maxSkipDoublings))
+}
+
+// dpIdsOf maps the status codes of a device to the DP ids used on the LAN
+func (r *Router) dpIdsOf(deviceId string) (map[string]string, error) {
+	r.mu.Lock()
+	ids, ok := r.dpIds[deviceId]
+	r.mu.Unlock()
+	if ok {
+		return ids, nil
+	}
+
+	properties, err := r.cloud.GetDeviceProperties(deviceId)
+	if err != nil {
+		return nil, fmt.Errorf("dp ids: %w", err)
+	}
+	ids = make(map[string]string, len(properties))
+	for _, property := range properties {
+		ids[property.Code] = strconv.Itoa(property.DpId)
+	}
+	r.mu.Lock()
+	r.dpIds[deviceId] = ids
+	r.mu.Unlock()
+	return ids, nil
+}
+
+// conn returns the open connection to a device, connecting when there is none,
+// it was closed by the device or the device changed address
+func (r *Router) conn(ctx context.Context, device tuyalocal.LocalDevice) (*tuyalocal.Client, error) {
+	r.mu.Lock()
+	dialMu, ok := r.dialMu[device.Id]
+	if !ok {
+		dialMu = &sync.Mutex{}
+		r.dialMu[device.Id] = dialMu
+	}
+	r.mu.Unlock()
+	dialMu.Lock()
+	defer dialMu.Unlock()
+
+	r.mu.Lock()
+	conn, ok := r.conns[device.Id]
+	r.mu.Unlock()
+	if ok && conn.address == device.Ip {
+		select {
+		case <-conn.client.Done():
+		default:
+			return conn.client, nil
+		}
+	}
+	if ok {
+		conn.client.Close()
+	}
+
+	client, err := r.dial(ctx, tuyalocal.DeviceConfig{
+		Id:       device.Id,
+		Address:  device.Ip,
+		LocalKey: device.LocalKey,
+		Version:  device.Version,
+		Timeout:  r.timeout(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.conns[device.Id] = &localConn{client: client, address: device.Ip}
+	r.mu.Unlock()
+	return client, nil
+}
+
+// closeConn drops the connection after an error so the next request reconnects
+func (r *Router) closeConn(deviceId string) {
+	r.mu.Lock()
+	conn, ok := r.conns[deviceId]
+	delete(r.conns, deviceId)
+	r.mu.Unlock()
+	if ok {
+		conn.client.Close()
+	}
+}
+
+func (r *Router) localStatus(ctx context.Context, device tuyalocal.LocalDevice) ([]tuya.DeviceStatus, error) {
+	ids, err := r.dpIdsOf(device.Id)
+	if err != nil {
+		return nil, err
+	}
+	client, err := r.conn(ctx, device)
+	if err != nil {
+		return nil, err
+	}
+	dps, err := client.Status()
+	if err != nil {
+		r.closeConn(device.Id)
+		return nil, err
+	}
+
+	status := []tuya.DeviceStatus{}
+	for code, dpId := range ids {
+		if value, ok := dps[dpId]; ok {
+			status = append(status, tuya.DeviceStatus{Code: code, Value: value})
+		}
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Code < status[j].Code })
+	return status, nil
+}
+
+func (r *Router) localCommands(ctx context.Context, device tuyalocal.LocalDevice, commands []tuya.Command) error {
+	ids, err := r.dpIdsOf(device.Id)
+	if err != nil {
+		return err
+	}
+	dps := make(map[string]interface{}, len(commands))
+	for _, command := range commands {
+		dpId, ok := ids[command.Code]
+		if !ok {
+			return fmt.Errorf("no dp id for code %s", command.Code)
+		}
+		dps[dpId] = command.Value
+	}
+
+	client, err := r.conn(ctx, device)
+	if err != nil {
+		return err
+	}
+	if err := client.SetDPs(dps); err != nil {
+		r.closeConn(device.Id)
+		return err
+	}
+	return nil
+}
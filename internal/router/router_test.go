@@ -0,0 +1,261 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/internal/fakecloud"
+	"github.com/varjangn/tuya-middleware/pkg/logger"
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+	"github.com/varjangn/tuya-middleware/pkg/tuyalocal"
+)
+
+const (
+	testDeviceId = "bf1234567890abcdef"
+	testLocalKey = "0123456789abcdef"
+)
+
+type fakeCloud struct {
+	calls atomic.Int32
+	err   error
+}
+
+func (c *fakeCloud) GetDeviceStatus(deviceId string) ([]tuya.DeviceStatus, error) {
+	c.calls.Add(1)
+	if c.err != nil {
+		return nil, c.err
+	}
+	return []tuya.DeviceStatus{{Code: "switch_1", Value: false}}, nil
+}
+
+func (c *fakeCloud) SendCommands(deviceId string, commands []tuya.Command) (bool, error) {
+	c.calls.Add(1)
+	return c.err == nil, c.err
+}
+
+func (c *fakeCloud) GetDeviceProperties(deviceId string) ([]tuya.DeviceProperty, error) {
+	return []tuya.DeviceProperty{{Code: "switch_1", DpId: 1}}, nil
+}
+
+type fakeDirectory struct{}
+
+func (fakeDirectory) Device(id string) (tuyalocal.LocalDevice, bool) {
+	return tuyalocal.LocalDevice{Id: id, Ip: "192.0.2.10", Version: tuyalocal.Version33, Online: true, LocalKey: testLocalKey}, true
+}
+
+func newTestRouter(t *testing.T, cloud CloudClient) *Router {
+	t.Helper()
+	cfg := &config.Config{
+		Logger: config.Logger{Level: "fatal", Encoding: "console"},
+		Local:  config.Local{Enabled: true},
+	}
+	appLogger := logger.NewAppLogger(cfg)
+	appLogger.InitLogger()
+	return NewRouter(appLogger, cfg, cloud, fakeDirectory{})
+}
+
+// simulatedDialer connects to a simulated device over net.Pipe and counts the
+// dials, which take a while like a TCP connection
+func simulatedDialer(device *tuyalocal.SimulatedDevice, dials *atomic.Int32) Dialer {
+	return func(ctx context.Context, cfg tuyalocal.DeviceConfig) (*tuyalocal.Client, error) {
+		dials.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		clientConn, deviceConn := net.Pipe()
+		go device.ServeConn(deviceConn)
+		return tuyalocal.NewClient(clientConn, cfg)
+	}
+}
+
+func TestConcurrentRequestsShareOneConnection(t *testing.T) {
+	r := newTestRouter(t, &fakeCloud{})
+	device := tuyalocal.NewSimulatedDevice(testDeviceId, testLocalKey, tuyalocal.Version33, map[string]interface{}{"1": true})
+	var dials atomic.Int32
+	r.SetDialer(simulatedDialer(device, &dials))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := r.Status(context.Background(), testDeviceId)
+			if err == nil && result.Path != PathLocal {
+				err = errors.New("served by " + string(result.Path))
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := dials.Load(); n != 1 {
+		t.Fatalf("%d dials, want 1", n)
+	}
+}
+
+func TestLocalFailureFallsBackToSkippedCloud(t *testing.T) {
+	cloud := &fakeCloud{}
+	r := newTestRouter(t, cloud)
+	r.SetDialer(func(ctx context.Context, cfg tuyalocal.DeviceConfig) (*tuyalocal.Client, error) {
+		return nil, errors.New("connection refused")
+	})
+	// the cloud failed recently and is in its skip window
+	r.record(testDeviceId, PathCloud, errors.New("cloud unavailable"))
+
+	result, err := r.Status(context.Background(), testDeviceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Path != PathCloud || result.Errors[PathLocal] == "" {
+		t.Fatalf("result = %+v, want the cloud after a local error", result)
+	}
+	if n := cloud.calls.Load(); n != 1 {
+		t.Fatalf("%d cloud calls, want 1", n)
+	}
+}
+
+func TestSkippedLocalIsNotTriedFirst(t *testing.T) {
+	cloud := &fakeCloud{}
+	r := newTestRouter(t, cloud)
+	var dials atomic.Int32
+	r.SetDialer(func(ctx context.Context, cfg tuyalocal.DeviceConfig) (*tuyalocal.Client, error) {
+		dials.Add(1)
+		return nil, errors.New("connection refused")
+	})
+	r.record(testDeviceId, PathLocal, errors.New("timeout"))
+
+	result, err := r.Status(context.Background(), testDeviceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Path != PathCloud || dials.Load() != 0 {
+		t.Fatalf("result = %+v after %d dials, want the cloud without dialing", result, dials.Load())
+	}
+}
+
+func TestAllPathsFail(t *testing.T) {
+	r := newTestRouter(t, &fakeCloud{err: errors.New("cloud unavailable")})
+	r.SetDialer(func(ctx context.Context, cfg tuyalocal.DeviceConfig) (*tuyalocal.Client, error) {
+		return nil, errors.New("connection refused")
+	})
+	r.record(testDeviceId, PathCloud, errors.New("cloud unavailable"))
+
+	result, err := r.Status(context.Background(), testDeviceId)
+	if err == nil {
+		t.Fatal("no error with both paths failing")
+	}
+	if len(result.Errors) != 2 {
+		t.Fatalf("errors = %v, want both paths", result.Errors)
+	}
+}
+
+// lanDirectory lists the device as heard on the LAN while onLan is set
+type lanDirectory struct {
+	onLan atomic.Bool
+}
+
+func (d *lanDirectory) Device(id string) (tuyalocal.LocalDevice, bool) {
+	if !d.onLan.Load() {
+		return tuyalocal.LocalDevice{}, false
+	}
+	return fakeDirectory{}.Device(id)
+}
+
+// TestSimulatedDeviceOverBothPaths drives one simulated device over the LAN
+// and through the fake cloud, which share its DPs
+func TestSimulatedDeviceOverBothPaths(t *testing.T) {
+	device := tuyalocal.NewSimulatedDevice(testDeviceId, testLocalKey, tuyalocal.Version33, map[string]interface{}{"1": true})
+	cloud := fakecloud.NewServer("client")
+	cloud.SetSecret("secret")
+	cloud.Register(fakecloud.Device{
+		Info:       tuya.Device{Id: testDeviceId, Name: "plug", Category: "cz", LocalKey: testLocalKey},
+		DataPoints: []fakecloud.DataPoint{{Id: 1, Code: "switch_1", Type: "Boolean", Values: "{}"}},
+		State:      device.DPs,
+		Set:        device.SetDPs,
+	})
+	server := httptest.NewServer(cloud)
+	t.Cleanup(server.Close)
+
+	cfg := &config.Config{
+		Logger: config.Logger{Level: "fatal", Encoding: "console"},
+		Tuya:   config.Tuya{Host: server.URL, ClientId: "client", Secret: "secret"},
+		Local:  config.Local{Enabled: true},
+	}
+	appLogger := logger.NewAppLogger(cfg)
+	appLogger.InitLogger()
+	tuyaClient := tuya.NewTuyaClient(appLogger, cfg)
+	t.Cleanup(func() { tuya.SetActiveToken(&tuya.TokenResponse{}) })
+	if err := tuyaClient.FetchToken(); err != nil {
+		t.Fatalf("FetchToken: %v", err)
+	}
+	directory := &lanDirectory{}
+	r := NewRouter(appLogger, cfg, tuyaClient, directory)
+	var dials atomic.Int32
+	r.SetDialer(simulatedDialer(device, &dials))
+	ctx := context.Background()
+
+	switchValue := func(status []tuya.DeviceStatus) interface{} {
+		for _, s := range status {
+			if s.Code == "switch_1" {
+				return s.Value
+			}
+		}
+		return nil
+	}
+	check := func(path Path, want bool) {
+		t.Helper()
+		status, err := r.Status(ctx, testDeviceId)
+		if err != nil {
+			t.Fatalf("Status over %s: %v", path, err)
+		}
+		if status.Path != path || switchValue(status.Status) != want {
+			t.Fatalf("Status = %+v, want switch_1 %v over %s", status, want, path)
+		}
+	}
+
+	// on the LAN
+	directory.onLan.Store(true)
+	check(PathLocal, true)
+	result, err := r.SendCommands(ctx, testDeviceId, []tuya.Command{{Code: "switch_1", Value: false}})
+	if err != nil || result.Path != PathLocal || !result.Success {
+		t.Fatalf("SendCommands = %+v, %v, want sent over the LAN", result, err)
+	}
+	if device.DPs()["1"] != false {
+		t.Fatalf("DPs = %v after the local command", device.DPs())
+	}
+	check(PathLocal, false)
+
+	// through the cloud once the device isn't heard on the LAN
+	directory.onLan.Store(false)
+	check(PathCloud, false)
+	result, err = r.SendCommands(ctx, testDeviceId, []tuya.Command{{Code: "switch_1", Value: true}})
+	if err != nil || result.Path != PathCloud || !result.Success {
+		t.Fatalf("SendCommands = %+v, %v, want sent through the cloud", result, err)
+	}
+	if device.DPs()["1"] != true {
+		t.Fatalf("DPs = %v after the cloud command", device.DPs())
+	}
+	check(PathCloud, true)
+
+	// back on the LAN over the same connection
+	directory.onLan.Store(true)
+	check(PathLocal, true)
+	if n := dials.Load(); n != 1 {
+		t.Fatalf("%d dials, want 1", n)
+	}
+	health := r.Health()[testDeviceId]
+	if health.Local.Successes != 4 || health.Cloud.Successes != 3 || health.Local.Failures+health.Cloud.Failures != 0 {
+		t.Fatalf("health = %+v", health)
+	}
+}
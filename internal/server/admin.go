@@ -0,0 +1,47 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+)
+
+// requireAdmin lets the requests carrying the admin token through, as
+// Authorization: Bearer <token>. Nothing does while no token is configured.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.Admin.Token == "" {
+			writeError(w, http.StatusForbidden, errors.New("set admin.Token to enable this endpoint"))
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.Admin.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("missing or wrong admin token"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// getCredentials reports the client id and which secret signs the requests
+func (s *Server) getCredentials(w http.ResponseWriter, r *http.Request) {
+	writeResult(w, s.tuyaClient.CredentialsStatus())
+}
+
+// promoteSecret makes the secondary secret the primary one, once the
+// rotation is done on the Tuya side
+func (s *Server) promoteSecret(w http.ResponseWriter, r *http.Request) {
+	if err := s.tuyaClient.PromoteSecondary(); err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, tuya.ErrNoSecondarySecret) {
+			status = http.StatusConflict
+		}
+		writeError(w, status, err)
+		return
+	}
+	writeResult(w, s.tuyaClient.CredentialsStatus())
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+)
+
+type deviceCommandsRequest struct {
+	Commands []tuya.Command `json:"commands"`
+}
+
+// getDeviceStatus returns the status over the LAN when possible, the result
+// reports the path that served it
+func (s *Server) getDeviceStatus(w http.ResponseWriter, r *http.Request) {
+	result, err := s.router.Status(r.Context(), r.PathValue("deviceId"))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, result)
+}
+
+func (s *Server) sendDeviceCommands(w http.ResponseWriter, r *http.Request) {
+	req := new(deviceCommandsRequest)
+	if err := decodeBody(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(req.Commands) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("commands is required"))
+		return
+	}
+
+	result, err := s.router.SendCommands(r.Context(), r.PathValue("deviceId"), req.Commands)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, result)
+}
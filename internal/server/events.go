@@ -0,0 +1,156 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/varjangn/tuya-middleware/pkg/tuya/events"
+)
+
+const (
+	defaultHeartbeatInterval = 15 * time.Second
+	// a device whose category couldn't be fetched is left out of category
+	// filters for this long, instead of a cloud request per event
+	categoryRetryAfter = time.Minute
+)
+
+// deviceCategories caches the category of devices for event filtering, and
+// when the lookup of a device failed, the time it can be retried
+type deviceCategories struct {
+	mu         sync.Mutex
+	categories map[string]string
+	failed     map[string]time.Time
+}
+
+func newDeviceCategories() *deviceCategories {
+	return &deviceCategories{categories: map[string]string{}, failed: map[string]time.Time{}}
+}
+
+func (s *Server) deviceCategory(deviceId string) string {
+	s.categories.mu.Lock()
+	category, ok := s.categories.categories[deviceId]
+	retryAt, failed := s.categories.failed[deviceId]
+	s.categories.mu.Unlock()
+	if ok {
+		return category
+	}
+	if failed && time.Now().Before(retryAt) {
+		return ""
+	}
+
+	device, err := s.tuyaClient.GetDevice(deviceId)
+	s.categories.mu.Lock()
+	defer s.categories.mu.Unlock()
+	if err != nil {
+		s.logger.Warnf("category for %s, retrying in %s: %v", deviceId, categoryRetryAfter, err)
+		s.categories.failed[deviceId] = time.Now().Add(categoryRetryAfter)
+		return ""
+	}
+	delete(s.categories.failed, deviceId)
+	s.categories.categories[deviceId] = device.Category
+	return device.Category
+}
+
+func splitSet(value string) map[string]bool {
+	set := map[string]bool{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			set[item] = true
+		}
+	}
+	return set
+}
+
+// eventFilter matches events on device ids and event types, empty sets match everything
+func eventFilter(deviceIds, types map[string]bool) func(events.Event) bool {
+	return func(event events.Event) bool {
+		if len(deviceIds) > 0 && !deviceIds[event.Meta().DeviceId] {
+			return false
+		}
+		if len(types) > 0 && !types[string(event.Type())] {
+			return false
+		}
+		return true
+	}
+}
+
+// streamEvents streams device events as Server-Sent Events, filtered by the
+// comma separated device_id, category and type query params. Clients resume
+// with the Last-Event-ID header (or last_event_id param) from the buffered events.
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	query := r.URL.Query()
+	categories := splitSet(query.Get("category"))
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = query.Get("last_event_id")
+	}
+	afterId, _ := strconv.ParseUint(lastEventId, 10, 64)
+
+	sub, backlog := s.bus.Subscribe(afterId, eventFilter(splitSet(query.Get("device_id")), splitSet(query.Get("type"))))
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	send := func(record events.Record) error {
+		if len(categories) > 0 && !categories[s.deviceCategory(record.Event.Meta().DeviceId)] {
+			return nil
+		}
+		data, err := json.Marshal(record.Event)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", record.Id, record.Event.Type(), data)
+		return err
+	}
+
+	for _, record := range backlog {
+		if err := send(record); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeatInterval := s.cfg.Events.HeartbeatInterval
+	if heartbeatInterval <= 0 {
+		heartbeatInterval = defaultHeartbeatInterval
+	}
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case record, ok := <-sub.C:
+			if !ok {
+				// subscription fell behind, the client reconnects with its last id
+				return
+			}
+			if err := send(record); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
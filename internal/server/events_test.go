@@ -0,0 +1,156 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/internal/fakecloud"
+	"github.com/varjangn/tuya-middleware/pkg/logger"
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+	"github.com/varjangn/tuya-middleware/pkg/tuya/events"
+)
+
+// eventsServer returns a server whose devices light1 (dj) and plug1 (cz) are
+// in a fake cloud, counting the device lookups
+func eventsServer(t *testing.T) (*Server, *atomic.Int32) {
+	t.Helper()
+	cloud := fakecloud.NewServer("client")
+	noState := func() map[string]interface{} { return map[string]interface{}{} }
+	cloud.Register(fakecloud.Device{Info: tuya.Device{Id: "light1", Category: "dj"}, State: noState})
+	cloud.Register(fakecloud.Device{Info: tuya.Device{Id: "plug1", Category: "cz"}, State: noState})
+	lookups := &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v1.0/devices/") {
+			lookups.Add(1)
+		}
+		cloud.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	cfg := &config.Config{
+		Logger: config.Logger{Level: "fatal", Encoding: "console"},
+		Tuya:   config.Tuya{Host: server.URL, ClientId: "client", Secret: "secret"},
+		Events: config.Events{HeartbeatInterval: time.Hour},
+	}
+	appLogger := logger.NewAppLogger(cfg)
+	appLogger.InitLogger()
+	tuyaClient := tuya.NewTuyaClient(appLogger, cfg)
+	t.Cleanup(func() { tuya.SetActiveToken(&tuya.TokenResponse{}) })
+	if err := tuyaClient.FetchToken(); err != nil {
+		t.Fatalf("FetchToken: %v", err)
+	}
+	return NewServer(appLogger, cfg, tuyaClient, events.NewBus(8)), lookups
+}
+
+func online(deviceId string) events.Event {
+	return &events.OnlineEvent{EventMeta: events.EventMeta{DeviceId: deviceId}}
+}
+
+func TestDeviceCategoryCachesFailures(t *testing.T) {
+	s, lookups := eventsServer(t)
+
+	for i := 0; i < 3; i++ {
+		if category := s.deviceCategory("light1"); category != "dj" {
+			t.Fatalf("category = %q, want dj", category)
+		}
+		if category := s.deviceCategory("unknown"); category != "" {
+			t.Fatalf("category of an unknown device = %q", category)
+		}
+	}
+	if n := lookups.Load(); n != 2 {
+		t.Fatalf("%d lookups, want one per device", n)
+	}
+
+	// retried once the failure expired
+	s.categories.mu.Lock()
+	s.categories.failed["unknown"] = time.Now().Add(-time.Second)
+	s.categories.mu.Unlock()
+	s.deviceCategory("unknown")
+	if n := lookups.Load(); n != 3 {
+		t.Fatalf("%d lookups, want the failed device retried", n)
+	}
+}
+
+// readEvents reads the ids and types of n SSE events
+func readEvents(t *testing.T, lines *bufio.Scanner, n int) []string {
+	t.Helper()
+	got := []string{}
+	id := ""
+	for len(got) < n && lines.Scan() {
+		line := lines.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			got = append(got, id+" "+strings.TrimPrefix(line, "event: "))
+		}
+	}
+	if len(got) < n {
+		t.Fatalf("stream ended after %v: %v", got, lines.Err())
+	}
+	return got
+}
+
+func TestStreamEvents(t *testing.T) {
+	for _, tt := range []struct {
+		name, query, lastEventId string
+		want                     []string
+		live                     string
+	}{
+		{"resume after Last-Event-ID", "", "2", []string{"3 offline", "4 online", "5 online"}, "unknown"},
+		{"last_event_id param", "?last_event_id=3", "", []string{"4 online", "5 online"}, "unknown"},
+		{"category", "?category=dj", "1", []string{"3 offline", "4 online"}, "light1"},
+		{"category and type", "?category=dj,cz&type=online", "1", []string{"2 online", "4 online"}, "plug1"},
+		{"device id", "?device_id=plug1", "1", []string{"2 online"}, "plug1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := eventsServer(t)
+			server := httptest.NewServer(http.HandlerFunc(s.streamEvents))
+			t.Cleanup(server.Close)
+
+			s.bus.Publish(online("light1"))                                                      // 1
+			s.bus.Publish(online("plug1"))                                                       // 2
+			s.bus.Publish(&events.OfflineEvent{EventMeta: events.EventMeta{DeviceId: "light1"}}) // 3
+			s.bus.Publish(online("light1"))                                                      // 4
+			s.bus.Publish(online("unknown"))                                                     // 5
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+tt.query, nil)
+			if tt.lastEventId != "" {
+				req.Header.Set("Last-Event-ID", tt.lastEventId)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+				t.Fatalf("Content-Type = %s", ct)
+			}
+			lines := bufio.NewScanner(resp.Body)
+			got := readEvents(t, lines, len(tt.want))
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("backlog = %v, want %v", got, tt.want)
+			}
+
+			// live events pass the same filters
+			ids := map[string]uint64{}
+			for _, deviceId := range []string{"unknown", "plug1", "light1"} {
+				ids[deviceId] = s.bus.Publish(online(deviceId))
+			}
+			got = readEvents(t, lines, 1)
+			if want := fmt.Sprintf("%d online", ids[tt.live]); got[0] != want {
+				t.Fatalf("live event = %s, want %s", got[0], want)
+			}
+		})
+	}
+}
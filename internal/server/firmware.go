@@ -0,0 +1,143 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+)
+
+// firmware infos requested at once by getFleetUpgrades
+const fleetFirmwareConcurrency = 4
+
+type fleetDeviceUpgrade struct {
+	DeviceId string              `json:"device_id"`
+	Name     string              `json:"name"`
+	Online   bool                `json:"online"`
+	Modules  []tuya.FirmwareInfo `json:"modules"`
+}
+
+type fleetProductUpgrades struct {
+	ProductId   string               `json:"product_id"`
+	ProductName string               `json:"product_name"`
+	Devices     []fleetDeviceUpgrade `json:"devices"`
+}
+
+// fleetFailure is a device whose firmware info couldn't be read
+type fleetFailure struct {
+	DeviceId string `json:"device_id"`
+	Name     string `json:"name"`
+	Error    string `json:"error"`
+}
+
+type fleetUpgrades struct {
+	Products []*fleetProductUpgrades `json:"products"`
+	Failed   []fleetFailure          `json:"failed"`
+}
+
+func (s *Server) getFirmwareInfo(w http.ResponseWriter, r *http.Request) {
+	modules, err := s.tuyaClient.GetFirmwareInfo(r.PathValue("deviceId"))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, modules)
+}
+
+func (s *Server) upgradeFirmware(w http.ResponseWriter, r *http.Request) {
+	firmwareType, err := strconv.Atoi(r.PathValue("type"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid firmware type %q", r.PathValue("type")))
+		return
+	}
+
+	ok, err := s.tuyaClient.UpgradeFirmware(r.PathValue("deviceId"), firmwareType)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, ok)
+}
+
+// getFleetUpgrades pages through every device of the project and reports the
+// devices with a firmware upgrade available, grouped by product id, along with
+// the devices whose firmware info failed
+func (s *Server) getFleetUpgrades(w http.ResponseWriter, r *http.Request) {
+	pageSize := queryInt(r, "page_size", 100)
+	products := map[string]*fleetProductUpgrades{}
+	order := []string{}
+	failed := []fleetFailure{}
+
+	seen := 0
+	for pageNo := 1; ; pageNo++ {
+		page, err := s.tuyaClient.GetDevices(pageNo, pageSize, map[string]string{})
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+
+		infos, errs := s.firmwareInfos(page.Devices)
+		for i, device := range page.Devices {
+			if errs[i] != nil {
+				s.logger.Warnf("firmware info for %s: %v", device.Id, errs[i])
+				failed = append(failed, fleetFailure{DeviceId: device.Id, Name: device.Name, Error: errs[i].Error()})
+				continue
+			}
+			available := []tuya.FirmwareInfo{}
+			for _, module := range infos[i] {
+				if module.UpgradeStatus == tuya.FirmwareUpgradeAvailable {
+					available = append(available, module)
+				}
+			}
+			if len(available) == 0 {
+				continue
+			}
+
+			product, ok := products[device.ProductId]
+			if !ok {
+				product = &fleetProductUpgrades{ProductId: device.ProductId, ProductName: device.ProductName}
+				products[device.ProductId] = product
+				order = append(order, device.ProductId)
+			}
+			product.Devices = append(product.Devices, fleetDeviceUpgrade{
+				DeviceId: device.Id,
+				Name:     device.Name,
+				Online:   device.Online,
+				Modules:  available,
+			})
+		}
+
+		seen += len(page.Devices)
+		if len(page.Devices) < pageSize || int64(seen) >= page.Total {
+			break
+		}
+	}
+
+	result := fleetUpgrades{Products: make([]*fleetProductUpgrades, 0, len(order)), Failed: failed}
+	for _, productId := range order {
+		result.Products = append(result.Products, products[productId])
+	}
+	writeResult(w, result)
+}
+
+// firmwareInfos reads the firmware info of the devices, at most
+// fleetFirmwareConcurrency at once, in the order of the devices
+func (s *Server) firmwareInfos(devices []tuya.Device) ([][]tuya.FirmwareInfo, []error) {
+	infos := make([][]tuya.FirmwareInfo, len(devices))
+	errs := make([]error, len(devices))
+	slots := make(chan struct{}, fleetFirmwareConcurrency)
+	var wg sync.WaitGroup
+	for i, device := range devices {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, deviceId string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			infos[i], errs[i] = s.tuyaClient.GetFirmwareInfo(deviceId)
+		}(i, device.Id)
+	}
+	wg.Wait()
+	return infos, errs
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/pkg/logger"
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+)
+
+func TestGetFleetUpgrades(t *testing.T) {
+	const devices = 12
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	cloud := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1.0/devices" {
+			list := []tuya.Device{}
+			for i := 0; i < devices; i++ {
+				list = append(list, tuya.Device{Id: fmt.Sprintf("d%d", i), Name: fmt.Sprintf("plug %d", i), ProductId: "p1"})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": true,
+				"result":  map[string]interface{}{"devices": list, "total": devices},
+			})
+			return
+		}
+
+		mu.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+		time.Sleep(10 * time.Millisecond)
+
+		if strings.Contains(r.URL.Path, "/d3/") {
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "code": 1106, "msg": "permission deny"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"result":  []tuya.FirmwareInfo{{Type: 9, Version: "1.1.0", UpgradeStatus: tuya.FirmwareUpgradeAvailable}},
+		})
+	}))
+	defer cloud.Close()
+
+	cfg := &config.Config{
+		Logger: config.Logger{Level: "fatal", Encoding: "console"},
+		Tuya:   config.Tuya{Host: cloud.URL, ClientId: "client", Secret: "secret"},
+	}
+	appLogger := logger.NewAppLogger(cfg)
+	appLogger.InitLogger()
+	tuya.SetActiveToken(&tuya.TokenResponse{Result: tuya.Token{AccessToken: "token"}})
+	defer tuya.SetActiveToken(&tuya.TokenResponse{})
+	s := &Server{logger: appLogger, cfg: cfg, tuyaClient: tuya.NewTuyaClient(appLogger, cfg)}
+
+	rec := httptest.NewRecorder()
+	s.getFleetUpgrades(rec, httptest.NewRequest(http.MethodGet, "/api/v1/firmware/upgrades", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	var resp struct {
+		Result fleetUpgrades `json:"result"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Result.Products) != 1 || len(resp.Result.Products[0].Devices) != devices-1 {
+		t.Fatalf("products = %+v, want the %d devices that answered", resp.Result.Products, devices-1)
+	}
+	if len(resp.Result.Failed) != 1 || resp.Result.Failed[0].DeviceId != "d3" || resp.Result.Failed[0].Error == "" {
+		t.Fatalf("failed = %+v, want d3", resp.Result.Failed)
+	}
+	if maxRunning > fleetFirmwareConcurrency {
+		t.Fatalf("%d firmware requests at once, want at most %d", maxRunning, fleetFirmwareConcurrency)
+	}
+}
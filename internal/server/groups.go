@@ -0,0 +1,223 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type createGroupRequest struct {
+	SpaceId   string   `json:"space_id"`
+	Name      string   `json:"name"`
+	ProductId string   `json:"product_id"`
+	DeviceIds []string `json:"device_ids"`
+}
+
+type renameGroupRequest struct {
+	Name string `json:"name"`
+}
+
+type groupDevicesRequest struct {
+	DeviceIds []string `json:"device_ids"`
+}
+
+type groupCommandsRequest struct {
+	Properties map[string]interface{} `json:"properties"`
+}
+
+func pathGroupId(r *http.Request) (int64, error) {
+	groupId, err := strconv.ParseInt(r.PathValue("groupId"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid group id %q", r.PathValue("groupId"))
+	}
+	return groupId, nil
+}
+
+func (s *Server) createGroup(w http.ResponseWriter, r *http.Request) {
+	req := new(createGroupRequest)
+	if err := decodeBody(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.SpaceId == "" || req.Name == "" || req.ProductId == "" || len(req.DeviceIds) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("space_id, name, product_id and device_ids are required"))
+		return
+	}
+
+	groupId, err := s.tuyaClient.CreateGroup(req.SpaceId, req.Name, req.ProductId, req.DeviceIds)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, map[string]int64{"id": groupId})
+}
+
+func (s *Server) getGroups(w http.ResponseWriter, r *http.Request) {
+	spaceId := r.URL.Query().Get("space_id")
+	if spaceId == "" {
+		writeError(w, http.StatusBadRequest, errors.New("space_id is required"))
+		return
+	}
+
+	groups, err := s.tuyaClient.GetGroups(spaceId, queryInt(r, "page_no", 1), queryInt(r, "page_size", 20))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, groups)
+}
+
+func (s *Server) getGroup(w http.ResponseWriter, r *http.Request) {
+	groupId, err := pathGroupId(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	group, err := s.tuyaClient.GetGroup(groupId)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, group)
+}
+
+func (s *Server) renameGroup(w http.ResponseWriter, r *http.Request) {
+	groupId, err := pathGroupId(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	req := new(renameGroupRequest)
+	if err := decodeBody(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, errors.New("name is required"))
+		return
+	}
+
+	ok, err := s.tuyaClient.RenameGroup(groupId, req.Name)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, ok)
+}
+
+func (s *Server) deleteGroup(w http.ResponseWriter, r *http.Request) {
+	groupId, err := pathGroupId(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ok, err := s.tuyaClient.DeleteGroup(groupId)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, ok)
+}
+
+func (s *Server) getGroupDevices(w http.ResponseWriter, r *http.Request) {
+	groupId, err := pathGroupId(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	devices, err := s.tuyaClient.GetGroupDevices(groupId, queryInt(r, "page_no", 1), queryInt(r, "page_size", 20))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, devices)
+}
+
+func (s *Server) addGroupDevices(w http.ResponseWriter, r *http.Request) {
+	groupId, err := pathGroupId(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	req := new(groupDevicesRequest)
+	if err := decodeBody(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(req.DeviceIds) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("device_ids is required"))
+		return
+	}
+
+	ok, err := s.tuyaClient.AddGroupDevices(groupId, req.DeviceIds)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, ok)
+}
+
+// removeGroupDevices takes the devices to remove as a comma separated device_ids query param
+func (s *Server) removeGroupDevices(w http.ResponseWriter, r *http.Request) {
+	groupId, err := pathGroupId(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	deviceIds := r.URL.Query().Get("device_ids")
+	if deviceIds == "" {
+		writeError(w, http.StatusBadRequest, errors.New("device_ids is required"))
+		return
+	}
+
+	ok, err := s.tuyaClient.RemoveGroupDevices(groupId, strings.Split(deviceIds, ","))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, ok)
+}
+
+func (s *Server) getGroupProperties(w http.ResponseWriter, r *http.Request) {
+	groupId, err := pathGroupId(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	properties, err := s.tuyaClient.GetGroupProperties(groupId)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, properties)
+}
+
+func (s *Server) sendGroupCommands(w http.ResponseWriter, r *http.Request) {
+	groupId, err := pathGroupId(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	req := new(groupCommandsRequest)
+	if err := decodeBody(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(req.Properties) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("properties is required"))
+		return
+	}
+
+	ok, err := s.tuyaClient.SendGroupCommands(groupId, req.Properties)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, ok)
+}
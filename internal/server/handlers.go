@@ -0,0 +1,85 @@
+package server
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+)
+
+func (s *Server) MapHandlers() {
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "running...\n")
+	})
+
+	// devices, routed over the LAN or the cloud
+	s.mux.HandleFunc("GET /api/v1/devices/{deviceId}/status", s.getDeviceStatus)
+	s.mux.HandleFunc("POST /api/v1/devices/{deviceId}/commands", s.sendDeviceCommands)
+
+	// groups
+	s.mux.HandleFunc("POST /api/v1/groups", s.createGroup)
+	s.mux.HandleFunc("GET /api/v1/groups", s.getGroups)
+	s.mux.HandleFunc("GET /api/v1/groups/{groupId}", s.getGroup)
+	s.mux.HandleFunc("PUT /api/v1/groups/{groupId}", s.renameGroup)
+	s.mux.HandleFunc("DELETE /api/v1/groups/{groupId}", s.deleteGroup)
+	s.mux.HandleFunc("GET /api/v1/groups/{groupId}/devices", s.getGroupDevices)
+	s.mux.HandleFunc("POST /api/v1/groups/{groupId}/devices", s.addGroupDevices)
+	s.mux.HandleFunc("DELETE /api/v1/groups/{groupId}/devices", s.removeGroupDevices)
+	s.mux.HandleFunc("GET /api/v1/groups/{groupId}/properties", s.getGroupProperties)
+	s.mux.HandleFunc("POST /api/v1/groups/{groupId}/commands", s.sendGroupCommands)
+
+	// door locks
+	s.mux.HandleFunc("POST /api/v1/devices/{deviceId}/door-lock/password-ticket", s.getPasswordTicket)
+	s.mux.HandleFunc("GET /api/v1/devices/{deviceId}/door-lock/temp-passwords", s.getTempPasswords)
+	s.mux.HandleFunc("POST /api/v1/devices/{deviceId}/door-lock/temp-passwords", s.createTempPassword)
+	s.mux.HandleFunc("DELETE /api/v1/devices/{deviceId}/door-lock/temp-passwords/{passwordId}", s.deleteTempPassword)
+	s.mux.HandleFunc("POST /api/v1/devices/{deviceId}/door-lock/unlock", s.remoteUnlock)
+	s.mux.HandleFunc("GET /api/v1/devices/{deviceId}/door-lock/unlock-records", s.getUnlockRecords)
+
+	// infrared remotes
+	s.mux.HandleFunc("GET /api/v1/infrareds/{infraredId}/remotes", s.getIrRemotes)
+	s.mux.HandleFunc("GET /api/v1/infrareds/{infraredId}/remotes/{remoteId}/keys", s.getIrRemoteKeys)
+	s.mux.HandleFunc("POST /api/v1/infrareds/{infraredId}/remotes/{remoteId}/keys", s.sendIrKey)
+	s.mux.HandleFunc("POST /api/v1/infrareds/{infraredId}/remotes/{remoteId}/ac-state", s.sendAcState)
+	s.mux.HandleFunc("POST /api/v1/infrareds/{infraredId}/remotes/{remoteId}/learning-codes", s.sendIrLearningCode)
+	s.mux.HandleFunc("PUT /api/v1/infrareds/{infraredId}/learning-state", s.setIrLearningState)
+	s.mux.HandleFunc("GET /api/v1/infrareds/{infraredId}/learning-codes", s.getIrLearningCode)
+	s.mux.HandleFunc("POST /api/v1/infrareds/{infraredId}/learning-codes", s.saveIrLearningCodes)
+
+	// firmware
+	s.mux.HandleFunc("GET /api/v1/devices/{deviceId}/firmware", s.getFirmwareInfo)
+	s.mux.HandleFunc("POST /api/v1/devices/{deviceId}/firmware/{type}/upgrade", s.upgradeFirmware)
+	s.mux.HandleFunc("GET /api/v1/firmware/upgrades", s.getFleetUpgrades)
+
+	// statistics
+	s.mux.HandleFunc("GET /api/v1/devices/{deviceId}/statistic-types", s.getStatisticTypes)
+	s.mux.HandleFunc("GET /api/v1/statistics", s.getStatistics)
+
+	// cameras
+	s.mux.HandleFunc("GET /api/v1/devices/{deviceId}/stream", s.allocateStream)
+	s.mux.HandleFunc("GET /api/v1/devices/{deviceId}/snapshot", s.getSnapshot)
+
+	// events
+	s.mux.HandleFunc("GET /api/v1/events", s.streamEvents)
+	s.mux.HandleFunc("GET /api/v1/ws", s.serveWebsocket)
+
+	// webhooks, with the admin token as subscriptions receive every event
+	s.mux.HandleFunc("POST /api/v1/webhooks", s.requireAdmin(s.createWebhook))
+	s.mux.HandleFunc("GET /api/v1/webhooks", s.requireAdmin(s.getWebhooks))
+	s.mux.HandleFunc("DELETE /api/v1/webhooks/{webhookId}", s.requireAdmin(s.deleteWebhook))
+	s.mux.HandleFunc("GET /api/v1/webhooks/dead-letters", s.requireAdmin(s.getDeadLetters))
+	s.mux.HandleFunc("POST /api/v1/webhooks/dead-letters/{deliveryId}/redeliver", s.requireAdmin(s.redeliverWebhook))
+
+	// local control
+	s.mux.HandleFunc("GET /api/v1/local/devices", s.getLocalDevices)
+	s.mux.HandleFunc("GET /api/v1/local/health", s.getRouteHealth)
+
+	// admin, with the admin token
+	s.mux.HandleFunc("GET /api/v1/admin/credentials", s.requireAdmin(s.getCredentials))
+	s.mux.HandleFunc("POST /api/v1/admin/credentials/promote", s.requireAdmin(s.promoteSecret))
+
+	// metrics, such as tuya_clock_skew_ms
+	s.mux.Handle("GET /debug/vars", expvar.Handler())
+
+	// signing proxy to the Tuya host, with the admin token
+	s.mux.HandleFunc("/tuya/{version}/{path...}", s.requireAdmin(s.proxyTuya))
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+)
+
+type irKeyRequest struct {
+	CategoryId int    `json:"category_id"`
+	KeyId      int    `json:"key_id"`
+	Key        string `json:"key"`
+}
+
+type irLearningStateRequest struct {
+	Learning bool `json:"learning"`
+}
+
+type irLearningCodesRequest struct {
+	CategoryId int                 `json:"category_id"`
+	BrandName  string              `json:"brand_name"`
+	RemoteName string              `json:"remote_name"`
+	Codes      []tuya.IrLearnedKey `json:"codes"`
+}
+
+type irCodeRequest struct {
+	Code string `json:"code"`
+}
+
+func (s *Server) getIrRemotes(w http.ResponseWriter, r *http.Request) {
+	remotes, err := s.tuyaClient.GetIrRemotes(r.PathValue("infraredId"))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, remotes)
+}
+
+func (s *Server) getIrRemoteKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.tuyaClient.GetIrRemoteKeys(r.PathValue("infraredId"), r.PathValue("remoteId"))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, keys)
+}
+
+func (s *Server) sendIrKey(w http.ResponseWriter, r *http.Request) {
+	req := new(irKeyRequest)
+	if err := decodeBody(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Key == "" {
+		writeError(w, http.StatusBadRequest, errors.New("key is required"))
+		return
+	}
+
+	ok, err := s.tuyaClient.SendIrKey(r.PathValue("infraredId"), r.PathValue("remoteId"), req.CategoryId, req.KeyId, req.Key)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, ok)
+}
+
+func (s *Server) sendAcState(w http.ResponseWriter, r *http.Request) {
+	state := tuya.AcState{}
+	if err := decodeBody(r, &state); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ok, err := s.tuyaClient.SendAcState(r.PathValue("infraredId"), r.PathValue("remoteId"), state)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, ok)
+}
+
+func (s *Server) setIrLearningState(w http.ResponseWriter, r *http.Request) {
+	req := new(irLearningStateRequest)
+	if err := decodeBody(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ok, err := s.tuyaClient.SetIrLearningState(r.PathValue("infraredId"), req.Learning)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, ok)
+}
+
+func (s *Server) getIrLearningCode(w http.ResponseWriter, r *http.Request) {
+	learningTime, err := strconv.ParseInt(r.URL.Query().Get("learning_time"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("learning_time is required"))
+		return
+	}
+
+	code, err := s.tuyaClient.GetIrLearningCode(r.PathValue("infraredId"), learningTime)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, code)
+}
+
+func (s *Server) saveIrLearningCodes(w http.ResponseWriter, r *http.Request) {
+	req := new(irLearningCodesRequest)
+	if err := decodeBody(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.RemoteName == "" || len(req.Codes) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("remote_name and codes are required"))
+		return
+	}
+
+	remoteId, err := s.tuyaClient.SaveIrLearningCodes(r.PathValue("infraredId"), req.CategoryId, req.BrandName, req.RemoteName, req.Codes)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, map[string]string{"remote_id": remoteId})
+}
+
+func (s *Server) sendIrLearningCode(w http.ResponseWriter, r *http.Request) {
+	req := new(irCodeRequest)
+	if err := decodeBody(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Code == "" {
+		writeError(w, http.StatusBadRequest, errors.New("code is required"))
+		return
+	}
+
+	ok, err := s.tuyaClient.SendIrLearningCode(r.PathValue("infraredId"), r.PathValue("remoteId"), req.Code)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, ok)
+}
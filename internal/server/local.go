@@ -0,0 +1,35 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/varjangn/tuya-middleware/pkg/tuyalocal"
+)
+
+// getLocalDevices lists the devices heard on the LAN merged with the inventory,
+// online=true keeps the ones heard recently and known=true the ones of the project
+func (s *Server) getLocalDevices(w http.ResponseWriter, r *http.Request) {
+	if !s.cfg.Local.Enabled {
+		writeError(w, http.StatusServiceUnavailable, errors.New("local control is disabled"))
+		return
+	}
+
+	query := r.URL.Query()
+	devices := []tuyalocal.LocalDevice{}
+	for _, device := range s.discovery.Devices() {
+		if query.Get("online") == "true" && !device.Online {
+			continue
+		}
+		if query.Get("known") == "true" && !device.Known {
+			continue
+		}
+		devices = append(devices, device)
+	}
+	writeResult(w, devices)
+}
+
+// getRouteHealth reports the local and cloud path health of every device routed so far
+func (s *Server) getRouteHealth(w http.ResponseWriter, r *http.Request) {
+	writeResult(w, s.router.Health())
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+)
+
+func (s *Server) getPasswordTicket(w http.ResponseWriter, r *http.Request) {
+	ticket, err := s.tuyaClient.GetPasswordTicket(r.PathValue("deviceId"))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, ticket)
+}
+
+func (s *Server) createTempPassword(w http.ResponseWriter, r *http.Request) {
+	params := tuya.TempPasswordParams{}
+	if err := decodeBody(r, &params); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if params.Name == "" || params.Password == "" {
+		writeError(w, http.StatusBadRequest, errors.New("name and password are required"))
+		return
+	}
+	if params.InvalidTime <= params.EffectiveTime {
+		writeError(w, http.StatusBadRequest, errors.New("invalid_time must be after effective_time"))
+		return
+	}
+
+	passwordId, err := s.tuyaClient.CreateTempPassword(r.PathValue("deviceId"), params)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, map[string]int64{"id": passwordId})
+}
+
+func (s *Server) getTempPasswords(w http.ResponseWriter, r *http.Request) {
+	valid := r.URL.Query().Get("valid") != "false"
+
+	passwords, err := s.tuyaClient.GetTempPasswords(r.PathValue("deviceId"), valid)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, passwords)
+}
+
+func (s *Server) deleteTempPassword(w http.ResponseWriter, r *http.Request) {
+	passwordId, err := strconv.ParseInt(r.PathValue("passwordId"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid password id %q", r.PathValue("passwordId")))
+		return
+	}
+
+	ok, err := s.tuyaClient.DeleteTempPassword(r.PathValue("deviceId"), passwordId)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, ok)
+}
+
+func (s *Server) remoteUnlock(w http.ResponseWriter, r *http.Request) {
+	ok, err := s.tuyaClient.RemoteUnlock(r.PathValue("deviceId"))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, ok)
+}
+
+func (s *Server) getUnlockRecords(w http.ResponseWriter, r *http.Request) {
+	startTime, _ := strconv.ParseInt(r.URL.Query().Get("start_time"), 10, 64)
+	endTime, _ := strconv.ParseInt(r.URL.Query().Get("end_time"), 10, 64)
+
+	records, err := s.tuyaClient.GetUnlockRecords(r.PathValue("deviceId"),
+		queryInt(r, "page_no", 1), queryInt(r, "page_size", 20), startTime, endTime)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, records)
+}
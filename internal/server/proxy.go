@@ -0,0 +1,122 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+)
+
+const maxProxyBodySize = 1 << 20
+
+// proxyAllowed tells whether a request is matched by one of the Allow patterns
+func proxyAllowed(allow []string, method, urlPath string) bool {
+	for _, rule := range allow {
+		ruleMethod, pattern, ok := strings.Cut(strings.TrimSpace(rule), " ")
+		if !ok {
+			continue
+		}
+		if ruleMethod != "*" && !strings.EqualFold(ruleMethod, method) {
+			continue
+		}
+		if matchPath(strings.TrimSpace(pattern), urlPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath matches a path against a pattern where * matches one segment and
+// a trailing /** any sub path
+func matchPath(pattern, urlPath string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "/**"); ok {
+		if urlPath == prefix {
+			return true
+		}
+		patternSegments := strings.Split(prefix, "/")
+		pathSegments := strings.Split(urlPath, "/")
+		if len(pathSegments) <= len(patternSegments) {
+			return false
+		}
+		return matchPath(prefix, strings.Join(pathSegments[:len(patternSegments)], "/"))
+	}
+	matched, err := path.Match(pattern, urlPath)
+	return err == nil && matched
+}
+
+// hasDotSegment tells whether a decoded path has . or .. segments, which
+// would escape the allowlisted prefixes once resolved upstream
+func hasDotSegment(urlPath string) bool {
+	for _, segment := range strings.Split(urlPath, "/") {
+		if segment == "." || segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyTuya forwards /tuya/{version}/... to the same path of the Tuya host,
+// signed with the managed access token
+func (s *Server) proxyTuya(w http.ResponseWriter, r *http.Request) {
+	if !s.cfg.Proxy.Enabled {
+		writeError(w, http.StatusServiceUnavailable, errors.New("the tuya proxy is disabled"))
+		return
+	}
+
+	// the signature covers the decoded path, escapes would make it differ
+	// from the path Tuya receives
+	if r.URL.EscapedPath() != r.URL.Path {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%s has escaped characters", r.URL.EscapedPath()))
+		return
+	}
+	urlPath := strings.TrimPrefix(r.URL.Path, "/tuya")
+	if hasDotSegment(urlPath) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%s has . or .. segments", urlPath))
+		return
+	}
+	if !proxyAllowed(s.cfg.Proxy.Allow, r.Method, urlPath) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("%s %s is not allowed by the proxy allowlist", r.Method, urlPath))
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxProxyBodySize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("the body exceeds %d bytes", tooLarge.Limit))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	target := urlPath
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+
+	// the nonce and the headers listed in Signature-Headers are signed along
+	opts := tuya.SignOptions{Nonce: r.Header.Get("nonce")}
+	if keys := r.Header.Get("Signature-Headers"); keys != "" {
+		opts.Headers = map[string]string{}
+		for _, key := range strings.Split(keys, ":") {
+			opts.Headers[key] = r.Header.Get(key)
+		}
+	}
+
+	resp, err := s.tuyaClient.Do(r.Method, target, body, opts)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/pkg/logger"
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+)
+
+func TestProxyAllowed(t *testing.T) {
+	allow := []string{"GET /v1.0/devices/*/logs", "* /v2.0/cloud/thing/**"}
+	for _, tt := range []struct {
+		method, path string
+		want         bool
+	}{
+		{"GET", "/v1.0/devices/d1/logs", true},
+		{"POST", "/v1.0/devices/d1/logs", false},
+		{"GET", "/v1.0/devices/d1/d2/logs", false},
+		{"POST", "/v2.0/cloud/thing", true},
+		{"DELETE", "/v2.0/cloud/thing/d1/shadow/properties", true},
+		{"GET", "/v2.0/cloud/things", false},
+	} {
+		if got := proxyAllowed(allow, tt.method, tt.path); got != tt.want {
+			t.Errorf("proxyAllowed(%s %s) = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestProxyTuya(t *testing.T) {
+	type forwarded struct {
+		method, uri, body string
+	}
+	var upstream *forwarded
+	cloud := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("sign") != tuya.Sign(r, body, "secret") {
+			t.Errorf("%s %s forwarded with a signature Tuya rejects", r.Method, r.URL.RequestURI())
+		}
+		upstream = &forwarded{r.Method, r.URL.RequestURI(), string(body)}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"success":true,"result":{}}`)
+	}))
+	defer cloud.Close()
+
+	cfg := &config.Config{
+		Logger: config.Logger{Level: "fatal", Encoding: "console"},
+		Tuya:   config.Tuya{Host: cloud.URL, ClientId: "client", Secret: "secret"},
+		Proxy:  config.Proxy{Enabled: true, Allow: []string{"GET /v1.0/devices/*/logs", "POST /v1.0/devices/*/commands"}},
+		Admin:  config.Admin{Token: "s3cret"},
+	}
+	appLogger := logger.NewAppLogger(cfg)
+	appLogger.InitLogger()
+	tuya.SetActiveToken(&tuya.TokenResponse{Result: tuya.Token{AccessToken: "token"}})
+	defer tuya.SetActiveToken(&tuya.TokenResponse{})
+	s := &Server{logger: appLogger, cfg: cfg, tuyaClient: tuya.NewTuyaClient(appLogger, cfg), mux: http.NewServeMux()}
+	s.mux.HandleFunc("/tuya/{version}/{path...}", s.requireAdmin(s.proxyTuya))
+
+	command := `{"commands":[{"code":"switch_1","value":true}]}`
+	for _, tt := range []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		token    string
+		want     int
+		upstream *forwarded
+	}{
+		{"allowed", "GET", "/tuya/v1.0/devices/d1/logs?type=7&start_time=0", "", "s3cret", http.StatusOK,
+			&forwarded{"GET", "/v1.0/devices/d1/logs?type=7&start_time=0", ""}},
+		{"body", "POST", "/tuya/v1.0/devices/d1/commands", command, "s3cret", http.StatusOK,
+			&forwarded{"POST", "/v1.0/devices/d1/commands", command}},
+		{"body too large", "POST", "/tuya/v1.0/devices/d1/commands", strings.Repeat("x", maxProxyBodySize+1), "s3cret", http.StatusRequestEntityTooLarge, nil},
+		{"not allowed", "GET", "/tuya/v1.0/devices/d1/commands", "", "s3cret", http.StatusForbidden, nil},
+		{"escaped slash", "GET", "/tuya/v1.0/devices/d1%2Fd2/logs", "", "s3cret", http.StatusBadRequest, nil},
+		{"escaped characters", "GET", "/tuya/v1.0/devices/d%31/logs", "", "s3cret", http.StatusBadRequest, nil},
+		{"encoded dot segments", "GET", "/tuya/v1.0/devices/%2e%2e/users/logs", "", "s3cret", http.StatusBadRequest, nil},
+		{"no token", "GET", "/tuya/v1.0/devices/d1/logs", "", "", http.StatusUnauthorized, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream = nil
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			s.mux.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			switch {
+			case tt.upstream == nil && upstream != nil:
+				t.Fatalf("forwarded %+v, want nothing forwarded", *upstream)
+			case tt.upstream != nil && (upstream == nil || *upstream != *tt.upstream):
+				t.Fatalf("forwarded %+v, want %+v", upstream, *tt.upstream)
+			}
+		})
+	}
+}
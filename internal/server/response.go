@@ -0,0 +1,45 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+type errorResponse struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error"`
+}
+
+type resultResponse struct {
+	Success bool        `json:"success"`
+	Result  interface{} `json:"result"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(payload)
+}
+
+func writeResult(w http.ResponseWriter, result interface{}) {
+	writeJSON(w, http.StatusOK, resultResponse{Success: true, Result: result})
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Success: false, Error: err.Error()})
+}
+
+func decodeBody(r *http.Request, v interface{}) error {
+	defer r.Body.Close()
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+// queryInt returns the integer query param for key, or def when it is missing or invalid
+func queryInt(r *http.Request, key string, def int) int {
+	value, err := strconv.Atoi(r.URL.Query().Get(key))
+	if err != nil || value <= 0 {
+		return def
+	}
+	return value
+}
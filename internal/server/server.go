@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/internal/router"
+	"github.com/varjangn/tuya-middleware/internal/webhooks"
+	"github.com/varjangn/tuya-middleware/pkg/logger"
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+	"github.com/varjangn/tuya-middleware/pkg/tuya/events"
+	"github.com/varjangn/tuya-middleware/pkg/tuyalocal"
+)
+
+type Server struct {
+	logger     *logger.AppLogger
+	cfg        *config.Config
+	tuyaClient *tuya.TuyaClient
+	mux        *http.ServeMux
+	streams    *streamCache
+	bus        *events.Bus
+	categories *deviceCategories
+	webhooks   *webhooks.Dispatcher
+	discovery  *tuyalocal.Discovery
+	router     *router.Router
+}
+
+func NewServer(logger *logger.AppLogger, cfg *config.Config, tuyaClient *tuya.TuyaClient, bus *events.Bus) *Server {
+	s := &Server{
+		logger:     logger,
+		cfg:        cfg,
+		tuyaClient: tuyaClient,
+		mux:        http.NewServeMux(),
+		streams:    newStreamCache(cfg.Camera.StreamTTL),
+		bus:        bus,
+		categories: newDeviceCategories(),
+	}
+	s.webhooks = webhooks.NewDispatcher(logger, cfg, s.deviceCategory)
+	s.discovery = tuyalocal.NewDiscovery(logger, cfg, tuyaClient)
+	s.router = router.NewRouter(logger, cfg, tuyaClient, s.discovery)
+	return s
+}
+
+// Router routes the device requests over the LAN or the cloud, shared with
+// the MQTT bridge
+func (s *Server) Router() *router.Router {
+	return s.router
+}
+
+func (s *Server) Run() error {
+	s.MapHandlers()
+	go s.webhooks.Run(context.Background(), s.bus)
+	if s.cfg.Local.Enabled {
+		go func() {
+			if err := s.discovery.Run(context.Background()); err != nil {
+				s.logger.Errorf("local discovery: %v", err)
+			}
+		}()
+	}
+
+	s.logger.Infof("server listening on %s", s.cfg.Server.Port)
+	return http.ListenAndServe(s.cfg.Server.Port, s.mux)
+}
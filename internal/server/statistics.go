@@ -0,0 +1,176 @@
+package server
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+)
+
+type statisticPoint struct {
+	Period string             `json:"period"`
+	Total  float64            `json:"total"`
+	Values map[string]float64 `json:"values"`
+}
+
+type statisticSeries struct {
+	Code        string           `json:"code"`
+	StatType    string           `json:"stat_type"`
+	Granularity string           `json:"granularity"`
+	Devices     []string         `json:"devices"`
+	Series      []statisticPoint `json:"series"`
+}
+
+func (s *Server) getStatisticTypes(w http.ResponseWriter, r *http.Request) {
+	types, err := s.tuyaClient.GetStatisticTypes(r.PathValue("deviceId"))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, types)
+}
+
+// statisticDeviceIds resolves the devices to aggregate, either from the
+// comma separated device_ids param or from every device of home_id
+func (s *Server) statisticDeviceIds(r *http.Request) ([]string, error) {
+	query := r.URL.Query()
+	if deviceIds := query.Get("device_ids"); deviceIds != "" {
+		return strings.Split(deviceIds, ","), nil
+	}
+	if homeId := query.Get("home_id"); homeId != "" {
+		devices, err := s.tuyaClient.GetHomeDevices(homeId)
+		if err != nil {
+			return nil, err
+		}
+		deviceIds := make([]string, 0, len(devices))
+		for _, device := range devices {
+			deviceIds = append(deviceIds, device.Id)
+		}
+		return deviceIds, nil
+	}
+	return nil, errors.New("device_ids or home_id is required")
+}
+
+// getStatistics aggregates the statistics of a DP code across several devices
+// into a single time series, returned as JSON or CSV (format=csv)
+func (s *Server) getStatistics(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	code := query.Get("code")
+	granularity := query.Get("granularity")
+	start, end := query.Get("start"), query.Get("end")
+	statType := query.Get("stat_type")
+	if statType == "" {
+		statType = "sum"
+	}
+	if code == "" || granularity == "" || start == "" || end == "" {
+		writeError(w, http.StatusBadRequest, errors.New("code, granularity, start and end are required"))
+		return
+	}
+	if !tuya.IsStatisticGranularity(granularity) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown granularity %q, use hour, day or month", granularity))
+		return
+	}
+	for _, period := range []string{start, end} {
+		if err := checkStatisticPeriod(granularity, period); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	format := query.Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown format %q, use json or csv", format))
+		return
+	}
+
+	deviceIds, err := s.statisticDeviceIds(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	points := map[string]*statisticPoint{}
+	for _, deviceId := range deviceIds {
+		values, err := s.tuyaClient.GetStatistics(deviceId, code, statType, granularity, start, end)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, fmt.Errorf("statistics for %s: %w", deviceId, err))
+			return
+		}
+		for period, raw := range values {
+			value, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				s.logger.Warnf("statistics for %s: invalid value %q for %s", deviceId, raw, period)
+				continue
+			}
+			point, ok := points[period]
+			if !ok {
+				point = &statisticPoint{Period: period, Values: map[string]float64{}}
+				points[period] = point
+			}
+			point.Values[deviceId] = value
+			point.Total += value
+		}
+	}
+
+	series := statisticSeries{
+		Code:        code,
+		StatType:    statType,
+		Granularity: granularity,
+		Devices:     deviceIds,
+		Series:      make([]statisticPoint, 0, len(points)),
+	}
+	for _, point := range points {
+		series.Series = append(series.Series, *point)
+	}
+	// periods are zero padded timestamps so they sort lexically
+	sort.Slice(series.Series, func(i, j int) bool {
+		return series.Series[i].Period < series.Series[j].Period
+	})
+
+	if format == "csv" {
+		writeStatisticsCSV(w, series)
+		return
+	}
+	writeResult(w, series)
+}
+
+// statisticPeriodLayouts are the start and end formats per granularity
+var statisticPeriodLayouts = map[string]string{
+	tuya.StatisticHour:  "2006010215",
+	tuya.StatisticDay:   "20060102",
+	tuya.StatisticMonth: "200601",
+}
+
+func checkStatisticPeriod(granularity, period string) error {
+	layout := statisticPeriodLayouts[granularity]
+	if _, err := time.Parse(layout, period); err != nil || len(period) != len(layout) {
+		return fmt.Errorf("%q is not a %s period, use %s", period, granularity, strings.NewReplacer("2006", "yyyy", "01", "MM", "02", "dd", "15", "HH").Replace(layout))
+	}
+	return nil
+}
+
+func writeStatisticsCSV(w http.ResponseWriter, series statisticSeries) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-%s.csv", series.Code, series.Granularity))
+
+	writer := csv.NewWriter(w)
+	writer.Write(append([]string{"period", "total"}, series.Devices...))
+	for _, point := range series.Series {
+		row := []string{point.Period, strconv.FormatFloat(point.Total, 'f', -1, 64)}
+		for _, deviceId := range series.Devices {
+			value, ok := point.Values[deviceId]
+			if !ok {
+				row = append(row, "")
+				continue
+			}
+			row = append(row, strconv.FormatFloat(value, 'f', -1, 64))
+		}
+		writer.Write(row)
+	}
+	writer.Flush()
+}
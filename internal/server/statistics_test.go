@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/pkg/logger"
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+)
+
+// daily statistics of add_ele per device, d3 has an invalid value
+var testStatistics = map[string]map[string]string{
+	"d1": {"20240101": "1.5", "20240102": "2"},
+	"d2": {"20240102": "0.25", "20240103": "4"},
+	"d3": {"20240101": "n/a", "20240103": "1"},
+}
+
+func statisticsServer(t *testing.T) (*Server, *atomic.Int32) {
+	t.Helper()
+	requests := &atomic.Int32{}
+	cloud := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		var result interface{}
+		switch parts := strings.Split(r.URL.Path, "/"); {
+		case r.URL.Path == "/v1.0/homes/h1/devices":
+			result = []tuya.Device{{Id: "d1"}, {Id: "d2"}}
+		case len(parts) == 6 && parts[4] == "statistics" && parts[5] == "days":
+			if r.URL.Query().Get("start_day") == "" || r.URL.Query().Get("code") != "add_ele" {
+				t.Errorf("statistics query %s", r.URL.RawQuery)
+			}
+			result = tuya.Statistics{Days: testStatistics[parts[3]]}
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": result})
+	}))
+	t.Cleanup(cloud.Close)
+
+	cfg := &config.Config{
+		Logger: config.Logger{Level: "fatal", Encoding: "console"},
+		Tuya:   config.Tuya{Host: cloud.URL, ClientId: "client", Secret: "secret"},
+	}
+	appLogger := logger.NewAppLogger(cfg)
+	appLogger.InitLogger()
+	tuya.SetActiveToken(&tuya.TokenResponse{Result: tuya.Token{AccessToken: "token"}})
+	t.Cleanup(func() { tuya.SetActiveToken(&tuya.TokenResponse{}) })
+	return &Server{logger: appLogger, cfg: cfg, tuyaClient: tuya.NewTuyaClient(appLogger, cfg)}, requests
+}
+
+func getStatistics(s *Server, query string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.getStatistics(rec, httptest.NewRequest(http.MethodGet, "/api/v1/statistics?"+query, nil))
+	return rec
+}
+
+func TestStatisticsValidation(t *testing.T) {
+	s, requests := statisticsServer(t)
+	for _, query := range []string{
+		"device_ids=d1&granularity=day&start=20240101&end=20240103",
+		"code=add_ele&device_ids=d1&granularity=week&start=20240101&end=20240103",
+		"code=add_ele&device_ids=d1&granularity=day&start=2024010100&end=20240103",
+		"code=add_ele&device_ids=d1&granularity=hour&start=2024010100&end=2024013125",
+		"code=add_ele&device_ids=d1&granularity=month&start=202401&end=202413",
+		"code=add_ele&device_ids=d1&granularity=day&start=20240101&end=20240103&format=xml",
+		"code=add_ele&granularity=day&start=20240101&end=20240103",
+	} {
+		if rec := getStatistics(s, query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s = %d, want 400", query, rec.Code)
+		}
+	}
+	if n := requests.Load(); n != 0 {
+		t.Fatalf("%d requests sent for invalid queries", n)
+	}
+}
+
+func TestStatisticsAggregation(t *testing.T) {
+	s, _ := statisticsServer(t)
+	rec := getStatistics(s, "code=add_ele&device_ids=d1,d2,d3&granularity=day&start=20240101&end=20240103")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Result statisticSeries `json:"result"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := statisticSeries{
+		Code:        "add_ele",
+		StatType:    "sum",
+		Granularity: "day",
+		Devices:     []string{"d1", "d2", "d3"},
+		Series: []statisticPoint{
+			{Period: "20240101", Total: 1.5, Values: map[string]float64{"d1": 1.5}},
+			{Period: "20240102", Total: 2.25, Values: map[string]float64{"d1": 2, "d2": 0.25}},
+			{Period: "20240103", Total: 5, Values: map[string]float64{"d2": 4, "d3": 1}},
+		},
+	}
+	if !reflect.DeepEqual(resp.Result, want) {
+		t.Fatalf("series =\n%+v\nwant\n%+v", resp.Result, want)
+	}
+}
+
+func TestStatisticsCSV(t *testing.T) {
+	s, _ := statisticsServer(t)
+	rec := getStatistics(s, "code=add_ele&home_id=h1&granularity=day&start=20240101&end=20240103&format=csv")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/csv" {
+		t.Fatalf("Content-Type = %s", ct)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); cd != "attachment; filename=add_ele-day.csv" {
+		t.Fatalf("Content-Disposition = %s", cd)
+	}
+	want := "period,total,d1,d2\n" +
+		"20240101,1.5,1.5,\n" +
+		"20240102,2.25,2,0.25\n" +
+		"20240103,4,,4\n"
+	if rec.Body.String() != want {
+		t.Fatalf("csv =\n%s\nwant\n%s", rec.Body, want)
+	}
+}
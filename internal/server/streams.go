@@ -0,0 +1,160 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+)
+
+const (
+	defaultStreamTTL = 5 * time.Minute
+	// stream URLs are allocated again this long before Tuya expires them, so
+	// a viewer isn't handed a URL about to stop working
+	streamExpiryMargin = 30 * time.Second
+	// snapshots larger than this are cut off with a 502
+	maxSnapshotSize = 10 << 20
+)
+
+var snapshotClient = &http.Client{Timeout: 10 * time.Second}
+
+type streamEntry struct {
+	url        string
+	expiresAt  time.Time
+	reuseUntil time.Time
+	err        error
+	ready      chan struct{}
+}
+
+// streamCache keeps allocated stream URLs until they expire, concurrent
+// requests for the same stream wait on a single allocation. The expiry is
+// the one Tuya returns, or ttl when it returns none.
+type streamCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*streamEntry
+}
+
+func newStreamCache(ttl time.Duration) *streamCache {
+	if ttl <= 0 {
+		ttl = defaultStreamTTL
+	}
+	return &streamCache{ttl: ttl, entries: map[string]*streamEntry{}}
+}
+
+func (c *streamCache) get(key string, allocate func() (*tuya.Stream, error)) (*streamEntry, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if ok {
+		select {
+		case <-entry.ready:
+			if !time.Now().Before(entry.reuseUntil) {
+				ok = false
+			}
+		default:
+			// allocation in flight
+		}
+	}
+	if !ok {
+		entry = &streamEntry{ready: make(chan struct{})}
+		c.entries[key] = entry
+		c.mu.Unlock()
+
+		stream, err := allocate()
+		now := time.Now()
+		if err == nil {
+			entry.url = stream.Url
+			entry.expiresAt = now.Add(c.ttl)
+			entry.reuseUntil = entry.expiresAt
+			if stream.ExpireTime > 0 {
+				entry.expiresAt = now.Add(time.Duration(stream.ExpireTime) * time.Second)
+				entry.reuseUntil = entry.expiresAt.Add(-streamExpiryMargin)
+			}
+		}
+		entry.err = err
+		if entry.err != nil {
+			c.mu.Lock()
+			delete(c.entries, key)
+			c.mu.Unlock()
+		}
+		close(entry.ready)
+		return entry, entry.err
+	}
+	c.mu.Unlock()
+
+	<-entry.ready
+	return entry, entry.err
+}
+
+func (s *Server) allocateStream(w http.ResponseWriter, r *http.Request) {
+	deviceId := r.PathValue("deviceId")
+	streamType := strings.ToUpper(r.URL.Query().Get("type"))
+	if streamType == "" {
+		streamType = tuya.StreamHLS
+	}
+	if !tuya.IsStreamType(streamType) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unsupported stream type %q, use RTSP, HLS, FLV or RTMP", streamType))
+		return
+	}
+
+	entry, err := s.streams.get(deviceId+"/"+streamType, func() (*tuya.Stream, error) {
+		return s.tuyaClient.AllocateStream(deviceId, streamType)
+	})
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, map[string]interface{}{
+		"url":        entry.url,
+		"type":       streamType,
+		"expires_at": entry.expiresAt.Unix(),
+	})
+}
+
+// getSnapshot captures a camera snapshot and serves the image itself, so
+// clients never see the short-lived Tuya URL
+func (s *Server) getSnapshot(w http.ResponseWriter, r *http.Request) {
+	snapshot, err := s.tuyaClient.CaptureSnapshot(r.PathValue("deviceId"))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, snapshot.Url, nil)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	resp, err := snapshotClient.Do(req)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		writeError(w, http.StatusBadGateway, fmt.Errorf("snapshot download: unexpected status %d", resp.StatusCode))
+		return
+	}
+	// read whole so a failed download is still answered with an error
+	image, err := io.ReadAll(io.LimitReader(resp.Body, maxSnapshotSize+1))
+	if err == nil && len(image) > maxSnapshotSize {
+		err = errors.New("snapshot download: image too large")
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(image)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(image)
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/pkg/logger"
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+)
+
+// cameraServer answers the camera endpoints of Tuya with the result built by
+// answer, and counts the requests
+func cameraServer(t *testing.T, answer func(r *http.Request) interface{}) (*Server, *atomic.Int32) {
+	t.Helper()
+	requests := &atomic.Int32{}
+	cloud := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": answer(r)})
+	}))
+	t.Cleanup(cloud.Close)
+
+	cfg := &config.Config{
+		Logger: config.Logger{Level: "fatal", Encoding: "console"},
+		Tuya:   config.Tuya{Host: cloud.URL, ClientId: "client", Secret: "secret"},
+		Camera: config.Camera{StreamTTL: time.Minute},
+	}
+	appLogger := logger.NewAppLogger(cfg)
+	appLogger.InitLogger()
+	tuya.SetActiveToken(&tuya.TokenResponse{Result: tuya.Token{AccessToken: "token"}})
+	t.Cleanup(func() { tuya.SetActiveToken(&tuya.TokenResponse{}) })
+	s := NewServer(appLogger, cfg, tuya.NewTuyaClient(appLogger, cfg), nil)
+	s.MapHandlers()
+	return s, requests
+}
+
+type streamResult struct {
+	Url       string `json:"url"`
+	Type      string `json:"type"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+func getStream(t *testing.T, s *Server, query string) (int, streamResult) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/devices/cam1/stream"+query, nil))
+	var resp struct {
+		Result streamResult `json:"result"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec.Code, resp.Result
+}
+
+func TestStreamType(t *testing.T) {
+	s, requests := cameraServer(t, func(r *http.Request) interface{} {
+		var body struct {
+			Type string `json:"type"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		return tuya.Stream{Url: "rtsp://camera/" + body.Type}
+	})
+
+	if code, _ := getStream(t, s, "?type=mjpeg"); code != http.StatusBadRequest {
+		t.Fatalf("unknown type = %d, want 400", code)
+	}
+	if n := requests.Load(); n != 0 {
+		t.Fatalf("%d allocations for an unknown type", n)
+	}
+
+	code, stream := getStream(t, s, "?type=rtsp")
+	if code != http.StatusOK || stream.Type != "RTSP" || stream.Url != "rtsp://camera/RTSP" {
+		t.Fatalf("rtsp = %d %+v", code, stream)
+	}
+	if code, stream := getStream(t, s, ""); code != http.StatusOK || stream.Type != "HLS" {
+		t.Fatalf("default type = %d %+v, want HLS", code, stream)
+	}
+}
+
+func TestStreamExpiry(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		expireTime  int64
+		wantExpiry  time.Duration
+		allocations int32
+	}{
+		{"expiry of Tuya", 600, 600 * time.Second, 1},
+		{"no expiry uses StreamTTL", 0, time.Minute, 1},
+		{"expiring within the margin", 20, 20 * time.Second, 3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			allocated := 0
+			s, requests := cameraServer(t, func(r *http.Request) interface{} {
+				allocated++
+				return tuya.Stream{Url: fmt.Sprintf("https://camera/%d.m3u8", allocated), ExpireTime: tt.expireTime}
+			})
+
+			for i := 0; i < 3; i++ {
+				code, stream := getStream(t, s, "?type=HLS")
+				if code != http.StatusOK {
+					t.Fatalf("status = %d", code)
+				}
+				expiresIn := time.Until(time.Unix(stream.ExpiresAt, 0))
+				if expiresIn < tt.wantExpiry-2*time.Second || expiresIn > tt.wantExpiry+time.Second {
+					t.Fatalf("expires in %s, want %s", expiresIn, tt.wantExpiry)
+				}
+			}
+			if n := requests.Load(); n != tt.allocations {
+				t.Fatalf("%d allocations, want %d", n, tt.allocations)
+			}
+		})
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	jpeg := []byte("\xff\xd8\xff\xe0 fake jpeg")
+	images := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write(jpeg)
+		case "/untyped":
+			w.Write(jpeg)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(images.Close)
+
+	for _, tt := range []struct {
+		name, path  string
+		code        int
+		contentType string
+	}{
+		{"image", "/image.jpg", http.StatusOK, "image/jpeg"},
+		{"detected content type", "/untyped", http.StatusOK, "image/jpeg"},
+		{"download failed", "/expired", http.StatusBadGateway, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := cameraServer(t, func(r *http.Request) interface{} {
+				if !strings.HasSuffix(r.URL.Path, "/devices/cam1/camera/actions/capture") || r.Method != http.MethodPost {
+					t.Errorf("capture request %s %s", r.Method, r.URL.Path)
+				}
+				return tuya.Snapshot{Url: images.URL + tt.path, ExpireTime: 60}
+			})
+
+			rec := httptest.NewRecorder()
+			s.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/devices/cam1/snapshot", nil))
+			if rec.Code != tt.code {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.code, rec.Body)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != tt.contentType {
+				t.Fatalf("Content-Type = %s, want %s", ct, tt.contentType)
+			}
+			if rec.Body.String() != string(jpeg) {
+				t.Fatalf("body = %q, want the image", rec.Body)
+			}
+		})
+	}
+}
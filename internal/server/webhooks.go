@@ -0,0 +1,54 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/varjangn/tuya-middleware/internal/webhooks"
+)
+
+// createWebhook registers a subscription, the response is the only place the
+// signing secret is returned
+func (s *Server) createWebhook(w http.ResponseWriter, r *http.Request) {
+	req := webhooks.Subscription{}
+	if err := decodeBody(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	sub, err := s.webhooks.AddSubscription(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeResult(w, sub)
+}
+
+func (s *Server) getWebhooks(w http.ResponseWriter, r *http.Request) {
+	writeResult(w, s.webhooks.Subscriptions())
+}
+
+func (s *Server) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := s.webhooks.RemoveSubscription(r.PathValue("webhookId")); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeResult(w, true)
+}
+
+func (s *Server) getDeadLetters(w http.ResponseWriter, r *http.Request) {
+	writeResult(w, s.webhooks.DeadLetters())
+}
+
+func (s *Server) redeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	delivery, err := s.webhooks.Redeliver(r.PathValue("deliveryId"))
+	if errors.Is(err, webhooks.ErrNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResult(w, delivery)
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/pkg/logger"
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+	"github.com/varjangn/tuya-middleware/pkg/tuya/events"
+)
+
+func TestWebhookRoutesRequireAdmin(t *testing.T) {
+	cfg := &config.Config{
+		Logger: config.Logger{Level: "fatal", Encoding: "console"},
+		Admin:  config.Admin{Token: "s3cret"},
+	}
+	appLogger := logger.NewAppLogger(cfg)
+	appLogger.InitLogger()
+	s := NewServer(appLogger, cfg, tuya.NewTuyaClient(appLogger, cfg), events.NewBus(0))
+	s.MapHandlers()
+
+	for _, tt := range []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodPost, "/api/v1/webhooks", `{"url": "http://localhost/hook"}`, http.StatusOK},
+		{http.MethodGet, "/api/v1/webhooks", "", http.StatusOK},
+		{http.MethodDelete, "/api/v1/webhooks/unknown", "", http.StatusNotFound},
+		{http.MethodGet, "/api/v1/webhooks/dead-letters", "", http.StatusOK},
+		{http.MethodPost, "/api/v1/webhooks/dead-letters/unknown/redeliver", "", http.StatusNotFound},
+	} {
+		for _, authorization := range []string{"", "Bearer s3cret"} {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if authorization != "" {
+				req.Header.Set("Authorization", authorization)
+			}
+			rec := httptest.NewRecorder()
+			s.mux.ServeHTTP(rec, req)
+
+			want := tt.want
+			if authorization == "" {
+				want = http.StatusUnauthorized
+			}
+			if rec.Code != want {
+				t.Errorf("%s %s with %q = %d, want %d", tt.method, tt.path, authorization, rec.Code, want)
+			}
+		}
+	}
+}
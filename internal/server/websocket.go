@@ -0,0 +1,235 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+	"github.com/varjangn/tuya-middleware/pkg/tuya/events"
+)
+
+const (
+	wsWriteWait        = 10 * time.Second
+	wsPongWait         = 60 * time.Second
+	wsPingInterval     = wsPongWait * 9 / 10
+	wsMaxMessageSize   = 64 * 1024
+	wsSendQueueSize    = 256
+	wsMaxInflightCalls = 8
+)
+
+// message types of the websocket protocol
+const (
+	wsSubscribe   = "subscribe"
+	wsUnsubscribe = "unsubscribe"
+	wsCommand     = "command"
+	wsAck         = "ack"
+	wsError       = "error"
+	wsEvent       = "event"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// message sent by clients, Id correlates the ack or error reply
+type wsRequest struct {
+	Id        string         `json:"id"`
+	Type      string         `json:"type"`
+	DeviceIds []string       `json:"device_ids,omitempty"`
+	DeviceId  string         `json:"device_id,omitempty"`
+	Commands  []tuya.Command `json:"commands,omitempty"`
+}
+
+type wsReply struct {
+	Id        string       `json:"id,omitempty"`
+	Type      string       `json:"type"`
+	Error     string       `json:"error,omitempty"`
+	Result    interface{}  `json:"result,omitempty"`
+	EventId   uint64       `json:"event_id,omitempty"`
+	EventType string       `json:"event_type,omitempty"`
+	Event     events.Event `json:"event,omitempty"`
+}
+
+// wsConn is a client connection, replies and events are queued on send and
+// written by a single writer. A client that doesn't drain its queue is disconnected.
+type wsConn struct {
+	server   *Server
+	conn     *websocket.Conn
+	send     chan wsReply
+	done     chan struct{}
+	once     sync.Once
+	inflight chan struct{}
+
+	mu      sync.RWMutex
+	devices map[string]bool
+}
+
+func (s *Server) serveWebsocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.logger.Warnf("websocket upgrade: %v", err)
+		return
+	}
+
+	c := &wsConn{
+		server:   s,
+		conn:     conn,
+		send:     make(chan wsReply, wsSendQueueSize),
+		done:     make(chan struct{}),
+		inflight: make(chan struct{}, wsMaxInflightCalls),
+		devices:  map[string]bool{},
+	}
+	sub, _ := s.bus.Subscribe(0, c.subscribed)
+
+	go c.writePump()
+	go c.eventPump(sub)
+	c.readPump()
+}
+
+// subscribed is the bus filter of the connection
+func (c *wsConn) subscribed(event events.Event) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.devices[event.Meta().DeviceId]
+}
+
+func (c *wsConn) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// enqueue queues a message for the writer, closing the connection when the
+// client is too slow to keep up
+func (c *wsConn) enqueue(reply wsReply) {
+	select {
+	case c.send <- reply:
+	case <-c.done:
+	default:
+		c.server.logger.Warnf("websocket client %s is too slow, disconnecting", c.conn.RemoteAddr())
+		c.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "send queue full"),
+			time.Now().Add(wsWriteWait))
+		c.close()
+	}
+}
+
+func (c *wsConn) readPump() {
+	defer c.close()
+
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		req := wsRequest{}
+		if err := json.Unmarshal(data, &req); err != nil {
+			c.enqueue(wsReply{Type: wsError, Error: fmt.Sprintf("invalid message: %v", err)})
+			continue
+		}
+		c.handle(req)
+	}
+}
+
+func (c *wsConn) handle(req wsRequest) {
+	switch req.Type {
+	case wsSubscribe, wsUnsubscribe:
+		if len(req.DeviceIds) == 0 {
+			c.enqueue(wsReply{Id: req.Id, Type: wsError, Error: "device_ids is required"})
+			return
+		}
+		c.mu.Lock()
+		for _, deviceId := range req.DeviceIds {
+			if req.Type == wsSubscribe {
+				c.devices[deviceId] = true
+			} else {
+				delete(c.devices, deviceId)
+			}
+		}
+		c.mu.Unlock()
+		c.enqueue(wsReply{Id: req.Id, Type: wsAck})
+	case wsCommand:
+		if req.DeviceId == "" || len(req.Commands) == 0 {
+			c.enqueue(wsReply{Id: req.Id, Type: wsError, Error: "device_id and commands are required"})
+			return
+		}
+		select {
+		case c.inflight <- struct{}{}:
+		default:
+			c.enqueue(wsReply{Id: req.Id, Type: wsError, Error: "too many commands in flight"})
+			return
+		}
+		go func() {
+			defer func() { <-c.inflight }()
+			result, err := c.server.router.SendCommands(context.Background(), req.DeviceId, req.Commands)
+			if err != nil {
+				c.enqueue(wsReply{Id: req.Id, Type: wsError, Error: err.Error()})
+				return
+			}
+			c.enqueue(wsReply{Id: req.Id, Type: wsAck, Result: result})
+		}()
+	default:
+		c.enqueue(wsReply{Id: req.Id, Type: wsError, Error: fmt.Sprintf("unknown message type %q", req.Type)})
+	}
+}
+
+func (c *wsConn) eventPump(sub *events.Subscription) {
+	defer sub.Close()
+	for {
+		select {
+		case <-c.done:
+			return
+		case record, ok := <-sub.C:
+			if !ok {
+				c.server.logger.Warnf("websocket client %s fell behind the event bus, disconnecting", c.conn.RemoteAddr())
+				c.close()
+				return
+			}
+			c.enqueue(wsReply{
+				Type:      wsEvent,
+				EventId:   record.Id,
+				EventType: string(record.Event.Type()),
+				Event:     record.Event,
+			})
+		}
+	}
+}
+
+func (c *wsConn) writePump() {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	defer c.close()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case reply := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteJSON(reply); err != nil {
+				if !errors.Is(err, websocket.ErrCloseSent) {
+					c.server.logger.Debugf("websocket write: %v", err)
+				}
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,420 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/pkg/logger"
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+	"github.com/varjangn/tuya-middleware/pkg/tuya/events"
+	"go.uber.org/zap"
+)
+
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventIdHeader   = "X-Webhook-Event-Id"
+
+	defaultWorkers        = 4
+	defaultMaxAttempts    = 5
+	defaultInitialBackoff = time.Second
+	defaultTimeout        = 10 * time.Second
+	defaultDeadLetterSize = 1000
+)
+
+var ErrNotFound = errors.New("not found")
+
+// Subscription delivers the events matching its filters to Url,
+// empty filters match everything
+type Subscription struct {
+	Id         string    `json:"id"`
+	Url        string    `json:"url"`
+	Secret     string    `json:"secret,omitempty"`
+	EventTypes []string  `json:"event_types,omitempty"`
+	DeviceIds  []string  `json:"device_ids,omitempty"`
+	Categories []string  `json:"categories,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Delivery is an event POSTed to a subscription, failed deliveries are kept
+// in the dead letter list
+type Delivery struct {
+	Id             string          `json:"id"`
+	SubscriptionId string          `json:"subscription_id"`
+	Url            string          `json:"url"`
+	EventId        uint64          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Attempts       int             `json:"attempts"`
+	LastError      string          `json:"last_error,omitempty"`
+	LastAttemptAt  time.Time       `json:"last_attempt_at"`
+}
+
+type payload struct {
+	Id    uint64       `json:"id"`
+	Type  string       `json:"type"`
+	Event events.Event `json:"event"`
+}
+
+// Dispatcher delivers bus events to the webhook subscriptions as signed JSON
+// POSTs, retrying with exponential backoff before dead lettering them.
+// Subscriptions and dead letters are kept in memory.
+type Dispatcher struct {
+	logger     *logger.AppLogger
+	cfg        *config.Webhooks
+	client     *http.Client
+	categoryOf func(deviceId string) string
+	queue      chan *Delivery
+
+	mu            sync.RWMutex
+	subscriptions map[string]*Subscription
+	deadLetters   []*Delivery
+}
+
+func NewDispatcher(logger *logger.AppLogger, cfg *config.Config, categoryOf func(deviceId string) string) *Dispatcher {
+	timeout := cfg.Webhooks.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &Dispatcher{
+		logger:        logger,
+		cfg:           &cfg.Webhooks,
+		client:        &http.Client{Timeout: timeout},
+		categoryOf:    categoryOf,
+		queue:         make(chan *Delivery, 256),
+		subscriptions: map[string]*Subscription{},
+	}
+}
+
+func newId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// AddSubscription validates and registers a subscription, a signing secret is
+// generated when none is given
+func (d *Dispatcher) AddSubscription(sub Subscription) (*Subscription, error) {
+	u, err := url.Parse(sub.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("url must be an absolute http(s) url")
+	}
+	sub.Id = newId()
+	if sub.Secret == "" {
+		sub.Secret = newId()
+	}
+	sub.CreatedAt = time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.subscriptions[sub.Id] = &sub
+	return &sub, nil
+}
+
+func (d *Dispatcher) Subscription(id string) (*Subscription, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	sub, ok := d.subscriptions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return sub, nil
+}
+
+// Subscriptions lists the subscriptions without their secrets
+func (d *Dispatcher) Subscriptions() []Subscription {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	subs := make([]Subscription, 0, len(d.subscriptions))
+	for _, sub := range d.subscriptions {
+		s := *sub
+		s.Secret = ""
+		subs = append(subs, s)
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].CreatedAt.Before(subs[j].CreatedAt) })
+	return subs
+}
+
+func (d *Dispatcher) RemoveSubscription(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.subscriptions[id]; !ok {
+		return ErrNotFound
+	}
+	delete(d.subscriptions, id)
+	return nil
+}
+
+func (d *Dispatcher) DeadLetters() []Delivery {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	deliveries := make([]Delivery, 0, len(d.deadLetters))
+	for _, delivery := range d.deadLetters {
+		deliveries = append(deliveries, *delivery)
+	}
+	return deliveries
+}
+
+// Redeliver makes one more attempt of a dead lettered delivery, it is removed
+// from the dead letter list when the attempt succeeds
+func (d *Dispatcher) Redeliver(id string) (*Delivery, error) {
+	d.mu.RLock()
+	var delivery *Delivery
+	for _, dl := range d.deadLetters {
+		if dl.Id == id {
+			delivery = dl
+			break
+		}
+	}
+	d.mu.RUnlock()
+	if delivery == nil {
+		return nil, ErrNotFound
+	}
+	sub, err := d.Subscription(delivery.SubscriptionId)
+	if err != nil {
+		return delivery, fmt.Errorf("subscription %s was removed", delivery.SubscriptionId)
+	}
+
+	// attempt on a copy so the dead letter isn't mutated without the lock
+	d.mu.RLock()
+	attempted := *delivery
+	d.mu.RUnlock()
+	err = d.attempt(sub, &attempted)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, dl := range d.deadLetters {
+		if dl.Id != id {
+			continue
+		}
+		if err == nil {
+			d.deadLetters = append(d.deadLetters[:i], d.deadLetters[i+1:]...)
+		} else {
+			*dl = attempted
+		}
+		break
+	}
+	return &attempted, err
+}
+
+// Run consumes the bus and delivers events until ctx is done. When the
+// dispatcher falls behind, it resumes from the last event it handled.
+func (d *Dispatcher) Run(ctx context.Context, bus *events.Bus) {
+	workers := d.cfg.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	for i := 0; i < workers; i++ {
+		go d.worker(ctx)
+	}
+
+	var lastId uint64
+	for {
+		sub, backlog := bus.Subscribe(lastId, nil)
+		for _, record := range backlog {
+			d.dispatch(ctx, record)
+			lastId = record.Id
+		}
+	loop:
+		for {
+			select {
+			case <-ctx.Done():
+				sub.Close()
+				return
+			case record, ok := <-sub.C:
+				if !ok {
+					d.logger.Warn("webhook dispatcher fell behind the event bus, resuming")
+					break loop
+				}
+				d.dispatch(ctx, record)
+				lastId = record.Id
+			}
+		}
+	}
+}
+
+func (d *Dispatcher) matches(sub *Subscription, event events.Event) bool {
+	if len(sub.EventTypes) > 0 && !contains(sub.EventTypes, string(event.Type())) {
+		return false
+	}
+	if len(sub.DeviceIds) > 0 && !contains(sub.DeviceIds, event.Meta().DeviceId) {
+		return false
+	}
+	if len(sub.Categories) > 0 && !contains(sub.Categories, d.categoryOf(event.Meta().DeviceId)) {
+		return false
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context, record events.Record) {
+	d.mu.RLock()
+	subs := make([]*Subscription, 0, len(d.subscriptions))
+	for _, sub := range d.subscriptions {
+		subs = append(subs, sub)
+	}
+	d.mu.RUnlock()
+
+	var body []byte
+	for _, sub := range subs {
+		if !d.matches(sub, record.Event) {
+			continue
+		}
+		if body == nil {
+			var err error
+			body, err = json.Marshal(payload{Id: record.Id, Type: string(record.Event.Type()), Event: record.Event})
+			if err != nil {
+				d.logger.Errorw("json_encode_err", zap.String("error", err.Error()))
+				return
+			}
+		}
+		delivery := &Delivery{
+			Id:             newId(),
+			SubscriptionId: sub.Id,
+			Url:            sub.Url,
+			EventId:        record.Id,
+			EventType:      string(record.Event.Type()),
+			Payload:        body,
+		}
+		select {
+		case d.queue <- delivery:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// worker makes one attempt per delivery taken from the queue. A failed
+// delivery is queued again by a timer once its backoff elapsed, so a slow
+// endpoint never holds a worker.
+func (d *Dispatcher) worker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case delivery := <-d.queue:
+			d.deliver(ctx, delivery)
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery *Delivery) {
+	maxAttempts := d.cfg.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	sub, err := d.Subscription(delivery.SubscriptionId)
+	if err != nil {
+		// subscription removed while the delivery was pending
+		return
+	}
+	if err := d.attempt(sub, delivery); err == nil {
+		return
+	}
+	if delivery.Attempts >= maxAttempts {
+		d.deadLetter(delivery)
+		return
+	}
+	time.AfterFunc(d.backoff(delivery.Attempts), func() {
+		select {
+		case d.queue <- delivery:
+		case <-ctx.Done():
+		}
+	})
+}
+
+// backoff is the delay after a failed attempt, InitialBackoff doubled after
+// every previous failure
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	backoff := d.cfg.InitialBackoff
+	if backoff <= 0 {
+		backoff = defaultInitialBackoff
+	}
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+	}
+	return backoff
+}
+
+// attempt POSTs the payload once, signing timestamp + "." + body with the
+// subscription secret
+func (d *Dispatcher) attempt(sub *Subscription, delivery *Delivery) error {
+	delivery.Attempts++
+	delivery.LastAttemptAt = time.Now()
+
+	err := d.post(sub, delivery)
+	if err != nil {
+		delivery.LastError = err.Error()
+		d.logger.Warnw("webhook_delivery_err",
+			zap.String("subscription_id", sub.Id),
+			zap.Uint64("event_id", delivery.EventId),
+			zap.Int("attempt", delivery.Attempts),
+			zap.String("error", err.Error()))
+		return err
+	}
+	delivery.LastError = ""
+	return nil
+}
+
+func (d *Dispatcher) post(sub *Subscription, delivery *Delivery) error {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := tuya.HmacSha256(ts+"."+string(delivery.Payload), sub.Secret)
+
+	req, err := http.NewRequest("POST", sub.Url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, ts)
+	req.Header.Set(SignatureHeader, "sha256="+signature)
+	req.Header.Set(EventIdHeader, strconv.FormatUint(delivery.EventId, 10))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (d *Dispatcher) deadLetter(delivery *Delivery) {
+	size := d.cfg.DeadLetterSize
+	if size <= 0 {
+		size = defaultDeadLetterSize
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.deadLetters = append(d.deadLetters, delivery)
+	if len(d.deadLetters) > size {
+		d.deadLetters = d.deadLetters[len(d.deadLetters)-size:]
+	}
+	d.logger.Errorw("webhook_dead_lettered",
+		zap.String("subscription_id", delivery.SubscriptionId),
+		zap.Uint64("event_id", delivery.EventId),
+		zap.String("error", delivery.LastError))
+}
@@ -0,0 +1,168 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/pkg/logger"
+	"github.com/varjangn/tuya-middleware/pkg/tuya/events"
+)
+
+// startDispatcher starts the workers of a dispatcher, events are handed to it
+// with dispatch as Run does for the bus events
+func startDispatcher(t *testing.T, cfg config.Webhooks) (*Dispatcher, context.Context) {
+	t.Helper()
+	appCfg := &config.Config{
+		Logger:   config.Logger{Level: "fatal", Encoding: "console"},
+		Webhooks: cfg,
+	}
+	appLogger := logger.NewAppLogger(appCfg)
+	appLogger.InitLogger()
+
+	d := NewDispatcher(appLogger, appCfg, func(string) string { return "" })
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	for i := 0; i < cfg.Workers; i++ {
+		go d.worker(ctx)
+	}
+	return d, ctx
+}
+
+func onlineRecord(id uint64) events.Record {
+	return events.Record{Id: id, Event: &events.OnlineEvent{EventMeta: events.EventMeta{DeviceId: "device1"}}}
+}
+
+// endpoint counts the requests it receives and answers them with status
+func endpoint(t *testing.T, status int, hits *atomic.Int32) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRetryDoesNotHoldWorker(t *testing.T) {
+	// a single worker and a backoff longer than the test
+	d, ctx := startDispatcher(t, config.Webhooks{Workers: 1, MaxAttempts: 5, InitialBackoff: time.Minute})
+
+	var failing, healthy atomic.Int32
+	if _, err := d.AddSubscription(Subscription{Url: endpoint(t, http.StatusInternalServerError, &failing)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.AddSubscription(Subscription{Url: endpoint(t, http.StatusNoContent, &healthy)}); err != nil {
+		t.Fatal(err)
+	}
+
+	for id := uint64(1); id <= 3; id++ {
+		d.dispatch(ctx, onlineRecord(id))
+	}
+	waitFor(t, "the healthy deliveries", func() bool {
+		return healthy.Load() == 3 && failing.Load() == 3
+	})
+}
+
+func TestRetriesThenDeadLetters(t *testing.T) {
+	d, ctx := startDispatcher(t, config.Webhooks{Workers: 1, MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond})
+
+	var hits atomic.Int32
+	sub, err := d.AddSubscription(Subscription{Url: endpoint(t, http.StatusBadGateway, &hits)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.dispatch(ctx, onlineRecord(1))
+
+	waitFor(t, "the dead letter", func() bool { return len(d.DeadLetters()) == 1 })
+	if n := hits.Load(); n != 3 {
+		t.Fatalf("%d attempts, want 3", n)
+	}
+	dl := d.DeadLetters()[0]
+	if dl.SubscriptionId != sub.Id || dl.Attempts != 3 || dl.LastError == "" {
+		t.Fatalf("dead letter = %+v", dl)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{cfg: &config.Webhooks{InitialBackoff: time.Second}}
+	for attempts, want := range []time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second} {
+		if attempts == 0 {
+			continue
+		}
+		if got := d.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+func TestSignature(t *testing.T) {
+	type request struct {
+		header http.Header
+		body   []byte
+	}
+	requests := make(chan request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{r.Header, body}
+	}))
+	t.Cleanup(server.Close)
+
+	d, ctx := startDispatcher(t, config.Webhooks{Workers: 1, MaxAttempts: 1})
+	sub, err := d.AddSubscription(Subscription{Url: server.URL, Secret: "hook-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.dispatch(ctx, onlineRecord(7))
+
+	var req request
+	select {
+	case req = <-requests:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no delivery")
+	}
+
+	// recomputed as a receiver would, from the timestamp and the raw body
+	ts := req.header.Get(TimestampHeader)
+	if _, err := strconv.ParseInt(ts, 10, 64); err != nil {
+		t.Fatalf("%s = %q, want unix seconds", TimestampHeader, ts)
+	}
+	mac := hmac.New(sha256.New, []byte(sub.Secret))
+	mac.Write([]byte(ts + "." + string(req.body)))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := req.header.Get(SignatureHeader); got != want {
+		t.Fatalf("%s = %s, want %s", SignatureHeader, got, want)
+	}
+	if got := req.header.Get(EventIdHeader); got != "7" {
+		t.Fatalf("%s = %s, want 7", EventIdHeader, got)
+	}
+
+	var p struct {
+		Id    uint64          `json:"id"`
+		Type  string          `json:"type"`
+		Event json.RawMessage `json:"event"`
+	}
+	if err := json.Unmarshal(req.body, &p); err != nil || p.Id != 7 || p.Type != string(events.EventOnline) {
+		t.Fatalf("payload = %s, %v", req.body, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sync/atomic"
 	"syscall"
 
 	"github.com/varjangn/tuya-middleware/config"
@@ -39,7 +40,9 @@ var loggerLevelMap = map[string]zapcore.Level{
 
 type AppLogger struct {
 	cfg         *config.Config
-	sugarLogger *zap.SugaredLogger
+	level       zap.AtomicLevel
+	encoding    string
+	sugarLogger atomic.Pointer[zap.SugaredLogger]
 }
 
 func NewAppLogger(cfg *config.Config) *AppLogger {
@@ -47,7 +50,11 @@ func NewAppLogger(cfg *config.Config) *AppLogger {
 }
 
 func (l *AppLogger) getLoggerLevel(cfg *config.Config) zapcore.Level {
-	level, exist := loggerLevelMap[cfg.Logger.Level]
+	return levelOf(cfg.Logger.Level)
+}
+
+func levelOf(name string) zapcore.Level {
+	level, exist := loggerLevelMap[name]
 	if !exist {
 		return zapcore.DebugLevel
 	}
@@ -55,8 +62,18 @@ func (l *AppLogger) getLoggerLevel(cfg *config.Config) zapcore.Level {
 }
 
 func (l *AppLogger) InitLogger() {
-	logLevel := l.getLoggerLevel(l.cfg)
+	l.level = zap.NewAtomicLevelAt(l.getLoggerLevel(l.cfg))
+	l.encoding = l.cfg.Logger.Encoding
+	l.sugarLogger.Store(l.newSugarLogger(l.encoding))
+
+	err := l.sugar().Sync()
+	if err != nil && !errors.Is(err, syscall.ENOTTY) {
+		fmt.Println(err)
+	}
+
+}
 
+func (l *AppLogger) newSugarLogger(encoding string) *zap.SugaredLogger {
 	logWriter := zapcore.AddSync(os.Stderr)
 
 	var encoderCfg zapcore.EncoderConfig
@@ -73,107 +90,117 @@ func (l *AppLogger) InitLogger() {
 	encoderCfg.NameKey = "NAME"
 	encoderCfg.MessageKey = "MESSAGE"
 
-	if l.cfg.Logger.Encoding == "console" {
+	if encoding == "console" {
 		encoder = zapcore.NewConsoleEncoder(encoderCfg)
 	} else {
 		encoder = zapcore.NewJSONEncoder(encoderCfg)
 	}
 
 	encoderCfg.EncodeTime = zapcore.ISO8601TimeEncoder
-	en := zap.NewAtomicLevelAt(logLevel)
-	core := zapcore.NewCore(encoder, logWriter, en)
+	core := zapcore.NewCore(encoder, logWriter, l.level)
 	logger := zap.New(core, zap.AddCaller(), zap.AddCallerSkip(1))
 
-	l.sugarLogger = logger.Sugar()
-	err := l.sugarLogger.Sync()
-	if err != nil && !errors.Is(err, syscall.ENOTTY) {
-		fmt.Println(err)
-	}
+	return logger.Sugar()
+}
 
+func (l *AppLogger) sugar() *zap.SugaredLogger {
+	return l.sugarLogger.Load()
+}
+
+// Reload applies the Level and Encoding of a reloaded config, the level
+// through the atomic level shared by the logger and the encoding by swapping
+// the logger
+func (l *AppLogger) Reload(cfg config.Logger) {
+	l.level.SetLevel(levelOf(cfg.Level))
+
+	if cfg.Encoding != l.encoding {
+		l.encoding = cfg.Encoding
+		l.sugarLogger.Store(l.newSugarLogger(l.encoding))
+	}
 }
 
 // Logger methods
 
 func (l *AppLogger) Debug(args ...interface{}) {
-	l.sugarLogger.Debug(args...)
+	l.sugar().Debug(args...)
 }
 
 func (l *AppLogger) Debugf(template string, args ...interface{}) {
-	l.sugarLogger.Debugf(template, args...)
+	l.sugar().Debugf(template, args...)
 }
 
 func (l *AppLogger) Debugw(msg string, args ...interface{}) {
-	l.sugarLogger.Debugw(msg, args...)
+	l.sugar().Debugw(msg, args...)
 }
 
 func (l *AppLogger) Info(args ...interface{}) {
-	l.sugarLogger.Info(args...)
+	l.sugar().Info(args...)
 }
 
 func (l *AppLogger) Infof(template string, args ...interface{}) {
-	l.sugarLogger.Infof(template, args...)
+	l.sugar().Infof(template, args...)
 }
 
 func (l *AppLogger) Infow(msg string, args ...interface{}) {
-	l.sugarLogger.Infow(msg, args...)
+	l.sugar().Infow(msg, args...)
 }
 
 func (l *AppLogger) Warn(args ...interface{}) {
-	l.sugarLogger.Warn(args...)
+	l.sugar().Warn(args...)
 }
 
 func (l *AppLogger) Warnf(template string, args ...interface{}) {
-	l.sugarLogger.Warnf(template, args...)
+	l.sugar().Warnf(template, args...)
 }
 
 func (l *AppLogger) Warnw(msg string, args ...interface{}) {
-	l.sugarLogger.Warnw(msg, args...)
+	l.sugar().Warnw(msg, args...)
 }
 
 func (l *AppLogger) Error(args ...interface{}) {
-	l.sugarLogger.Error(args...)
+	l.sugar().Error(args...)
 }
 
 func (l *AppLogger) Errorf(template string, args ...interface{}) {
-	l.sugarLogger.Errorf(template, args...)
+	l.sugar().Errorf(template, args...)
 }
 
 func (l *AppLogger) Errorw(msg string, args ...interface{}) {
-	l.sugarLogger.Errorw(msg, args...)
+	l.sugar().Errorw(msg, args...)
 }
 
 func (l *AppLogger) DPanic(args ...interface{}) {
-	l.sugarLogger.DPanic(args...)
+	l.sugar().DPanic(args...)
 }
 
 func (l *AppLogger) DPanicf(template string, args ...interface{}) {
-	l.sugarLogger.DPanicf(template, args...)
+	l.sugar().DPanicf(template, args...)
 }
 
 func (l *AppLogger) DPanicw(msg string, args ...interface{}) {
-	l.sugarLogger.DPanicw(msg, args...)
+	l.sugar().DPanicw(msg, args...)
 }
 
 func (l *AppLogger) Panic(args ...interface{}) {
-	l.sugarLogger.Panic(args...)
+	l.sugar().Panic(args...)
 }
 
 func (l *AppLogger) Panicf(template string, args ...interface{}) {
-	l.sugarLogger.Panicf(template, args...)
+	l.sugar().Panicf(template, args...)
 }
 
 func (l *AppLogger) Panicw(msg string, args ...interface{}) {
-	l.sugarLogger.Panicw(msg, args...)
+	l.sugar().Panicw(msg, args...)
 }
 
 func (l *AppLogger) Fatal(args ...interface{}) {
-	l.sugarLogger.Fatal(args...)
+	l.sugar().Fatal(args...)
 }
 
 func (l *AppLogger) Fatalf(template string, args ...interface{}) {
-	l.sugarLogger.Fatalf(template, args...)
+	l.sugar().Fatalf(template, args...)
 }
 
 func (l *AppLogger) Fatalw(msg string, args ...interface{}) {
-	l.sugarLogger.Fatalw(msg, args...)
+	l.sugar().Fatalw(msg, args...)
 }
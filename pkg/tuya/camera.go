@@ -0,0 +1,93 @@
+package tuya
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// stream protocols supported by AllocateStream
+const (
+	StreamRTSP = "RTSP"
+	StreamHLS  = "HLS"
+	StreamFLV  = "FLV"
+	StreamRTMP = "RTMP"
+)
+
+// IsStreamType tells whether streamType, in any case, is a protocol
+// AllocateStream supports
+func IsStreamType(streamType string) bool {
+	switch strings.ToUpper(streamType) {
+	case StreamRTSP, StreamHLS, StreamFLV, StreamRTMP:
+		return true
+	}
+	return false
+}
+
+/*
+Allocate a live stream URL of an IPC camera, streamType is one of RTSP, HLS, FLV or RTMP.
+The URL is short-lived, it must be allocated again once ExpireTime elapsed.
+*/
+func (c *TuyaClient) AllocateStream(deviceId, streamType string) (*Stream, error) {
+	baseURL := c.GetBaseUrl(1.0)
+	endpointURL := fmt.Sprintf("%s/devices/%s/stream/actions/allocate", baseURL, deviceId)
+
+	if !IsStreamType(streamType) {
+		return nil, fmt.Errorf("unsupported stream type %q", streamType)
+	}
+	payload := map[string]string{
+		"type": strings.ToUpper(streamType),
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		c.logger.Errorw("json_encode_err", zap.String("error", err.Error()))
+		return nil, err
+	}
+	response, err := c.DoRequest(endpointURL, "POST", body)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody := new(StreamResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return nil, fmt.Errorf("success false for response")
+	}
+	return &respBody.Result, nil
+}
+
+/*
+Capture a snapshot of an IPC camera, the image is served by the short-lived
+Url, which needs no signature.
+*/
+func (c *TuyaClient) CaptureSnapshot(deviceId string) (*Snapshot, error) {
+	var body []byte
+	baseURL := c.GetBaseUrl(1.0)
+	endpointURL := fmt.Sprintf("%s/devices/%s/camera/actions/capture", baseURL, deviceId)
+	response, err := c.DoRequest(endpointURL, "POST", body)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody := new(SnapshotResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return nil, fmt.Errorf("success false for response: %s", respBody.Msg)
+	}
+	if respBody.Result.Url == "" {
+		return nil, fmt.Errorf("no snapshot url returned")
+	}
+	return &respBody.Result, nil
+}
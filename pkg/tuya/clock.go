@@ -0,0 +1,72 @@
+package tuya
+
+import (
+	"encoding/json"
+	"expvar"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultClockSkewWarning is the skew warned about when Tuya.ClockSkewWarning
+// isn't set
+const DefaultClockSkewWarning = 30 * time.Second
+
+var (
+	// how far the Tuya clock is ahead of the local one in milliseconds,
+	// measured from the t of the responses and added to the t header
+	clockSkew atomic.Int64
+	// set while the skew is over the warning threshold
+	clockSkewWarned atomic.Bool
+
+	// published as tuya_clock_skew_ms by expvar
+	clockSkewMetric = expvar.NewInt("tuya_clock_skew_ms")
+)
+
+// ClockSkew returns how far the Tuya clock is ahead of the local one
+func ClockSkew() time.Duration {
+	return time.Duration(clockSkew.Load()) * time.Millisecond
+}
+
+// tuyaNow is the current time in milliseconds on the Tuya clock
+func tuyaNow() int64 {
+	return time.Now().UnixMilli() + clockSkew.Load()
+}
+
+// observeServerTime measures the skew from the t of a response, compared to
+// the local time halfway between sending the request and reading the response
+func (c *TuyaClient) observeServerTime(t int64, sent, received time.Time) {
+	if t <= 0 {
+		return
+	}
+	local := sent.Add(received.Sub(sent) / 2).UnixMilli()
+	skew := t - local
+	clockSkew.Store(skew)
+	clockSkewMetric.Set(skew)
+
+	threshold := c.credentials().ClockSkewWarning
+	if threshold <= 0 {
+		threshold = DefaultClockSkewWarning
+	}
+	offset, direction := time.Duration(skew)*time.Millisecond, "behind"
+	if offset < 0 {
+		offset, direction = -offset, "ahead of"
+	}
+	if offset > threshold {
+		if !clockSkewWarned.Swap(true) {
+			c.logger.Warnf("local clock is %s %s the Tuya clock, over %s, check NTP; requests are signed with the Tuya time",
+				offset, direction, threshold)
+		}
+	} else if clockSkewWarned.Swap(false) {
+		c.logger.Infof("local clock is back within %s of the Tuya clock", threshold)
+	}
+}
+
+// observeResponse measures the skew from the t of a response body
+func (c *TuyaClient) observeResponse(body []byte, sent, received time.Time) {
+	var resp struct {
+		T int64 `json:"t"`
+	}
+	if err := json.Unmarshal(body, &resp); err == nil {
+		c.observeServerTime(resp.T, sent, received)
+	}
+}
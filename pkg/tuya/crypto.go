@@ -0,0 +1,63 @@
+package tuya
+
+import (
+	"bytes"
+	"crypto/aes"
+	"fmt"
+)
+
+// AesEcbEncrypt encrypts data with AES in ECB mode using PKCS7 padding
+func AesEcbEncrypt(data, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	blockSize := block.BlockSize()
+	data = pkcs7Pad(data, blockSize)
+	encrypted := make([]byte, len(data))
+	for start := 0; start < len(data); start += blockSize {
+		block.Encrypt(encrypted[start:start+blockSize], data[start:start+blockSize])
+	}
+	return encrypted, nil
+}
+
+// AesEcbDecrypt decrypts AES ECB data and removes the PKCS7 padding
+func AesEcbDecrypt(data, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	blockSize := block.BlockSize()
+	if len(data) == 0 || len(data)%blockSize != 0 {
+		return nil, fmt.Errorf("ciphertext is not a multiple of the block size")
+	}
+	decrypted := make([]byte, len(data))
+	for start := 0; start < len(data); start += blockSize {
+		block.Decrypt(decrypted[start:start+blockSize], data[start:start+blockSize])
+	}
+	return pkcs7Unpad(decrypted, blockSize)
+}
+
+func pkcs7Pad(data []byte, blockSize int) []byte {
+	padding := blockSize - len(data)%blockSize
+	padded := make([]byte, len(data), len(data)+padding)
+	copy(padded, data)
+	return append(padded, bytes.Repeat([]byte{byte(padding)}, padding)...)
+}
+
+func pkcs7Unpad(data []byte, blockSize int) ([]byte, error) {
+	length := len(data)
+	if length == 0 {
+		return nil, fmt.Errorf("invalid padding")
+	}
+	padding := int(data[length-1])
+	if padding == 0 || padding > blockSize || padding > length {
+		return nil, fmt.Errorf("invalid padding")
+	}
+	for _, b := range data[length-padding:] {
+		if int(b) != padding {
+			return nil, fmt.Errorf("invalid padding")
+		}
+	}
+	return data[:length-padding], nil
+}
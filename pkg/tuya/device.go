@@ -68,6 +68,112 @@ func (c *TuyaClient) GetUserDevices(userId string, queryParams map[string]string
 	return respBody.Result, nil
 }
 
+/*
+Query the list of devices in a home
+*/
+func (c *TuyaClient) GetHomeDevices(homeId string) ([]Device, error) {
+	var body []byte
+	baseURL := c.GetBaseUrl(1.0)
+	endpointURL := fmt.Sprintf("%s/homes/%s/devices", baseURL, homeId)
+	response, err := c.DoRequest(endpointURL, "GET", body)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody := new(UserDeviceResponse)
+
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return nil, fmt.Errorf("success false for response")
+	}
+
+	return respBody.Result, nil
+}
+
+/*
+Query the specification (functions and status data points with their value ranges) of a device
+*/
+func (c *TuyaClient) GetDeviceSpecification(deviceId string) (*Specification, error) {
+	var body []byte
+	baseURL := c.GetBaseUrl(1.0)
+	endpointURL := fmt.Sprintf("%s/devices/%s/specifications", baseURL, deviceId)
+	response, err := c.DoRequest(endpointURL, "GET", body)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody := new(SpecificationResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return nil, fmt.Errorf("success false for response")
+	}
+
+	return &respBody.Result, nil
+}
+
+/*
+Query the latest status of a device
+*/
+func (c *TuyaClient) GetDeviceStatus(deviceId string) ([]DeviceStatus, error) {
+	var body []byte
+	baseURL := c.GetBaseUrl(1.0)
+	endpointURL := fmt.Sprintf("%s/devices/%s/status", baseURL, deviceId)
+	response, err := c.DoRequest(endpointURL, "GET", body)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody := new(DeviceStatusResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return nil, fmt.Errorf("success false for response")
+	}
+
+	return respBody.Result, nil
+}
+
+/*
+Query the shadow properties of a device, they map the status codes to the
+data point ids used on the LAN
+*/
+func (c *TuyaClient) GetDeviceProperties(deviceId string) ([]DeviceProperty, error) {
+	var body []byte
+	baseURL := c.GetBaseUrl(2.0)
+	endpointURL := fmt.Sprintf("%s/cloud/thing/%s/shadow/properties", baseURL, deviceId)
+	response, err := c.DoRequest(endpointURL, "GET", body)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody := new(DevicePropertiesResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return nil, fmt.Errorf("success false for response")
+	}
+
+	return respBody.Result.Properties, nil
+}
+
 /*
 Query a list of devices parameters
 https://developer.tuya.com/en/docs/cloud/device-management?id=K9g6rfntdz78a#title-19-Get%20a%20list%20of%20devices
@@ -229,7 +335,7 @@ func (c *TuyaClient) GetFactoryInfo(deviceIds string) ([]FactoryInfo, error) {
 	var body []byte
 	baseURL := c.GetBaseUrl(1.0)
 	endpointURL := fmt.Sprintf("%s/devices/factory-infos?device_ids=%s", baseURL, deviceIds)
-	response, err := c.DoRequest(endpointURL, "DELETE", body)
+	response, err := c.DoRequest(endpointURL, "GET", body)
 	if err != nil {
 		return []FactoryInfo{}, err
 	}
@@ -295,7 +401,7 @@ func (c *TuyaClient) AddUser(deviceId string, userInfo map[string]interface{}) (
 	}
 	_, ok = userInfo["sex"]
 	if !ok {
-		return "", fmt.Errorf("nick_name can not be empty")
+		return "", fmt.Errorf("sex can not be empty")
 	}
 
 	body, err := json.Marshal(userInfo)
@@ -335,7 +441,7 @@ func (c *TuyaClient) ModifyUser(deviceId, userId string, userInfo map[string]int
 	}
 	_, ok = userInfo["sex"]
 	if !ok {
-		return "", fmt.Errorf("nick_name can not be empty")
+		return "", fmt.Errorf("sex can not be empty")
 	}
 
 	body, err := json.Marshal(userInfo)
@@ -501,3 +607,39 @@ func (c *TuyaClient) GetMODeviceNames(deviceId, identifier, name string) ([]MODe
 	}
 	return respBody.Result, nil
 }
+
+/*
+Send commands to a device
+*/
+func (c *TuyaClient) SendCommands(deviceId string, commands []Command) (bool, error) {
+	baseURL := c.GetBaseUrl(1.0)
+	endpointURL := fmt.Sprintf("%s/devices/%s/commands", baseURL, deviceId)
+
+	if len(commands) == 0 {
+		return false, fmt.Errorf("commands can not be empty")
+	}
+	payload := map[string][]Command{
+		"commands": commands,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		c.logger.Errorw("json_encode_err", zap.String("error", err.Error()))
+		return false, err
+	}
+	response, err := c.DoRequest(endpointURL, "POST", body)
+	if err != nil {
+		return false, err
+	}
+
+	respBody := new(BooleanResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return false, fmt.Errorf("success false for response")
+	}
+	return respBody.Result, nil
+}
@@ -0,0 +1,122 @@
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	defaultBufferSize       = 1024
+	subscriptionChannelSize = 64
+)
+
+// Record is an event published on the bus with its sequence id
+type Record struct {
+	Id    uint64
+	Event Event
+}
+
+// Bus fans out events from every event source (message service, poller, ...)
+// to its subscriptions and keeps the latest events in a ring buffer so
+// subscribers can resume after a reconnect
+type Bus struct {
+	mu     sync.Mutex
+	lastId uint64
+	ring   []Record
+	next   int
+	subs   map[*Subscription]struct{}
+}
+
+// Subscription receives the events matching its filter. A subscription that
+// can't keep up is closed, the subscriber can resume from its last id.
+type Subscription struct {
+	C      <-chan Record
+	ch     chan Record
+	filter func(Event) bool
+	bus    *Bus
+	closed bool
+}
+
+func NewBus(bufferSize int) *Bus {
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	return &Bus{
+		ring: make([]Record, 0, bufferSize),
+		subs: map[*Subscription]struct{}{},
+	}
+}
+
+// Consume publishes the event, so the bus can be registered as a Consumer
+func (b *Bus) Consume(ctx context.Context, event Event) error {
+	b.Publish(event)
+	return nil
+}
+
+func (b *Bus) Publish(event Event) uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastId++
+	record := Record{Id: b.lastId, Event: event}
+	if len(b.ring) < cap(b.ring) {
+		b.ring = append(b.ring, record)
+	} else {
+		b.ring[b.next] = record
+		b.next = (b.next + 1) % len(b.ring)
+	}
+
+	for sub := range b.subs {
+		if sub.filter != nil && !sub.filter(event) {
+			continue
+		}
+		select {
+		case sub.ch <- record:
+		default:
+			b.closeLocked(sub)
+		}
+	}
+	return record.Id
+}
+
+// Subscribe registers a subscription, filter may be nil. Buffered events
+// with an id greater than afterId and matching the filter are returned as
+// backlog, afterId 0 skips the backlog.
+func (b *Bus) Subscribe(afterId uint64, filter func(Event) bool) (*Subscription, []Record) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	backlog := []Record{}
+	if afterId > 0 {
+		for i := range b.ring {
+			record := b.ring[(b.next+i)%len(b.ring)]
+			if record.Id <= afterId {
+				continue
+			}
+			if filter != nil && !filter(record.Event) {
+				continue
+			}
+			backlog = append(backlog, record)
+		}
+	}
+
+	ch := make(chan Record, subscriptionChannelSize)
+	sub := &Subscription{C: ch, ch: ch, filter: filter, bus: b}
+	b.subs[sub] = struct{}{}
+	return sub, backlog
+}
+
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.closeLocked(s)
+}
+
+func (b *Bus) closeLocked(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	delete(b.subs, sub)
+	close(sub.ch)
+}
@@ -0,0 +1,97 @@
+package events
+
+import (
+	"fmt"
+	"testing"
+)
+
+func busEvent(deviceId string) Event {
+	return &OnlineEvent{EventMeta: EventMeta{DeviceId: deviceId}}
+}
+
+func recordIds(records []Record) string {
+	ids := []uint64{}
+	for _, record := range records {
+		ids = append(ids, record.Id)
+	}
+	return fmt.Sprint(ids)
+}
+
+func TestBusRingBuffer(t *testing.T) {
+	bus := NewBus(3)
+	for i := 0; i < 5; i++ {
+		bus.Publish(busEvent(fmt.Sprintf("d%d", i%2)))
+	}
+
+	for _, tt := range []struct {
+		name    string
+		afterId uint64
+		filter  func(Event) bool
+		want    string
+	}{
+		{"no backlog", 0, nil, "[]"},
+		{"older than the buffer", 1, nil, "[3 4 5]"},
+		{"within the buffer", 3, nil, "[4 5]"},
+		{"up to date", 5, nil, "[]"},
+		{"filtered", 1, func(e Event) bool { return e.Meta().DeviceId == "d0" }, "[3 5]"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, backlog := bus.Subscribe(tt.afterId, tt.filter)
+			defer sub.Close()
+			if got := recordIds(backlog); got != tt.want {
+				t.Fatalf("backlog = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBusDelivers(t *testing.T) {
+	bus := NewBus(0)
+	all, _ := bus.Subscribe(0, nil)
+	d1, _ := bus.Subscribe(0, func(e Event) bool { return e.Meta().DeviceId == "d1" })
+	defer all.Close()
+	defer d1.Close()
+
+	bus.Publish(busEvent("d0"))
+	id := bus.Publish(busEvent("d1"))
+
+	if record := <-all.C; record.Id != id-1 {
+		t.Fatalf("first record = %d, want %d", record.Id, id-1)
+	}
+	if record := <-all.C; record.Id != id {
+		t.Fatalf("second record = %d, want %d", record.Id, id)
+	}
+	if record := <-d1.C; record.Id != id || record.Event.Meta().DeviceId != "d1" {
+		t.Fatalf("filtered record = %+v", record)
+	}
+	if len(d1.C) != 0 {
+		t.Fatal("filtered subscription received another event")
+	}
+}
+
+func TestBusClosesSlowSubscriptions(t *testing.T) {
+	bus := NewBus(0)
+	slow, _ := bus.Subscribe(0, nil)
+
+	var lastId uint64
+	for i := 0; i <= subscriptionChannelSize; i++ {
+		lastId = bus.Publish(busEvent("d0"))
+	}
+
+	received := 0
+	for range slow.C {
+		received++
+	}
+	if received != subscriptionChannelSize {
+		t.Fatalf("received %d events before the close, want %d", received, subscriptionChannelSize)
+	}
+	// closing again is a no-op
+	slow.Close()
+
+	// the subscriber resumes from its last id
+	resumed, backlog := bus.Subscribe(uint64(received), nil)
+	defer resumed.Close()
+	if len(backlog) != 1 || backlog[0].Id != lastId {
+		t.Fatalf("backlog after resuming = %s, want [%d]", recordIds(backlog), lastId)
+	}
+}
@@ -0,0 +1,157 @@
+package events
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+)
+
+// message protocols of the Tuya message service
+const (
+	protocolStatusReport = 4
+	protocolDeviceEvent  = 20
+)
+
+// ErrUnknownEvent is returned for messages without a typed event,
+// they are acknowledged and skipped by the subscriber
+var ErrUnknownEvent = errors.New("unknown event")
+
+// envelope of the base64 decoded pulsar payload
+type messagePayload struct {
+	Protocol int    `json:"protocol"`
+	Pv       string `json:"pv"`
+	Sign     string `json:"sign"`
+	T        int64  `json:"t"`
+	Data     string `json:"data"`
+}
+
+// decrypted data of a message
+type messageData struct {
+	DevId      string          `json:"devId"`
+	ProductKey string          `json:"productKey"`
+	DataId     string          `json:"dataId"`
+	Status     []StatusValue   `json:"status"`
+	BizCode    string          `json:"bizCode"`
+	BizData    json.RawMessage `json:"bizData"`
+	Ts         int64           `json:"ts"`
+}
+
+type bizData struct {
+	Name string `json:"name"`
+	Uid  string `json:"uid"`
+}
+
+// DecryptData decrypts the data field of a message with the project secret.
+// The key is the middle 16 bytes of the secret, encryptModel "aes_gcm" selects
+// AES-GCM with a 12 byte nonce prefix, anything else is AES-ECB.
+func DecryptData(data, secret, encryptModel string) ([]byte, error) {
+	if len(secret) < 24 {
+		return nil, fmt.Errorf("secret is too short")
+	}
+	key := []byte(secret[8:24])
+
+	encrypted, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("decode data: %w", err)
+	}
+
+	if encryptModel != "aes_gcm" {
+		return tuya.AesEcbDecrypt(encrypted, key)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(encrypted) < gcm.NonceSize() {
+		return nil, fmt.Errorf("data is too short")
+	}
+	nonce, ciphertext := encrypted[:gcm.NonceSize()], encrypted[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+// DecodeEvent decodes a pulsar payload into a typed event. The data is
+// decrypted with the first of the secrets that works, so messages encrypted
+// before or after a secret rotation are both read.
+func DecodeEvent(messageId string, payload []byte, properties map[string]string, secrets ...string) (Event, error) {
+	envelope := new(messagePayload)
+	if err := json.Unmarshal(payload, envelope); err != nil {
+		return nil, fmt.Errorf("decode payload: %w", err)
+	}
+
+	data, err := decryptMessageData(envelope.Data, properties["em"], secrets)
+	if err != nil {
+		return nil, err
+	}
+	ts := data.Ts
+	if ts == 0 {
+		ts = envelope.T
+	}
+	meta := EventMeta{
+		MessageId: messageId,
+		DeviceId:  data.DevId,
+		ProductId: data.ProductKey,
+		Time:      time.UnixMilli(ts),
+	}
+
+	switch envelope.Protocol {
+	case protocolStatusReport:
+		return &StatusReportEvent{EventMeta: meta, Status: data.Status}, nil
+	case protocolDeviceEvent:
+		biz := bizData{}
+		if len(data.BizData) > 0 {
+			if err := json.Unmarshal(data.BizData, &biz); err != nil {
+				return nil, fmt.Errorf("decode biz data: %w", err)
+			}
+		}
+		switch data.BizCode {
+		case "online":
+			return &OnlineEvent{EventMeta: meta}, nil
+		case "offline":
+			return &OfflineEvent{EventMeta: meta}, nil
+		case "nameUpdate":
+			return &NameChangeEvent{EventMeta: meta, Name: biz.Name}, nil
+		case "bindUser":
+			return &BindEvent{EventMeta: meta, UserId: biz.Uid}, nil
+		case "unbindUser":
+			return &UnbindEvent{EventMeta: meta, UserId: biz.Uid}, nil
+		case "delete":
+			return &DeleteEvent{EventMeta: meta}, nil
+		}
+		return nil, fmt.Errorf("%w: bizCode %q", ErrUnknownEvent, data.BizCode)
+	}
+	return nil, fmt.Errorf("%w: protocol %d", ErrUnknownEvent, envelope.Protocol)
+}
+
+// decryptMessageData decrypts and decodes the data of a message with each
+// secret in turn, a wrong secret fails the padding, the GCM tag or the JSON
+func decryptMessageData(encrypted, encryptModel string, secrets []string) (*messageData, error) {
+	errs := []error{}
+	for _, secret := range secrets {
+		decrypted, err := DecryptData(encrypted, secret, encryptModel)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("decrypt payload: %w", err))
+			continue
+		}
+		data := new(messageData)
+		if err := json.Unmarshal(decrypted, data); err != nil {
+			errs = append(errs, fmt.Errorf("decode data: %w", err))
+			continue
+		}
+		return data, nil
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("decrypt payload: no secret")
+	}
+	return nil, errors.Join(errs...)
+}
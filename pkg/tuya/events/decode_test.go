@@ -0,0 +1,102 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func devicePayload(t *testing.T, secret, encryptModel string, protocol int, data interface{}) []byte {
+	t.Helper()
+	payload, err := json.Marshal(messagePayload{
+		Protocol: protocol,
+		Pv:       "2.0",
+		T:        1700000000000,
+		Data:     encryptData(t, secret, encryptModel, data),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func TestDecodeEvent(t *testing.T) {
+	meta := EventMeta{MessageId: "m1", DeviceId: "device1", ProductId: "product1", Time: time.UnixMilli(1700000001000)}
+	deviceEvent := func(bizCode string, biz interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"devId":      "device1",
+			"productKey": "product1",
+			"bizCode":    bizCode,
+			"bizData":    biz,
+			"ts":         1700000001000,
+		}
+	}
+
+	for _, encryptModel := range []string{"aes_ecb", "aes_gcm"} {
+		for _, tt := range []struct {
+			name     string
+			protocol int
+			data     interface{}
+			want     Event
+		}{
+			{"status report", protocolStatusReport, map[string]interface{}{
+				"devId":      "device1",
+				"productKey": "product1",
+				"status":     []StatusValue{{Code: "switch_1", Value: true, T: 1}},
+				"ts":         1700000001000,
+			}, &StatusReportEvent{EventMeta: meta, Status: []StatusValue{{Code: "switch_1", Value: true, T: 1}}}},
+			{"online", protocolDeviceEvent, deviceEvent("online", map[string]interface{}{}), &OnlineEvent{EventMeta: meta}},
+			{"offline", protocolDeviceEvent, deviceEvent("offline", nil), &OfflineEvent{EventMeta: meta}},
+			{"name update", protocolDeviceEvent, deviceEvent("nameUpdate", map[string]string{"name": "Desk lamp"}), &NameChangeEvent{EventMeta: meta, Name: "Desk lamp"}},
+			{"bind", protocolDeviceEvent, deviceEvent("bindUser", map[string]string{"uid": "user1"}), &BindEvent{EventMeta: meta, UserId: "user1"}},
+			{"unbind", protocolDeviceEvent, deviceEvent("unbindUser", map[string]string{"uid": "user1"}), &UnbindEvent{EventMeta: meta, UserId: "user1"}},
+			{"delete", protocolDeviceEvent, deviceEvent("delete", nil), &DeleteEvent{EventMeta: meta}},
+		} {
+			t.Run(encryptModel+" "+tt.name, func(t *testing.T) {
+				payload := devicePayload(t, testSecret, encryptModel, tt.protocol, tt.data)
+				event, err := DecodeEvent("m1", payload, map[string]string{"em": encryptModel}, testSecret)
+				if err != nil {
+					t.Fatalf("DecodeEvent: %v", err)
+				}
+				if !reflect.DeepEqual(event, tt.want) {
+					t.Fatalf("DecodeEvent = %#v, want %#v", event, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestDecodeEventErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		payload []byte
+		em      string
+		unknown bool
+	}{
+		{"unknown biz code", devicePayload(t, testSecret, "aes_ecb", protocolDeviceEvent, map[string]string{"devId": "device1", "bizCode": "upgradeStatus"}), "aes_ecb", true},
+		{"unknown protocol", devicePayload(t, testSecret, "aes_ecb", 1000, map[string]string{"devId": "device1"}), "aes_ecb", true},
+		// the GCM tag covers the data, reading it as ECB or with the other
+		// secret fails
+		{"gcm read as ecb", devicePayload(t, testSecret, "aes_gcm", protocolDeviceEvent, map[string]string{"devId": "device1", "bizCode": "online"}), "aes_ecb", false},
+		{"gcm other secret", devicePayload(t, testSecondarySecret, "aes_gcm", protocolDeviceEvent, map[string]string{"devId": "device1", "bizCode": "online"}), "aes_gcm", false},
+		{"invalid envelope", []byte("{"), "aes_ecb", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeEvent("m1", tt.payload, map[string]string{"em": tt.em}, testSecret)
+			if err == nil {
+				t.Fatal("DecodeEvent succeeded")
+			}
+			if unknown := errors.Is(err, ErrUnknownEvent); unknown != tt.unknown {
+				t.Fatalf("DecodeEvent = %v, unknown event %v, want %v", err, unknown, tt.unknown)
+			}
+		})
+	}
+
+	// while rotating, the other secret decrypts GCM payloads too
+	payload := devicePayload(t, testSecondarySecret, "aes_gcm", protocolDeviceEvent, map[string]string{"devId": "device1", "bizCode": "online"})
+	if _, err := DecodeEvent("m1", payload, map[string]string{"em": "aes_gcm"}, testSecret, testSecondarySecret); err != nil {
+		t.Fatalf("DecodeEvent with the secondary secret: %v", err)
+	}
+}
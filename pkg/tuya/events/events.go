@@ -0,0 +1,135 @@
+package events
+
+import (
+	"time"
+)
+
+type EventType string
+
+const (
+	EventStatusReport EventType = "status_report"
+	EventOnline       EventType = "online"
+	EventOffline      EventType = "offline"
+	EventNameChange   EventType = "name_change"
+	EventBind         EventType = "bind"
+	EventUnbind       EventType = "unbind"
+	EventDelete       EventType = "delete"
+)
+
+// Event is a decoded message from the Tuya message service
+type Event interface {
+	Type() EventType
+	Meta() EventMeta
+}
+
+// fields shared by every event
+type EventMeta struct {
+	MessageId string    `json:"message_id"`
+	DeviceId  string    `json:"device_id"`
+	ProductId string    `json:"product_id"`
+	Time      time.Time `json:"time"`
+}
+
+func (m EventMeta) Meta() EventMeta {
+	return m
+}
+
+type StatusValue struct {
+	Code  string      `json:"code"`
+	Value interface{} `json:"value"`
+	T     int64       `json:"t"`
+}
+
+// device reported new DP values
+type StatusReportEvent struct {
+	EventMeta
+	Status []StatusValue `json:"status"`
+}
+
+func (e *StatusReportEvent) Type() EventType { return EventStatusReport }
+
+type OnlineEvent struct {
+	EventMeta
+}
+
+func (e *OnlineEvent) Type() EventType { return EventOnline }
+
+type OfflineEvent struct {
+	EventMeta
+}
+
+func (e *OfflineEvent) Type() EventType { return EventOffline }
+
+type NameChangeEvent struct {
+	EventMeta
+	Name string `json:"name"`
+}
+
+func (e *NameChangeEvent) Type() EventType { return EventNameChange }
+
+// device bound to a user
+type BindEvent struct {
+	EventMeta
+	UserId string `json:"user_id"`
+}
+
+func (e *BindEvent) Type() EventType { return EventBind }
+
+// device unbound from a user
+type UnbindEvent struct {
+	EventMeta
+	UserId string `json:"user_id"`
+}
+
+func (e *UnbindEvent) Type() EventType { return EventUnbind }
+
+// device removed from the project
+type DeleteEvent struct {
+	EventMeta
+}
+
+func (e *DeleteEvent) Type() EventType { return EventDelete }
+
+// events emitted by the poller from the diff of two device snapshots
+const (
+	EventDpChanged   EventType = "dp_changed"
+	EventWentOnline  EventType = "went_online"
+	EventWentOffline EventType = "went_offline"
+	EventRenamed     EventType = "renamed"
+	EventRemoved     EventType = "removed"
+)
+
+type DpChangedEvent struct {
+	EventMeta
+	Code     string      `json:"code"`
+	OldValue interface{} `json:"old_value"`
+	NewValue interface{} `json:"new_value"`
+}
+
+func (e *DpChangedEvent) Type() EventType { return EventDpChanged }
+
+type WentOnlineEvent struct {
+	EventMeta
+}
+
+func (e *WentOnlineEvent) Type() EventType { return EventWentOnline }
+
+type WentOfflineEvent struct {
+	EventMeta
+}
+
+func (e *WentOfflineEvent) Type() EventType { return EventWentOffline }
+
+type RenamedEvent struct {
+	EventMeta
+	OldName string `json:"old_name"`
+	Name    string `json:"name"`
+}
+
+func (e *RenamedEvent) Type() EventType { return EventRenamed }
+
+type RemovedEvent struct {
+	EventMeta
+}
+
+func (e *RemovedEvent) Type() EventType { return EventRemoved }
@@ -0,0 +1,200 @@
+package events
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/pkg/logger"
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultPollInterval = time.Minute
+	defaultPollPageSize = 100
+	defaultPollQPS      = 1.0
+)
+
+// DeviceLister is the part of TuyaClient the poller needs
+type DeviceLister interface {
+	GetDevices(pageNo, pageSize int, queryParams map[string]string) (*tuya.DevicesResult, error)
+}
+
+type deviceSnapshot struct {
+	productId string
+	name      string
+	online    bool
+	status    map[string]interface{}
+}
+
+// Poller detects device changes by periodically walking the device list and
+// diffing it with the previous walk, for projects without the message service
+type Poller struct {
+	logger    *logger.AppLogger
+	cfg       *config.Poller
+	client    DeviceLister
+	mu        sync.RWMutex
+	consumers []Consumer
+	snapshot  map[string]deviceSnapshot
+}
+
+func NewPoller(logger *logger.AppLogger, cfg *config.Config, client DeviceLister) *Poller {
+	return &Poller{logger: logger, cfg: &cfg.Poller, client: client}
+}
+
+// AddConsumer registers a consumer, every change event is delivered to all consumers
+func (p *Poller) AddConsumer(consumer Consumer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.consumers = append(p.consumers, consumer)
+}
+
+// Run walks the devices every poll interval until ctx is done. The first walk
+// only records the snapshot, changes are emitted from the second walk on.
+func (p *Poller) Run(ctx context.Context) error {
+	interval := p.cfg.Interval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	qps := p.cfg.QPS
+	if qps <= 0 {
+		qps = defaultPollQPS
+	}
+	// requests are spaced out to stay within the QPS budget
+	limiter := time.NewTicker(time.Duration(float64(time.Second) / qps))
+	defer limiter.Stop()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := p.Poll(ctx, limiter.C); err != nil {
+			p.logger.Warnw("poller_err", zap.String("error", err.Error()))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll runs a single walk, waiting on limiter before each request, and
+// emits the changes since the previous walk
+func (p *Poller) Poll(ctx context.Context, limiter <-chan time.Time) error {
+	current, err := p.walk(ctx, limiter)
+	if err != nil {
+		return err
+	}
+
+	previous := p.snapshot
+	p.snapshot = current
+	if previous == nil {
+		p.logger.Infof("poller snapshot initialised with %d devices", len(current))
+		return nil
+	}
+
+	now := time.Now()
+	for deviceId, device := range current {
+		meta := EventMeta{DeviceId: deviceId, ProductId: device.productId, Time: now}
+		old, ok := previous[deviceId]
+		if !ok {
+			// new devices only seed the snapshot
+			continue
+		}
+		if old.name != device.name {
+			p.emit(ctx, &RenamedEvent{EventMeta: meta, OldName: old.name, Name: device.name})
+		}
+		if !old.online && device.online {
+			p.emit(ctx, &WentOnlineEvent{EventMeta: meta})
+		}
+		if old.online && !device.online {
+			p.emit(ctx, &WentOfflineEvent{EventMeta: meta})
+		}
+		for code, value := range device.status {
+			oldValue, ok := old.status[code]
+			if ok && reflect.DeepEqual(oldValue, value) {
+				continue
+			}
+			p.emit(ctx, &DpChangedEvent{EventMeta: meta, Code: code, OldValue: oldValue, NewValue: value})
+		}
+	}
+	for deviceId, device := range previous {
+		if _, ok := current[deviceId]; !ok {
+			p.emit(ctx, &RemovedEvent{EventMeta: EventMeta{DeviceId: deviceId, ProductId: device.productId, Time: now}})
+		}
+	}
+	return nil
+}
+
+// walk pages through the devices in scope, a failed page fails the whole
+// walk so missing devices are never reported as removed
+func (p *Poller) walk(ctx context.Context, limiter <-chan time.Time) (map[string]deviceSnapshot, error) {
+	pageSize := p.cfg.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultPollPageSize
+	}
+	queryParams := map[string]string{}
+	if len(p.cfg.DeviceIds) > 0 {
+		queryParams["device_ids"] = strings.Join(p.cfg.DeviceIds, ",")
+	}
+	if len(p.cfg.ProductIds) > 0 {
+		queryParams["product_ids"] = strings.Join(p.cfg.ProductIds, ",")
+	}
+	categories := map[string]bool{}
+	for _, category := range p.cfg.Categories {
+		categories[category] = true
+	}
+
+	snapshot := map[string]deviceSnapshot{}
+	seen := 0
+	for pageNo := 1; ; pageNo++ {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-limiter:
+		}
+
+		page, err := p.client.GetDevices(pageNo, pageSize, queryParams)
+		if err != nil {
+			return nil, err
+		}
+		for _, device := range page.Devices {
+			if len(categories) > 0 && !categories[device.Category] {
+				continue
+			}
+			status := make(map[string]interface{}, len(device.Status))
+			for _, dp := range device.Status {
+				status[dp.Code] = dp.Value
+			}
+			snapshot[device.Id] = deviceSnapshot{
+				productId: device.ProductId,
+				name:      device.Name,
+				online:    device.Online,
+				status:    status,
+			}
+		}
+
+		seen += len(page.Devices)
+		if len(page.Devices) < pageSize || int64(seen) >= page.Total {
+			return snapshot, nil
+		}
+	}
+}
+
+func (p *Poller) emit(ctx context.Context, event Event) {
+	p.mu.RLock()
+	consumers := p.consumers
+	p.mu.RUnlock()
+
+	for _, consumer := range consumers {
+		if err := consumer.Consume(ctx, event); err != nil {
+			p.logger.Warnw("poller_consume_err",
+				zap.String("device_id", event.Meta().DeviceId),
+				zap.String("error", err.Error()))
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/pkg/logger"
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+)
+
+// stubDevices serves devices one page at a time, or err
+type stubDevices struct {
+	devices []tuya.Device
+	err     error
+	pages   int
+}
+
+func (s *stubDevices) GetDevices(pageNo, pageSize int, queryParams map[string]string) (*tuya.DevicesResult, error) {
+	s.pages++
+	if s.err != nil {
+		return nil, s.err
+	}
+	result := &tuya.DevicesResult{Total: int64(len(s.devices)), Devices: []tuya.Device{}}
+	for i := (pageNo - 1) * pageSize; i < len(s.devices) && i < pageNo*pageSize; i++ {
+		result.Devices = append(result.Devices, s.devices[i])
+	}
+	return result, nil
+}
+
+// recorder records the events it consumes as short descriptions
+type recorder struct {
+	events []string
+}
+
+func (r *recorder) Consume(ctx context.Context, event Event) error {
+	description := fmt.Sprintf("%s %s", event.Type(), event.Meta().DeviceId)
+	switch e := event.(type) {
+	case *DpChangedEvent:
+		description += fmt.Sprintf(" %s %v->%v", e.Code, e.OldValue, e.NewValue)
+	case *RenamedEvent:
+		description += fmt.Sprintf(" %s->%s", e.OldName, e.Name)
+	}
+	r.events = append(r.events, description)
+	return nil
+}
+
+// sorted returns the events recorded since the last call in a stable order,
+// the poller emits them in map order
+func (r *recorder) sorted() []string {
+	events := r.events
+	r.events = nil
+	sort.Strings(events)
+	return events
+}
+
+func plug(id, name string, online bool, on interface{}) tuya.Device {
+	return tuya.Device{Id: id, Name: name, ProductId: "p1", Category: "cz", Online: online,
+		Status: []tuya.DeviceStatus{{Code: "switch_1", Value: on}}}
+}
+
+func newTestPoller(client DeviceLister, cfg config.Poller) (*Poller, *recorder) {
+	appCfg := &config.Config{Logger: config.Logger{Level: "fatal", Encoding: "console"}, Poller: cfg}
+	appLogger := logger.NewAppLogger(appCfg)
+	appLogger.InitLogger()
+	poller := NewPoller(appLogger, appCfg, client)
+	events := &recorder{}
+	poller.AddConsumer(events)
+	return poller, events
+}
+
+// noLimit lets every request of a walk through
+func noLimit() <-chan time.Time {
+	limiter := make(chan time.Time)
+	close(limiter)
+	return limiter
+}
+
+func TestPollerDiff(t *testing.T) {
+	client := &stubDevices{devices: []tuya.Device{
+		plug("changed", "plug", true, false),
+		plug("online", "plug", false, false),
+		plug("offline", "plug", true, false),
+		plug("renamed", "plug", true, false),
+		plug("removed", "plug", true, false),
+		plug("same", "plug", true, false),
+	}}
+	poller, events := newTestPoller(client, config.Poller{PageSize: 2})
+	ctx := context.Background()
+
+	if err := poller.Poll(ctx, noLimit()); err != nil {
+		t.Fatal(err)
+	}
+	if got := events.sorted(); len(got) != 0 {
+		t.Fatalf("first walk emitted %v, want it to only seed the snapshot", got)
+	}
+	if client.pages != 3 {
+		t.Fatalf("%d pages walked, want 3", client.pages)
+	}
+
+	client.devices = []tuya.Device{
+		plug("changed", "plug", true, true),
+		plug("online", "plug", true, false),
+		plug("offline", "plug", false, false),
+		plug("renamed", "lamp", true, false),
+		plug("same", "plug", true, false),
+		plug("added", "plug", true, true),
+	}
+	client.devices[0].Status = append(client.devices[0].Status, tuya.DeviceStatus{Code: "countdown_1", Value: 60})
+	if err := poller.Poll(ctx, noLimit()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"dp_changed changed countdown_1 <nil>->60",
+		"dp_changed changed switch_1 false->true",
+		"removed removed",
+		"renamed renamed plug->lamp",
+		"went_offline offline",
+		"went_online online",
+	}
+	if got := events.sorted(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("events =\n%v\nwant\n%v", got, want)
+	}
+
+	// no change, no events
+	if err := poller.Poll(ctx, noLimit()); err != nil {
+		t.Fatal(err)
+	}
+	if got := events.sorted(); len(got) != 0 {
+		t.Fatalf("unchanged walk emitted %v", got)
+	}
+}
+
+func TestPollerFailedWalkKeepsTheSnapshot(t *testing.T) {
+	client := &stubDevices{devices: []tuya.Device{plug("d1", "plug", true, false)}}
+	poller, events := newTestPoller(client, config.Poller{})
+	ctx := context.Background()
+	if err := poller.Poll(ctx, noLimit()); err != nil {
+		t.Fatal(err)
+	}
+
+	client.err = errors.New("rate limited")
+	if err := poller.Poll(ctx, noLimit()); err == nil {
+		t.Fatal("failed walk returned no error")
+	}
+	if got := events.sorted(); len(got) != 0 {
+		t.Fatalf("failed walk emitted %v, want no removed devices", got)
+	}
+
+	client.err = nil
+	client.devices[0] = plug("d1", "plug", true, true)
+	if err := poller.Poll(ctx, noLimit()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := events.sorted(), []string{"dp_changed d1 switch_1 false->true"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("events = %v, want %v against the snapshot before the failure", got, want)
+	}
+}
+
+func TestPollerCategories(t *testing.T) {
+	light := plug("light", "light", true, false)
+	light.Category = "dj"
+	client := &stubDevices{devices: []tuya.Device{plug("plug", "plug", true, false), light}}
+	poller, events := newTestPoller(client, config.Poller{Categories: []string{"dj"}})
+	ctx := context.Background()
+	if err := poller.Poll(ctx, noLimit()); err != nil {
+		t.Fatal(err)
+	}
+
+	client.devices[0] = plug("plug", "plug", true, true)
+	client.devices[1].Status = []tuya.DeviceStatus{{Code: "switch_1", Value: true}}
+	if err := poller.Poll(ctx, noLimit()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := events.sorted(), []string{"dp_changed light switch_1 false->true"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("events = %v, want only the dj device", got)
+	}
+}
@@ -0,0 +1,263 @@
+package events
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultMaxRedeliveries = 5
+	defaultAckTimeout      = 3 * time.Second
+	defaultRetryDelay      = time.Second
+	minReconnectDelay      = time.Second
+	maxReconnectDelay      = time.Minute
+	pingInterval           = 30 * time.Second
+)
+
+// Consumer handles decoded events, returning an error retries the event for
+// that consumer only
+type Consumer interface {
+	Consume(ctx context.Context, event Event) error
+}
+
+type ConsumerFunc func(ctx context.Context, event Event) error
+
+func (f ConsumerFunc) Consume(ctx context.Context, event Event) error {
+	return f(ctx, event)
+}
+
+// message received from the pulsar websocket consumer API
+type pulsarMessage struct {
+	MessageId       string            `json:"messageId"`
+	Payload         string            `json:"payload"`
+	Properties      map[string]string `json:"properties"`
+	PublishTime     string            `json:"publishTime"`
+	RedeliveryCount int               `json:"redeliveryCount"`
+}
+
+type pulsarAck struct {
+	Type      string `json:"type,omitempty"`
+	MessageId string `json:"messageId"`
+}
+
+// Credentials provides the client id and the secrets of the project, the
+// active one first, e.g. a *tuya.TuyaClient following secret rotations
+type Credentials interface {
+	Secrets() (clientId string, secrets []string)
+}
+
+// Subscriber consumes the Tuya message service through the pulsar websocket
+// API and dispatches decoded events to the registered consumers
+type Subscriber struct {
+	logger    *logger.AppLogger
+	cfg       *config.Events
+	creds     Credentials
+	mu        sync.RWMutex
+	consumers []Consumer
+	writeMu   sync.Mutex
+	// first delay before a failed consumer is retried, doubled on each retry
+	retryDelay time.Duration
+
+	// Dialer connects to the message service at events.Url, it can be
+	// replaced before Run, e.g. to go through a proxy
+	Dialer *websocket.Dialer
+}
+
+// NewSubscriber reads the secrets from creds on every connection and message,
+// so a rotated secret is picked up without a restart
+func NewSubscriber(logger *logger.AppLogger, cfg *config.Config, creds Credentials) *Subscriber {
+	return &Subscriber{
+		logger:     logger,
+		cfg:        &cfg.Events,
+		creds:      creds,
+		Dialer:     websocket.DefaultDialer,
+		retryDelay: defaultRetryDelay,
+	}
+}
+
+// AddConsumer registers a consumer, every event is delivered to all consumers
+func (s *Subscriber) AddConsumer(consumer Consumer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.consumers = append(s.consumers, consumer)
+}
+
+// TopicUrl builds the websocket consumer url of the project topic
+func (s *Subscriber) TopicUrl() string {
+	clientId, _ := s.creds.Secrets()
+	env := s.cfg.Env
+	if env == "" {
+		env = "event"
+	}
+	ackTimeout := s.cfg.AckTimeout
+	if ackTimeout <= 0 {
+		ackTimeout = defaultAckTimeout
+	}
+	return fmt.Sprintf("%s/ws/v2/consumer/persistent/%s/out/%s/%s-sub?ackTimeoutMillis=%d&subscriptionType=Failover",
+		strings.TrimSuffix(s.cfg.Url, "/"), clientId, env, clientId, ackTimeout.Milliseconds())
+}
+
+// password for the message service is md5(clientId + md5(secret))[8:24]
+func password(clientId, secret string) string {
+	secretSum := md5.Sum([]byte(secret))
+	sum := md5.Sum([]byte(clientId + hex.EncodeToString(secretSum[:])))
+	return hex.EncodeToString(sum[:])[8:24]
+}
+
+// Run connects to the message service and consumes messages until ctx is
+// done, reconnecting with backoff when the connection drops
+func (s *Subscriber) Run(ctx context.Context) error {
+	delay := minReconnectDelay
+	for {
+		connectedAt := time.Now()
+		err := s.consume(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		s.logger.Warnw("events_connection_lost", zap.String("error", err.Error()))
+
+		if time.Since(connectedAt) > maxReconnectDelay {
+			delay = minReconnectDelay
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+func (s *Subscriber) consume(ctx context.Context) error {
+	clientId, secrets := s.creds.Secrets()
+	if len(secrets) == 0 {
+		return errors.New("no secret configured")
+	}
+	header := http.Header{}
+	header.Set("username", clientId)
+	header.Set("password", password(clientId, secrets[0]))
+
+	conn, _, err := s.Dialer.DialContext(ctx, s.TopicUrl(), header)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	s.logger.Infof("connected to tuya message service %s", s.cfg.Url)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				conn.Close()
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+				s.writeMu.Lock()
+				err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+				s.writeMu.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		msg := new(pulsarMessage)
+		if err := conn.ReadJSON(msg); err != nil {
+			return err
+		}
+		s.handle(ctx, msg)
+		if err := s.reply(conn, pulsarAck{MessageId: msg.MessageId}); err != nil {
+			return err
+		}
+	}
+}
+
+// handle decodes a message and delivers it to the consumers. Every message is
+// acknowledged: a redelivery would reach all the consumers again, so a failing
+// consumer retries the event on its own instead. Messages that can't be
+// decoded are dropped so they don't block the subscription.
+func (s *Subscriber) handle(ctx context.Context, msg *pulsarMessage) {
+	payload, err := base64.StdEncoding.DecodeString(msg.Payload)
+	if err != nil {
+		s.logger.Errorw("events_decode_err", zap.String("error", err.Error()))
+		return
+	}
+	_, secrets := s.creds.Secrets()
+	event, err := DecodeEvent(msg.MessageId, payload, msg.Properties, secrets...)
+	if errors.Is(err, ErrUnknownEvent) {
+		s.logger.Debugw("events_skipped", zap.String("reason", err.Error()))
+		return
+	}
+	if err != nil {
+		s.logger.Errorw("events_decode_err", zap.String("error", err.Error()))
+		return
+	}
+
+	s.mu.RLock()
+	consumers := s.consumers
+	s.mu.RUnlock()
+	for i, consumer := range consumers {
+		s.deliver(ctx, i, consumer, event, 0)
+	}
+}
+
+// deliver hands the event to a consumer. A failure is retried by a timer once
+// its backoff elapsed, up to MaxRedeliveries times, so the other consumers and
+// the next messages aren't held; a retried event may reach the consumer after
+// newer ones.
+func (s *Subscriber) deliver(ctx context.Context, index int, consumer Consumer, event Event, retries int) {
+	err := consumer.Consume(ctx, event)
+	if err == nil || ctx.Err() != nil {
+		return
+	}
+	maxRedeliveries := s.cfg.MaxRedeliveries
+	if maxRedeliveries <= 0 {
+		maxRedeliveries = defaultMaxRedeliveries
+	}
+	if retries >= maxRedeliveries {
+		s.logger.Errorw("events_dropped",
+			zap.String("message_id", event.Meta().MessageId),
+			zap.Int("consumer", index),
+			zap.Int("retries", retries),
+			zap.String("error", err.Error()))
+		return
+	}
+	s.logger.Warnw("events_consumer_err",
+		zap.String("message_id", event.Meta().MessageId),
+		zap.Int("consumer", index),
+		zap.String("error", err.Error()))
+	time.AfterFunc(s.retryDelay<<retries, func() {
+		if ctx.Err() == nil {
+			s.deliver(ctx, index, consumer, event, retries+1)
+		}
+	})
+}
+
+func (s *Subscriber) reply(conn *websocket.Conn, ack pulsarAck) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return conn.WriteJSON(ack)
+}
@@ -0,0 +1,330 @@
+package events
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/pkg/logger"
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+)
+
+const (
+	testClientId        = "client0123456789"
+	testSecret          = "0123456789abcdef0123456789abcdef"
+	testSecondarySecret = "abcdef0123456789abcdef0123456789"
+)
+
+// staticCredentials are the credentials of a client that never rotates
+type staticCredentials []string
+
+func (c staticCredentials) Secrets() (string, []string) {
+	return testClientId, c
+}
+
+// fakeBroker is the pulsar websocket consumer API of the message service. It
+// delivers its messages one at a time and redelivers the negatively
+// acknowledged ones with a higher redelivery count, which the subscriber
+// never asks for.
+type fakeBroker struct {
+	t        *testing.T
+	server   *httptest.Server
+	messages chan pulsarMessage
+	acks     chan pulsarAck
+	header   atomic.Value
+	done     chan struct{}
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	b := &fakeBroker{
+		t:        t,
+		messages: make(chan pulsarMessage, 10),
+		acks:     make(chan pulsarAck, 10),
+		done:     make(chan struct{}),
+	}
+	upgrader := websocket.Upgrader{}
+	b.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.header.Store(r.Header.Clone())
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b.serve(conn)
+	}))
+	t.Cleanup(func() {
+		close(b.done)
+		b.server.Close()
+	})
+	return b
+}
+
+func (b *fakeBroker) serve(conn *websocket.Conn) {
+	for {
+		var msg pulsarMessage
+		select {
+		case msg = <-b.messages:
+		case <-b.done:
+			return
+		}
+		if err := conn.WriteJSON(msg); err != nil {
+			return
+		}
+		ack := pulsarAck{}
+		if err := conn.ReadJSON(&ack); err != nil {
+			return
+		}
+		b.acks <- ack
+		if ack.Type == "negativeAcknowledge" {
+			msg.RedeliveryCount++
+			b.messages <- msg
+		}
+	}
+}
+
+func (b *fakeBroker) url() string {
+	return "ws" + strings.TrimPrefix(b.server.URL, "http")
+}
+
+func (b *fakeBroker) nextAck() pulsarAck {
+	b.t.Helper()
+	select {
+	case ack := <-b.acks:
+		return ack
+	case <-time.After(2 * time.Second):
+		b.t.Fatal("no ack received")
+		return pulsarAck{}
+	}
+}
+
+func (b *fakeBroker) noMoreAcks() {
+	b.t.Helper()
+	select {
+	case ack := <-b.acks:
+		b.t.Fatalf("unexpected ack %+v", ack)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// encryptData encrypts data the way the message service does, with the
+// middle of the secret and AES-ECB, or AES-GCM behind a 12 byte nonce
+func encryptData(t *testing.T, secret, encryptModel string, data interface{}) string {
+	t.Helper()
+	plain, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := []byte(secret[8:24])
+	if encryptModel != "aes_gcm" {
+		encrypted, err := tuya.AesEcbEncrypt(plain, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.StdEncoding.EncodeToString(encrypted)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := []byte("0123456789ab")
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plain, nil))
+}
+
+// encryptMessage builds a message the way the message service does, the data
+// encrypted with AES-ECB
+func encryptMessage(t *testing.T, id, secret string, protocol int, data interface{}) pulsarMessage {
+	t.Helper()
+	payload, err := json.Marshal(messagePayload{
+		Protocol: protocol,
+		Pv:       "2.0",
+		T:        time.Now().UnixMilli(),
+		Data:     encryptData(t, secret, "aes_ecb", data),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pulsarMessage{
+		MessageId:  id,
+		Payload:    base64.StdEncoding.EncodeToString(payload),
+		Properties: map[string]string{"em": "aes_ecb"},
+	}
+}
+
+func statusMessage(t *testing.T, id, secret string) pulsarMessage {
+	return encryptMessage(t, id, secret, protocolStatusReport, map[string]interface{}{
+		"devId":      "device1",
+		"productKey": "product1",
+		"status":     []StatusValue{{Code: "switch_1", Value: true, T: 1}},
+	})
+}
+
+func runSubscriber(t *testing.T, broker *fakeBroker, creds Credentials, consumers ...Consumer) *Subscriber {
+	t.Helper()
+	cfg := &config.Config{
+		Logger: config.Logger{Level: "error", Encoding: "console"},
+		Events: config.Events{Url: broker.url(), MaxRedeliveries: 2},
+	}
+	appLogger := logger.NewAppLogger(cfg)
+	appLogger.InitLogger()
+
+	subscriber := NewSubscriber(appLogger, cfg, creds)
+	subscriber.Dialer = &websocket.Dialer{HandshakeTimeout: time.Second}
+	subscriber.retryDelay = 10 * time.Millisecond
+	for _, consumer := range consumers {
+		subscriber.AddConsumer(consumer)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		subscriber.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return subscriber
+}
+
+func TestSubscriberDecryptsAndAcks(t *testing.T) {
+	broker := newFakeBroker(t)
+	received := make(chan Event, 1)
+	runSubscriber(t, broker, staticCredentials{testSecret}, ConsumerFunc(func(ctx context.Context, event Event) error {
+		received <- event
+		return nil
+	}))
+
+	broker.messages <- statusMessage(t, "m1", testSecret)
+	if ack := broker.nextAck(); ack.Type != "" || ack.MessageId != "m1" {
+		t.Fatalf("ack = %+v, want an ack of m1", ack)
+	}
+
+	event := <-received
+	status, ok := event.(*StatusReportEvent)
+	if !ok {
+		t.Fatalf("event = %T, want *StatusReportEvent", event)
+	}
+	if status.MessageId != "m1" || status.DeviceId != "device1" || len(status.Status) != 1 || status.Status[0].Value != true {
+		t.Fatalf("event = %+v", status)
+	}
+
+	header := broker.header.Load().(http.Header)
+	if header.Get("username") != testClientId || header.Get("password") != password(testClientId, testSecret) {
+		t.Fatalf("connected with username %q and password %q", header.Get("username"), header.Get("password"))
+	}
+}
+
+func TestSubscriberRetriesTheFailingConsumer(t *testing.T) {
+	broker := newFakeBroker(t)
+	var healthy, failing atomic.Int32
+	retried := make(chan struct{})
+	runSubscriber(t, broker, staticCredentials{testSecret},
+		ConsumerFunc(func(ctx context.Context, event Event) error {
+			healthy.Add(1)
+			return nil
+		}),
+		ConsumerFunc(func(ctx context.Context, event Event) error {
+			if failing.Add(1) == 1 {
+				return errors.New("consumer unavailable")
+			}
+			close(retried)
+			return nil
+		}))
+
+	// the message is acknowledged at once, a redelivery would reach the
+	// healthy consumer again
+	broker.messages <- statusMessage(t, "m1", testSecret)
+	if ack := broker.nextAck(); ack.Type != "" || ack.MessageId != "m1" {
+		t.Fatalf("ack = %+v, want an ack of m1", ack)
+	}
+	select {
+	case <-retried:
+	case <-time.After(2 * time.Second):
+		t.Fatal("failing consumer not retried")
+	}
+	broker.noMoreAcks()
+	if n := healthy.Load(); n != 1 {
+		t.Fatalf("healthy consumer called %d times, want 1", n)
+	}
+	if n := failing.Load(); n != 2 {
+		t.Fatalf("failing consumer called %d times, want 2", n)
+	}
+}
+
+func TestSubscriberDropsAfterMaxRedeliveries(t *testing.T) {
+	broker := newFakeBroker(t)
+	var calls atomic.Int32
+	runSubscriber(t, broker, staticCredentials{testSecret}, ConsumerFunc(func(ctx context.Context, event Event) error {
+		calls.Add(1)
+		return errors.New("consumer unavailable")
+	}))
+
+	broker.messages <- statusMessage(t, "m1", testSecret)
+	if ack := broker.nextAck(); ack.Type != "" || ack.MessageId != "m1" {
+		t.Fatalf("ack = %+v, want an ack of m1", ack)
+	}
+	// the first delivery and MaxRedeliveries retries, 10ms then 20ms apart
+	deadline := time.Now().Add(2 * time.Second)
+	for calls.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := calls.Load(); n != 3 {
+		t.Fatalf("consumer called %d times, want 3", n)
+	}
+	broker.noMoreAcks()
+}
+
+func TestSubscriberSkipsUndecryptable(t *testing.T) {
+	broker := newFakeBroker(t)
+	var calls atomic.Int32
+	runSubscriber(t, broker, staticCredentials{testSecret}, ConsumerFunc(func(ctx context.Context, event Event) error {
+		calls.Add(1)
+		return nil
+	}))
+
+	// redelivering a message that can't be decrypted would block the subscription
+	broker.messages <- statusMessage(t, "m1", "fedcba9876543210fedcba9876543210")
+	if ack := broker.nextAck(); ack.Type != "" || ack.MessageId != "m1" {
+		t.Fatalf("ack = %+v, want an ack of m1", ack)
+	}
+	if n := calls.Load(); n != 0 {
+		t.Fatalf("consumer called %d times for an undecryptable message", n)
+	}
+}
+
+func TestSubscriberFallsBackToTheOtherSecret(t *testing.T) {
+	broker := newFakeBroker(t)
+	received := make(chan Event, 2)
+	runSubscriber(t, broker, staticCredentials{testSecret, testSecondarySecret}, ConsumerFunc(func(ctx context.Context, event Event) error {
+		received <- event
+		return nil
+	}))
+
+	// messages encrypted before and after the rotation on the Tuya side
+	broker.messages <- statusMessage(t, "m1", testSecret)
+	broker.messages <- statusMessage(t, "m2", testSecondarySecret)
+	for _, id := range []string{"m1", "m2"} {
+		if ack := broker.nextAck(); ack.Type != "" || ack.MessageId != id {
+			t.Fatalf("ack = %+v, want an ack of %s", ack, id)
+		}
+		if event := <-received; event.Meta().MessageId != id {
+			t.Fatalf("event %s, want %s", event.Meta().MessageId, id)
+		}
+	}
+}
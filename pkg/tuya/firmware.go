@@ -0,0 +1,58 @@
+package tuya
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+/*
+Query the firmware upgrade information of every module (wifi, mcu, ...) of a device
+*/
+func (c *TuyaClient) GetFirmwareInfo(deviceId string) ([]FirmwareInfo, error) {
+	var body []byte
+	baseURL := c.GetBaseUrl(1.0)
+	endpointURL := fmt.Sprintf("%s/devices/%s/upgrade-infos", baseURL, deviceId)
+	response, err := c.DoRequest(endpointURL, "GET", body)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody := new(FirmwareInfoResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return nil, fmt.Errorf("success false for response")
+	}
+	return respBody.Result, nil
+}
+
+/*
+Confirm the firmware upgrade of a device module, firmwareType is the module type from GetFirmwareInfo
+*/
+func (c *TuyaClient) UpgradeFirmware(deviceId string, firmwareType int) (bool, error) {
+	var body []byte
+	baseURL := c.GetBaseUrl(1.0)
+	endpointURL := fmt.Sprintf("%s/devices/%s/upgrade-infos/%d", baseURL, deviceId, firmwareType)
+	response, err := c.DoRequest(endpointURL, "PUT", body)
+	if err != nil {
+		return false, err
+	}
+
+	respBody := new(BooleanResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return false, fmt.Errorf("success false for response")
+	}
+	return respBody.Result, nil
+}
@@ -0,0 +1,310 @@
+package tuya
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+/*
+Create a device group in a space from a list of devices of the same product
+*/
+func (c *TuyaClient) CreateGroup(spaceId, name, productId string, deviceIds []string) (int64, error) {
+	baseURL := c.GetBaseUrl(2.0)
+	endpointURL := fmt.Sprintf("%s/cloud/thing/group", baseURL)
+
+	payload := map[string]string{
+		"space_id":   spaceId,
+		"name":       name,
+		"product_id": productId,
+		"device_ids": strings.Join(deviceIds, ","),
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		c.logger.Errorw("json_encode_err", zap.String("error", err.Error()))
+		return 0, err
+	}
+	response, err := c.DoRequest(endpointURL, "POST", body)
+	if err != nil {
+		return 0, err
+	}
+
+	respBody := new(GroupIdResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return 0, fmt.Errorf("success false for response")
+	}
+	return respBody.Result.Id, nil
+}
+
+/*
+Query a list of groups in a space
+*/
+func (c *TuyaClient) GetGroups(spaceId string, pageNo, pageSize int) (*GroupsResult, error) {
+	var body []byte
+	baseURL := c.GetBaseUrl(2.0)
+	endpointURL := fmt.Sprintf("%s/cloud/thing/group/space/%s?page_no=%d&page_size=%d", baseURL, spaceId, pageNo, pageSize)
+	response, err := c.DoRequest(endpointURL, "GET", body)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody := new(GroupsResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return nil, fmt.Errorf("success false for response")
+	}
+	return &respBody.Result, nil
+}
+
+/*
+Query the details of a group
+*/
+func (c *TuyaClient) GetGroup(groupId int64) (*Group, error) {
+	var body []byte
+	baseURL := c.GetBaseUrl(2.0)
+	endpointURL := fmt.Sprintf("%s/cloud/thing/group/%d", baseURL, groupId)
+	response, err := c.DoRequest(endpointURL, "GET", body)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody := new(GroupResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return nil, fmt.Errorf("success false for response")
+	}
+	return &respBody.Result, nil
+}
+
+/*
+Query the devices in a group
+*/
+func (c *TuyaClient) GetGroupDevices(groupId int64, pageNo, pageSize int) (*GroupDevicesResult, error) {
+	var body []byte
+	baseURL := c.GetBaseUrl(2.0)
+	endpointURL := fmt.Sprintf("%s/cloud/thing/group/%d/devices?page_no=%d&page_size=%d", baseURL, groupId, pageNo, pageSize)
+	response, err := c.DoRequest(endpointURL, "GET", body)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody := new(GroupDevicesResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return nil, fmt.Errorf("success false for response")
+	}
+	return &respBody.Result, nil
+}
+
+/*
+Add devices to a group
+*/
+func (c *TuyaClient) AddGroupDevices(groupId int64, deviceIds []string) (bool, error) {
+	baseURL := c.GetBaseUrl(2.0)
+	endpointURL := fmt.Sprintf("%s/cloud/thing/group/%d/device", baseURL, groupId)
+
+	payload := map[string]string{
+		"device_ids": strings.Join(deviceIds, ","),
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		c.logger.Errorw("json_encode_err", zap.String("error", err.Error()))
+		return false, err
+	}
+	response, err := c.DoRequest(endpointURL, "POST", body)
+	if err != nil {
+		return false, err
+	}
+
+	respBody := new(BooleanResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return false, fmt.Errorf("success false for response")
+	}
+	return respBody.Result, nil
+}
+
+/*
+Remove devices from a group
+*/
+func (c *TuyaClient) RemoveGroupDevices(groupId int64, deviceIds []string) (bool, error) {
+	var body []byte
+	baseURL := c.GetBaseUrl(2.0)
+	encodedParams := url.Values{}
+	encodedParams.Add("device_ids", strings.Join(deviceIds, ","))
+	endpointURL := fmt.Sprintf("%s/cloud/thing/group/%d/device?%s", baseURL, groupId, encodedParams.Encode())
+
+	response, err := c.DoRequest(endpointURL, "DELETE", body)
+	if err != nil {
+		return false, err
+	}
+
+	respBody := new(BooleanResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return false, fmt.Errorf("success false for response")
+	}
+	return respBody.Result, nil
+}
+
+/*
+Modify a group name
+*/
+func (c *TuyaClient) RenameGroup(groupId int64, name string) (bool, error) {
+	var body []byte
+	baseURL := c.GetBaseUrl(2.0)
+	endpointURL := fmt.Sprintf("%s/cloud/thing/group/%d/%s", baseURL, groupId, url.PathEscape(name))
+
+	response, err := c.DoRequest(endpointURL, "PUT", body)
+	if err != nil {
+		return false, err
+	}
+
+	respBody := new(BooleanResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return false, fmt.Errorf("success false for response")
+	}
+	return respBody.Result, nil
+}
+
+/*
+Delete a group
+*/
+func (c *TuyaClient) DeleteGroup(groupId int64) (bool, error) {
+	var body []byte
+	baseURL := c.GetBaseUrl(2.0)
+	endpointURL := fmt.Sprintf("%s/cloud/thing/group/%d", baseURL, groupId)
+
+	response, err := c.DoRequest(endpointURL, "DELETE", body)
+	if err != nil {
+		return false, err
+	}
+
+	respBody := new(BooleanResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return false, fmt.Errorf("success false for response")
+	}
+	return respBody.Result, nil
+}
+
+/*
+Query the properties of a group
+*/
+func (c *TuyaClient) GetGroupProperties(groupId int64) (map[string]interface{}, error) {
+	var body []byte
+	baseURL := c.GetBaseUrl(2.0)
+	endpointURL := fmt.Sprintf("%s/cloud/thing/group/%d/properties", baseURL, groupId)
+
+	response, err := c.DoRequest(endpointURL, "GET", body)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody := new(GroupPropertiesResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return nil, fmt.Errorf("success false for response")
+	}
+
+	// properties are returned as a JSON encoded string
+	properties := map[string]interface{}{}
+	if respBody.Result.Properties != "" {
+		err = json.Unmarshal([]byte(respBody.Result.Properties), &properties)
+		if err != nil {
+			c.logger.Errorw("json_decode_err",
+				zap.String("error", err.Error()))
+			return nil, err
+		}
+	}
+	return properties, nil
+}
+
+/*
+Issue properties (commands) to every device in a group
+*/
+func (c *TuyaClient) SendGroupCommands(groupId int64, properties map[string]interface{}) (bool, error) {
+	baseURL := c.GetBaseUrl(2.0)
+	endpointURL := fmt.Sprintf("%s/cloud/thing/group/properties", baseURL)
+
+	propertiesStr, err := json.Marshal(properties)
+	if err != nil {
+		c.logger.Errorw("json_encode_err", zap.String("error", err.Error()))
+		return false, err
+	}
+	payload := map[string]interface{}{
+		"group_id":   groupId,
+		"properties": string(propertiesStr),
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		c.logger.Errorw("json_encode_err", zap.String("error", err.Error()))
+		return false, err
+	}
+	response, err := c.DoRequest(endpointURL, "POST", body)
+	if err != nil {
+		return false, err
+	}
+
+	respBody := new(BooleanResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return false, fmt.Errorf("success false for response")
+	}
+	return respBody.Result, nil
+}
@@ -0,0 +1,245 @@
+package tuya
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+/*
+Query the remotes bound to an IR hub (universal remote)
+*/
+func (c *TuyaClient) GetIrRemotes(infraredId string) ([]IrRemote, error) {
+	var body []byte
+	baseURL := c.GetBaseUrl(2.0)
+	endpointURL := fmt.Sprintf("%s/infrareds/%s/remotes", baseURL, infraredId)
+	response, err := c.DoRequest(endpointURL, "GET", body)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody := new(IrRemotesResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return nil, fmt.Errorf("success false for response")
+	}
+	return respBody.Result, nil
+}
+
+/*
+Query the keys of a remote
+*/
+func (c *TuyaClient) GetIrRemoteKeys(infraredId, remoteId string) (*IrRemoteKeys, error) {
+	var body []byte
+	baseURL := c.GetBaseUrl(2.0)
+	endpointURL := fmt.Sprintf("%s/infrareds/%s/remotes/%s/keys", baseURL, infraredId, remoteId)
+	response, err := c.DoRequest(endpointURL, "GET", body)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody := new(IrRemoteKeysResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return nil, fmt.Errorf("success false for response")
+	}
+	return &respBody.Result, nil
+}
+
+/*
+Send a key of a remote through the IR hub
+*/
+func (c *TuyaClient) SendIrKey(infraredId, remoteId string, categoryId, keyId int, key string) (bool, error) {
+	baseURL := c.GetBaseUrl(2.0)
+	endpointURL := fmt.Sprintf("%s/infrareds/%s/remotes/%s/command", baseURL, infraredId, remoteId)
+
+	payload := map[string]interface{}{
+		"category_id": categoryId,
+		"key_id":      keyId,
+		"key":         key,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		c.logger.Errorw("json_encode_err", zap.String("error", err.Error()))
+		return false, err
+	}
+	response, err := c.DoRequest(endpointURL, "POST", body)
+	if err != nil {
+		return false, err
+	}
+
+	respBody := new(BooleanResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return false, fmt.Errorf("success false for response")
+	}
+	return respBody.Result, nil
+}
+
+/*
+Send the full state (power, mode, temperature and wind speed) to an air conditioner remote
+*/
+func (c *TuyaClient) SendAcState(infraredId, remoteId string, state AcState) (bool, error) {
+	baseURL := c.GetBaseUrl(2.0)
+	endpointURL := fmt.Sprintf("%s/infrareds/%s/air-conditioners/%s/scenes-command", baseURL, infraredId, remoteId)
+
+	body, err := json.Marshal(state)
+	if err != nil {
+		c.logger.Errorw("json_encode_err", zap.String("error", err.Error()))
+		return false, err
+	}
+	response, err := c.DoRequest(endpointURL, "POST", body)
+	if err != nil {
+		return false, err
+	}
+
+	respBody := new(BooleanResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return false, fmt.Errorf("success false for response")
+	}
+	return respBody.Result, nil
+}
+
+/*
+Put the IR hub in or out of learning state
+*/
+func (c *TuyaClient) SetIrLearningState(infraredId string, learning bool) (bool, error) {
+	var body []byte
+	baseURL := c.GetBaseUrl(2.0)
+	endpointURL := fmt.Sprintf("%s/infrareds/%s/learning-state?state=%t", baseURL, infraredId, learning)
+	response, err := c.DoRequest(endpointURL, "PUT", body)
+	if err != nil {
+		return false, err
+	}
+
+	respBody := new(BooleanResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return false, fmt.Errorf("success false for response")
+	}
+	return respBody.Result, nil
+}
+
+/*
+Query the code learnt by the IR hub since learningTime (millisecond timestamp)
+*/
+func (c *TuyaClient) GetIrLearningCode(infraredId string, learningTime int64) (*IrLearningCode, error) {
+	var body []byte
+	baseURL := c.GetBaseUrl(2.0)
+	endpointURL := fmt.Sprintf("%s/infrareds/%s/learning-codes?learning_time=%d", baseURL, infraredId, learningTime)
+	response, err := c.DoRequest(endpointURL, "GET", body)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody := new(IrLearningCodeResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return nil, fmt.Errorf("success false for response")
+	}
+	return &respBody.Result, nil
+}
+
+/*
+Save learnt codes as a custom remote, returns the id of the new remote
+*/
+func (c *TuyaClient) SaveIrLearningCodes(infraredId string, categoryId int, brandName, remoteName string, codes []IrLearnedKey) (string, error) {
+	baseURL := c.GetBaseUrl(2.0)
+	endpointURL := fmt.Sprintf("%s/infrareds/%s/learning-codes", baseURL, infraredId)
+
+	if len(codes) == 0 {
+		return "", fmt.Errorf("codes can not be empty")
+	}
+	payload := map[string]interface{}{
+		"category_id": categoryId,
+		"brand_name":  brandName,
+		"remote_name": remoteName,
+		"codes":       codes,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		c.logger.Errorw("json_encode_err", zap.String("error", err.Error()))
+		return "", err
+	}
+	response, err := c.DoRequest(endpointURL, "POST", body)
+	if err != nil {
+		return "", err
+	}
+
+	respBody := new(IrRemoteIdResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return "", fmt.Errorf("success false for response")
+	}
+	return respBody.Result.RemoteId, nil
+}
+
+/*
+Send a learnt code through a custom remote
+*/
+func (c *TuyaClient) SendIrLearningCode(infraredId, remoteId, code string) (bool, error) {
+	baseURL := c.GetBaseUrl(2.0)
+	endpointURL := fmt.Sprintf("%s/infrareds/%s/remotes/%s/learning-codes", baseURL, infraredId, remoteId)
+
+	payload := map[string]string{
+		"code": code,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		c.logger.Errorw("json_encode_err", zap.String("error", err.Error()))
+		return false, err
+	}
+	response, err := c.DoRequest(endpointURL, "POST", body)
+	if err != nil {
+		return false, err
+	}
+
+	respBody := new(BooleanResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return false, fmt.Errorf("success false for response")
+	}
+	return respBody.Result, nil
+}
@@ -0,0 +1,239 @@
+package tuya
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+/*
+Get a temporary ticket to encrypt door lock passwords and authorize remote unlocking.
+The returned ticket key is encrypted with the project secret.
+*/
+func (c *TuyaClient) GetPasswordTicket(deviceId string) (*PasswordTicket, error) {
+	var body []byte
+	baseURL := c.GetBaseUrl(1.0)
+	endpointURL := fmt.Sprintf("%s/devices/%s/door-lock/password-ticket", baseURL, deviceId)
+	response, err := c.DoRequest(endpointURL, "POST", body)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody := new(PasswordTicketResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return nil, fmt.Errorf("success false for response")
+	}
+	return &respBody.Result, nil
+}
+
+// encryptLockPassword decrypts the ticket key with the project secret and uses
+// the plain key to encrypt the password as Tuya expects
+func (c *TuyaClient) encryptLockPassword(password string, ticket *PasswordTicket) (string, error) {
+	encryptedKey, err := hex.DecodeString(ticket.TicketKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid ticket key: %w", err)
+	}
+	c.signing.RLock()
+	secret := c.activeSecret(c.credentials())
+	c.signing.RUnlock()
+	ticketKey, err := AesEcbDecrypt(encryptedKey, []byte(secret))
+	if err != nil {
+		return "", fmt.Errorf("decrypt ticket key: %w", err)
+	}
+	encrypted, err := AesEcbEncrypt([]byte(password), ticketKey)
+	if err != nil {
+		return "", fmt.Errorf("encrypt password: %w", err)
+	}
+	return strings.ToUpper(hex.EncodeToString(encrypted)), nil
+}
+
+/*
+Create a temporary password for a door lock.
+A password ticket is requested and the plain password is encrypted with it before sending.
+*/
+func (c *TuyaClient) CreateTempPassword(deviceId string, params TempPasswordParams) (int64, error) {
+	baseURL := c.GetBaseUrl(1.0)
+	endpointURL := fmt.Sprintf("%s/devices/%s/door-lock/temp-password", baseURL, deviceId)
+
+	if params.Password == "" {
+		return 0, fmt.Errorf("password can not be empty")
+	}
+	ticket, err := c.GetPasswordTicket(deviceId)
+	if err != nil {
+		return 0, err
+	}
+	password, err := c.encryptLockPassword(params.Password, ticket)
+	if err != nil {
+		c.logger.Errorw("lock_password_encrypt_err", zap.String("error", err.Error()))
+		return 0, err
+	}
+
+	payload := map[string]interface{}{
+		"name":           params.Name,
+		"password":       password,
+		"password_type":  "ticket",
+		"ticket_id":      ticket.TicketId,
+		"effective_time": params.EffectiveTime,
+		"invalid_time":   params.InvalidTime,
+	}
+	if params.Phone != "" {
+		payload["phone"] = params.Phone
+	}
+	if params.TimeZone != "" {
+		payload["time_zone"] = params.TimeZone
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		c.logger.Errorw("json_encode_err", zap.String("error", err.Error()))
+		return 0, err
+	}
+	response, err := c.DoRequest(endpointURL, "POST", body)
+	if err != nil {
+		return 0, err
+	}
+
+	respBody := new(TempPasswordIdResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return 0, fmt.Errorf("success false for response")
+	}
+	return respBody.Result.Id, nil
+}
+
+/*
+Query the temporary passwords of a door lock
+*/
+func (c *TuyaClient) GetTempPasswords(deviceId string, valid bool) ([]TempPassword, error) {
+	var body []byte
+	baseURL := c.GetBaseUrl(1.0)
+	endpointURL := fmt.Sprintf("%s/devices/%s/door-lock/temp-passwords?valid=%t", baseURL, deviceId, valid)
+	response, err := c.DoRequest(endpointURL, "GET", body)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody := new(TempPasswordsResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return nil, fmt.Errorf("success false for response")
+	}
+	return respBody.Result, nil
+}
+
+/*
+Delete a temporary password of a door lock
+*/
+func (c *TuyaClient) DeleteTempPassword(deviceId string, passwordId int64) (bool, error) {
+	var body []byte
+	baseURL := c.GetBaseUrl(1.0)
+	endpointURL := fmt.Sprintf("%s/devices/%s/door-lock/temp-passwords/%d", baseURL, deviceId, passwordId)
+	response, err := c.DoRequest(endpointURL, "DELETE", body)
+	if err != nil {
+		return false, err
+	}
+
+	respBody := new(BooleanResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return false, fmt.Errorf("success false for response")
+	}
+	return respBody.Result, nil
+}
+
+/*
+Unlock a door lock remotely without a password.
+A password ticket is requested to authorize the operation.
+*/
+func (c *TuyaClient) RemoteUnlock(deviceId string) (bool, error) {
+	baseURL := c.GetBaseUrl(1.0)
+	endpointURL := fmt.Sprintf("%s/devices/%s/door-lock/password-free/open-door", baseURL, deviceId)
+
+	ticket, err := c.GetPasswordTicket(deviceId)
+	if err != nil {
+		return false, err
+	}
+	payload := map[string]string{
+		"ticket_id": ticket.TicketId,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		c.logger.Errorw("json_encode_err", zap.String("error", err.Error()))
+		return false, err
+	}
+	response, err := c.DoRequest(endpointURL, "POST", body)
+	if err != nil {
+		return false, err
+	}
+
+	respBody := new(BooleanResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return false, fmt.Errorf("success false for response")
+	}
+	return respBody.Result, nil
+}
+
+/*
+Query the unlock records of a door lock.
+startTime and endTime are millisecond timestamps, zero values are not sent.
+*/
+func (c *TuyaClient) GetUnlockRecords(deviceId string, pageNo, pageSize int, startTime, endTime int64) (*UnlockRecordsResult, error) {
+	var body []byte
+	baseURL := c.GetBaseUrl(1.0)
+	encodedParams := url.Values{}
+	encodedParams.Add("page_no", fmt.Sprint(pageNo))
+	encodedParams.Add("page_size", fmt.Sprint(pageSize))
+	if startTime > 0 {
+		encodedParams.Add("start_time", fmt.Sprint(startTime))
+	}
+	if endTime > 0 {
+		encodedParams.Add("end_time", fmt.Sprint(endTime))
+	}
+	endpointURL := fmt.Sprintf("%s/devices/%s/door-lock/open-logs?%s", baseURL, deviceId, encodedParams.Encode())
+	response, err := c.DoRequest(endpointURL, "GET", body)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody := new(UnlockRecordsResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return nil, fmt.Errorf("success false for response")
+	}
+	return &respBody.Result, nil
+}
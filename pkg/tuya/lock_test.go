@@ -0,0 +1,59 @@
+package tuya
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/pkg/logger"
+)
+
+func TestAesEcbKnownAnswer(t *testing.T) {
+	// FIPS-197 appendix C.1, followed by the block of PKCS7 padding
+	key, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	plain, _ := hex.DecodeString("00112233445566778899aabbccddeeff")
+	encrypted, err := AesEcbEncrypt(plain, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(encrypted[:16]); got != "69c4e0d86a7b0430d8cdb78070b4c55a" || len(encrypted) != 32 {
+		t.Fatalf("AesEcbEncrypt = %x", encrypted)
+	}
+	decrypted, err := AesEcbDecrypt(encrypted, key)
+	if err != nil || hex.EncodeToString(decrypted) != hex.EncodeToString(plain) {
+		t.Fatalf("AesEcbDecrypt = %x, %v", decrypted, err)
+	}
+}
+
+func TestEncryptLockPassword(t *testing.T) {
+	// computed with openssl: the ticket key fedcba9876543210 encrypted with
+	// aes-256-ecb and the secret, the password 123456 with aes-128-ecb and
+	// the ticket key
+	const (
+		secret    = "0123456789abcdef0123456789abcdef"
+		ticketKey = "de587b5fccf04eb47224e69a3a80b71c8aa36241fde8df054dc325c6c695b89e"
+		want      = "4239F36C3D29E53838530508DA1A94D1"
+	)
+	cfg := &config.Config{
+		Logger: config.Logger{Level: "fatal", Encoding: "console"},
+		Tuya:   config.Tuya{ClientId: "client", Secret: secret},
+	}
+	appLogger := logger.NewAppLogger(cfg)
+	appLogger.InitLogger()
+	client := NewTuyaClient(appLogger, cfg)
+
+	got, err := client.encryptLockPassword("123456", &PasswordTicket{TicketKey: ticketKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("encryptLockPassword = %s, want %s", got, want)
+	}
+
+	for _, ticket := range []string{"not hex", strings.Repeat("00", 16)} {
+		if _, err := client.encryptLockPassword("123456", &PasswordTicket{TicketKey: ticket}); err == nil {
+			t.Errorf("ticket key %q accepted", ticket)
+		}
+	}
+}
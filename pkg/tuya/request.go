@@ -6,17 +6,63 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"go.uber.org/zap"
 )
 
 func (c *TuyaClient) GetBaseUrl(version float32) string {
-	baseUrl := strings.TrimSuffix(c.cfg.Host, "/")
+	baseUrl := strings.TrimSuffix(c.credentials().Host, "/")
 	verStr := fmt.Sprintf("v%.1f", version)
 	return fmt.Sprintf("%s/%s", baseUrl, verStr)
 }
 
 func (c *TuyaClient) DoRequest(url, method string, body []byte) ([]byte, error) {
+	sent := time.Now()
+	resp, err := c.send(url, method, body, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	bs, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.logger.Errorw("decode_err",
+			zap.String("error", err.Error()))
+		return nil, err
+	}
+	c.observeResponse(bs, sent, time.Now())
+
+	return bs, nil
+}
+
+// Do signs and sends a request to a path of the Host such as
+// "/v1.0/devices?page_no=1", for endpoints the client doesn't wrap, opts
+// adding a nonce or signed headers. The caller closes the response body.
+func (c *TuyaClient) Do(method, path string, body []byte, opts ...SignOptions) (*http.Response, error) {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	sent := time.Now()
+	resp, err := c.send(strings.TrimSuffix(c.credentials().Host, "/")+path, method, body, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tuya answers small JSON documents, buffered to measure the clock skew
+	// from their t like the other requests
+	bs, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	c.observeResponse(bs, sent, time.Now())
+	resp.Body = io.NopCloser(bytes.NewReader(bs))
+	return resp, nil
+}
+
+// send waits for a running token refresh and sends the signed request
+func (c *TuyaClient) send(url, method string, body []byte, opts []SignOptions) (*http.Response, error) {
 
 	RefreshingWg.Wait()
 
@@ -33,26 +79,53 @@ func (c *TuyaClient) DoRequest(url, method string, body []byte) ([]byte, error)
 		zap.String("url", url),
 		zap.String("token", token.AccessToken))
 
-	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	resp, generation, err := c.sendSigned(url, method, body, opts)
 	if err != nil {
 		return nil, err
 	}
+	if c.credentials().SecondarySecret == "" && c.generation.Load() == generation {
+		return resp, nil
+	}
 
-	BuildRequestHeader(req, body, c.cfg.ClientId, c.cfg.Secret)
-	resp, err := http.DefaultClient.Do(req)
+	// while a secret is rotated, a request rejected for its signature is sent
+	// again once the other secret is active
+	bs, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
 	if err != nil {
-		c.logger.Errorw("request_err",
-			zap.String("error", err.Error()))
 		return nil, err
 	}
-	defer resp.Body.Close()
+	if signInvalid(bs) && c.failover(generation) {
+		c.logger.Warnw("request_retry",
+			zap.String("url", url),
+			zap.String("reason", "signature rejected, switched secret"))
+		resp, _, err = c.sendSigned(url, method, body, opts)
+		return resp, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(bs))
+	return resp, nil
+}
 
-	bs, err := io.ReadAll(resp.Body)
+// sendSigned signs the request with the active credentials and token and sends
+// it, along with the generation of the credentials it was signed with
+func (c *TuyaClient) sendSigned(url, method string, body []byte, opts []SignOptions) (*http.Response, uint64, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
 	if err != nil {
-		c.logger.Errorw("decode_err",
-			zap.String("error", err.Error()))
-		return nil, err
+		return nil, 0, err
 	}
 
-	return bs, nil
+	c.signing.RLock()
+	creds := c.credentials()
+	generation := c.generation.Load()
+	if creds.Nonce {
+		opts = append([]SignOptions{{Nonce: NewNonce()}}, opts...)
+	}
+	BuildRequestHeader(req, body, creds.ClientId, c.activeSecret(creds), opts...)
+	c.signing.RUnlock()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.logger.Errorw("request_err",
+			zap.String("error", err.Error()))
+		return nil, 0, err
+	}
+	return resp, generation, nil
 }
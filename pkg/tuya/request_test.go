@@ -0,0 +1,54 @@
+package tuya
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/pkg/logger"
+)
+
+func TestDoObservesClockSkew(t *testing.T) {
+	const skew = 90 * time.Second
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"success":true,"result":[],"t":%d}`, time.Now().Add(skew).UnixMilli())
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		Logger: config.Logger{Level: "fatal", Encoding: "console"},
+		Tuya:   config.Tuya{Host: server.URL, ClientId: testClientId, Secret: testSecret},
+	}
+	appLogger := logger.NewAppLogger(cfg)
+	appLogger.InitLogger()
+	client := NewTuyaClient(appLogger, cfg)
+
+	previous := client.GetActiveToken()
+	SetActiveToken(&TokenResponse{Result: Token{AccessToken: testAccessToken}})
+	defer func() {
+		SetActiveToken(&TokenResponse{Result: previous})
+		clockSkew.Store(0)
+	}()
+
+	resp, err := client.Do(http.MethodGet, "/v1.0/devices", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) == 0 || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("response passed on without its body or headers: %q", body)
+	}
+
+	if got := ClockSkew(); got < skew-5*time.Second || got > skew+5*time.Second {
+		t.Fatalf("ClockSkew = %s, want about %s", got, skew)
+	}
+}
@@ -62,3 +62,154 @@ type MODeviceNamesResponse struct {
 	BaseResponse
 	Result []MODeviceName `json:"result"`
 }
+
+type GroupId struct {
+	Id int64 `json:"id"`
+}
+
+type GroupIdResponse struct {
+	BaseResponse
+	Result GroupId `json:"result"`
+}
+
+type GroupResponse struct {
+	BaseResponse
+	Result Group `json:"result"`
+}
+
+type GroupsResult struct {
+	PageNo   int     `json:"page_no"`
+	PageSize int     `json:"page_size"`
+	Total    int64   `json:"total"`
+	Data     []Group `json:"data"`
+}
+
+type GroupsResponse struct {
+	BaseResponse
+	Result GroupsResult `json:"result"`
+}
+
+type GroupDevicesResult struct {
+	PageNo   int           `json:"page_no"`
+	PageSize int           `json:"page_size"`
+	Total    int64         `json:"total"`
+	Data     []GroupDevice `json:"data"`
+}
+
+type GroupDevicesResponse struct {
+	BaseResponse
+	Result GroupDevicesResult `json:"result"`
+}
+
+type GroupProperties struct {
+	Properties string `json:"properties"`
+}
+
+type GroupPropertiesResponse struct {
+	BaseResponse
+	Result GroupProperties `json:"result"`
+}
+
+type PasswordTicketResponse struct {
+	BaseResponse
+	Result PasswordTicket `json:"result"`
+}
+
+type TempPasswordId struct {
+	Id int64 `json:"id"`
+}
+
+type TempPasswordIdResponse struct {
+	BaseResponse
+	Result TempPasswordId `json:"result"`
+}
+
+type TempPasswordsResponse struct {
+	BaseResponse
+	Result []TempPassword `json:"result"`
+}
+
+type UnlockRecordsResult struct {
+	Total int64          `json:"total"`
+	Logs  []UnlockRecord `json:"logs"`
+}
+
+type UnlockRecordsResponse struct {
+	BaseResponse
+	Result UnlockRecordsResult `json:"result"`
+}
+
+type IrRemotesResponse struct {
+	BaseResponse
+	Result []IrRemote `json:"result"`
+}
+
+type IrRemoteKeysResponse struct {
+	BaseResponse
+	Result IrRemoteKeys `json:"result"`
+}
+
+type IrLearningCodeResponse struct {
+	BaseResponse
+	Result IrLearningCode `json:"result"`
+}
+
+type IrRemoteId struct {
+	RemoteId string `json:"remote_id"`
+}
+
+type IrRemoteIdResponse struct {
+	BaseResponse
+	Result IrRemoteId `json:"result"`
+}
+
+type FirmwareInfoResponse struct {
+	BaseResponse
+	Result []FirmwareInfo `json:"result"`
+}
+
+type StatisticTypesResponse struct {
+	BaseResponse
+	Result []StatisticType `json:"result"`
+}
+
+type StatisticsResponse struct {
+	BaseResponse
+	Result Statistics `json:"result"`
+}
+
+// ExpireTime is the number of seconds the Url stays valid, 0 when not returned
+type Stream struct {
+	Url        string `json:"url"`
+	ExpireTime int64  `json:"expire_time,omitempty"`
+}
+
+type StreamResponse struct {
+	BaseResponse
+	Result Stream `json:"result"`
+}
+
+type Snapshot struct {
+	Url        string `json:"url"`
+	ExpireTime int64  `json:"expire_time,omitempty"`
+}
+
+type SnapshotResponse struct {
+	BaseResponse
+	Result Snapshot `json:"result"`
+}
+
+type SpecificationResponse struct {
+	BaseResponse
+	Result Specification `json:"result"`
+}
+
+type DeviceStatusResponse struct {
+	BaseResponse
+	Result []DeviceStatus `json:"result"`
+}
+
+type DevicePropertiesResponse struct {
+	BaseResponse
+	Result DeviceProperties `json:"result"`
+}
@@ -0,0 +1,143 @@
+package tuya
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/varjangn/tuya-middleware/config"
+)
+
+const (
+	SecretPrimary   = "primary"
+	SecretSecondary = "secondary"
+
+	// Tuya error code of a request signed with a wrong secret
+	codeSignInvalid = "1004"
+)
+
+var ErrNoSecondarySecret = errors.New("no secondary secret configured")
+
+// CredentialsStatus reports the credentials in use, without the secrets
+type CredentialsStatus struct {
+	ClientId            string    `json:"client_id"`
+	Host                string    `json:"host"`
+	ActiveSecret        string    `json:"active_secret"`
+	SecondaryConfigured bool      `json:"secondary_configured"`
+	TokenExpiresAt      time.Time `json:"token_expires_at"`
+	ClockSkewMs         int64     `json:"clock_skew_ms"`
+}
+
+func secretName(secondary bool) string {
+	if secondary {
+		return SecretSecondary
+	}
+	return SecretPrimary
+}
+
+// tokenSecrets are the secrets tried for a token, primary first
+func tokenSecrets(creds *config.Tuya) []string {
+	if creds.SecondarySecret == "" {
+		return []string{creds.Secret}
+	}
+	return []string{creds.Secret, creds.SecondarySecret}
+}
+
+// activeSecret is the secret the active token was fetched with, to be called
+// with signing locked
+func (c *TuyaClient) activeSecret(creds *config.Tuya) string {
+	if c.secondaryActive.Load() {
+		return creds.SecondarySecret
+	}
+	return creds.Secret
+}
+
+// CredentialsStatus reports the client id and which secret is active
+func (c *TuyaClient) CredentialsStatus() CredentialsStatus {
+	c.signing.RLock()
+	defer c.signing.RUnlock()
+
+	creds := c.credentials()
+	return CredentialsStatus{
+		ClientId:            creds.ClientId,
+		Host:                creds.Host,
+		ActiveSecret:        secretName(c.secondaryActive.Load()),
+		SecondaryConfigured: creds.SecondarySecret != "",
+		TokenExpiresAt:      TokenExpiringAt(),
+		ClockSkewMs:         ClockSkew().Milliseconds(),
+	}
+}
+
+// Secrets returns the client id and its secrets, the active one first, for
+// the message service which encrypts with the project secret
+func (c *TuyaClient) Secrets() (string, []string) {
+	c.signing.RLock()
+	defer c.signing.RUnlock()
+
+	creds := c.credentials()
+	secrets := tokenSecrets(creds)
+	if c.secondaryActive.Load() && len(secrets) == 2 {
+		secrets[0], secrets[1] = secrets[1], secrets[0]
+	}
+	return creds.ClientId, secrets
+}
+
+// PromoteSecondary makes the secondary secret the primary one once the
+// rotation is done on the Tuya side, dropping the old primary. A token is
+// fetched with it before the switch, so requests keep going out meanwhile.
+func (c *TuyaClient) PromoteSecondary() error {
+	creds := *c.credentials()
+	if creds.SecondarySecret == "" {
+		return ErrNoSecondarySecret
+	}
+	creds.Secret, creds.SecondarySecret = creds.SecondarySecret, ""
+
+	ret, err := c.getToken(creds.Host+"/v1.0/token?grant_type=1", creds.ClientId, creds.Secret)
+	if err != nil {
+		return err
+	}
+	if ret.Result.AccessToken == "" {
+		return fmt.Errorf("the secondary secret is rejected: %s", ret.Msg)
+	}
+	c.storeToken(ret, &creds, false)
+	c.logger.Infof("promoted the secondary secret of client id %s", creds.ClientId)
+	return nil
+}
+
+// signInvalid tells whether a response body is Tuya rejecting the signature
+func signInvalid(body []byte) bool {
+	var resp struct {
+		Success bool        `json:"success"`
+		Code    interface{} `json:"code"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || resp.Success {
+		return false
+	}
+	return fmt.Sprint(resp.Code) == codeSignInvalid
+}
+
+// failover fetches a new token after a request signed with a generation of
+// the credentials was rejected for its signature, which happens when the
+// secret was rotated on the Tuya side. It tells whether other credentials are
+// now active and the request can be retried.
+func (c *TuyaClient) failover(generation uint64) bool {
+	c.failoverMu.Lock()
+	defer c.failoverMu.Unlock()
+
+	// switched since, by another rejected request or a promotion
+	if c.generation.Load() != generation {
+		return true
+	}
+
+	creds := c.credentials()
+	if creds.SecondarySecret == "" {
+		return false
+	}
+	ret, secondary, err := c.requestToken(creds)
+	if err != nil || ret.Result.AccessToken == "" || secondary == c.secondaryActive.Load() {
+		return false
+	}
+	c.storeToken(ret, nil, secondary)
+	return true
+}
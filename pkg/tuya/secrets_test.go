@@ -0,0 +1,151 @@
+package tuya_test
+
+import (
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/internal/fakecloud"
+	"github.com/varjangn/tuya-middleware/pkg/logger"
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+)
+
+const (
+	rotationClientId = "rotation"
+	oldSecret        = "old-secret"
+	newSecret        = "new-secret"
+)
+
+// rotationClient returns a client with the old secret as primary and the new
+// one as secondary, against a fake cloud verifying cloudSecret, with a
+// token fetched
+func rotationClient(t *testing.T, cloudSecret, secondary string) (*tuya.TuyaClient, *fakecloud.Server) {
+	t.Helper()
+	cloud := fakecloud.NewServer(rotationClientId)
+	cloud.SetSecret(cloudSecret)
+	server := httptest.NewServer(cloud)
+	t.Cleanup(server.Close)
+
+	cfg := &config.Config{
+		Logger: config.Logger{Level: "fatal", Encoding: "console"},
+		Tuya:   config.Tuya{Host: server.URL, ClientId: rotationClientId, Secret: oldSecret, SecondarySecret: secondary},
+	}
+	appLogger := logger.NewAppLogger(cfg)
+	appLogger.InitLogger()
+	client := tuya.NewTuyaClient(appLogger, cfg)
+	t.Cleanup(func() { tuya.SetActiveToken(&tuya.TokenResponse{}) })
+	if err := client.FetchToken(); err != nil {
+		t.Fatalf("FetchToken: %v", err)
+	}
+	return client, cloud
+}
+
+func activeSecret(t *testing.T, client *tuya.TuyaClient, want string) {
+	t.Helper()
+	if got := client.CredentialsStatus().ActiveSecret; got != want {
+		t.Fatalf("ActiveSecret = %s, want %s", got, want)
+	}
+}
+
+func TestTokenFallsBackToTheSecondarySecret(t *testing.T) {
+	client, _ := rotationClient(t, newSecret, newSecret)
+	activeSecret(t, client, tuya.SecretSecondary)
+	if client.GetActiveToken().AccessToken == "" {
+		t.Fatal("no token fetched with the secondary secret")
+	}
+	if _, err := client.GetDevices(1, 10, map[string]string{}); err != nil {
+		t.Fatalf("GetDevices: %v", err)
+	}
+
+	status := client.CredentialsStatus()
+	if status.ClientId != rotationClientId || !status.SecondaryConfigured || status.TokenExpiresAt.IsZero() {
+		t.Fatalf("CredentialsStatus = %+v", status)
+	}
+	if _, secrets := client.Secrets(); len(secrets) != 2 || secrets[0] != newSecret {
+		t.Fatalf("Secrets = %v, want the active secondary first", secrets)
+	}
+}
+
+func TestRequestRetriedWhenTheSecretFlips(t *testing.T) {
+	client, cloud := rotationClient(t, oldSecret, newSecret)
+	activeSecret(t, client, tuya.SecretPrimary)
+
+	cloud.SetSecret(newSecret)
+	if _, err := client.GetDevices(1, 10, map[string]string{}); err != nil {
+		t.Fatalf("GetDevices after the rotation: %v", err)
+	}
+	activeSecret(t, client, tuya.SecretSecondary)
+}
+
+func TestNoRequestFailsDuringTheSwitch(t *testing.T) {
+	client, cloud := rotationClient(t, oldSecret, newSecret)
+
+	const workers, requests = 8, 20
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*requests)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < requests; j++ {
+				if _, err := client.GetDevices(1, 10, map[string]string{}); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	cloud.SetSecret(newSecret)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("request failed during the switch: %v", err)
+	}
+	activeSecret(t, client, tuya.SecretSecondary)
+}
+
+func TestRotatedSecretWithoutSecondaryFails(t *testing.T) {
+	client, cloud := rotationClient(t, oldSecret, "")
+	cloud.SetSecret(newSecret)
+	if _, err := client.GetDevices(1, 10, map[string]string{}); err == nil {
+		t.Fatal("request signed with a rotated secret succeeded")
+	}
+	activeSecret(t, client, tuya.SecretPrimary)
+}
+
+func TestPromoteSecondary(t *testing.T) {
+	client, _ := rotationClient(t, newSecret, newSecret)
+	if err := client.PromoteSecondary(); err != nil {
+		t.Fatalf("PromoteSecondary: %v", err)
+	}
+
+	status := client.CredentialsStatus()
+	if status.ActiveSecret != tuya.SecretPrimary || status.SecondaryConfigured {
+		t.Fatalf("CredentialsStatus = %+v, want the new secret as the only primary", status)
+	}
+	if _, secrets := client.Secrets(); len(secrets) != 1 || secrets[0] != newSecret {
+		t.Fatalf("Secrets = %v, want [%s]", secrets, newSecret)
+	}
+	if _, err := client.GetDevices(1, 10, map[string]string{}); err != nil {
+		t.Fatalf("GetDevices after the promotion: %v", err)
+	}
+
+	if err := client.PromoteSecondary(); !errors.Is(err, tuya.ErrNoSecondarySecret) {
+		t.Fatalf("second PromoteSecondary = %v, want ErrNoSecondarySecret", err)
+	}
+}
+
+func TestPromoteRejectedSecondaryKeepsTheSecrets(t *testing.T) {
+	client, _ := rotationClient(t, oldSecret, newSecret)
+	if err := client.PromoteSecondary(); err == nil {
+		t.Fatal("promoted a secondary secret the cloud rejects")
+	}
+	status := client.CredentialsStatus()
+	if status.ActiveSecret != tuya.SecretPrimary || !status.SecondaryConfigured {
+		t.Fatalf("CredentialsStatus = %+v, want the secrets unchanged", status)
+	}
+	if _, err := client.GetDevices(1, 10, map[string]string{}); err != nil {
+		t.Fatalf("GetDevices: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package tuya
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"go.uber.org/zap"
+)
+
+// statistic granularities supported by GetStatistics
+const (
+	StatisticHour  = "hour"
+	StatisticDay   = "day"
+	StatisticMonth = "month"
+)
+
+// IsStatisticGranularity tells whether GetStatistics supports granularity
+func IsStatisticGranularity(granularity string) bool {
+	switch granularity {
+	case StatisticHour, StatisticDay, StatisticMonth:
+		return true
+	}
+	return false
+}
+
+/*
+Query the statistic types supported by a device, e.g. add_ele with stat_type sum
+*/
+func (c *TuyaClient) GetStatisticTypes(deviceId string) ([]StatisticType, error) {
+	var body []byte
+	baseURL := c.GetBaseUrl(1.0)
+	endpointURL := fmt.Sprintf("%s/devices/%s/all-statistic-type", baseURL, deviceId)
+	response, err := c.DoRequest(endpointURL, "GET", body)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody := new(StatisticTypesResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return nil, fmt.Errorf("success false for response")
+	}
+	return respBody.Result, nil
+}
+
+/*
+Query the statistics of a DP code for a time range.
+start and end are formatted per granularity: yyyyMMddHH for hours, yyyyMMdd for days and yyyyMM for months.
+The result maps each period to its value.
+*/
+func (c *TuyaClient) GetStatistics(deviceId, code, statType, granularity, start, end string) (map[string]string, error) {
+	var body []byte
+	baseURL := c.GetBaseUrl(1.0)
+
+	encodedParams := url.Values{}
+	encodedParams.Add("code", code)
+	if statType != "" {
+		encodedParams.Add("stat_type", statType)
+	}
+	switch granularity {
+	case StatisticHour:
+		encodedParams.Add("start_hour", start)
+		encodedParams.Add("end_hour", end)
+	case StatisticDay:
+		encodedParams.Add("start_day", start)
+		encodedParams.Add("end_day", end)
+	case StatisticMonth:
+		encodedParams.Add("start_month", start)
+		encodedParams.Add("end_month", end)
+	default:
+		return nil, fmt.Errorf("unknown statistic granularity %q", granularity)
+	}
+	endpointURL := fmt.Sprintf("%s/devices/%s/statistics/%ss?%s", baseURL, deviceId, granularity, encodedParams.Encode())
+	response, err := c.DoRequest(endpointURL, "GET", body)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody := new(StatisticsResponse)
+	err = json.Unmarshal(response, respBody)
+	if err != nil {
+		c.logger.Errorw("json_decode_err",
+			zap.String("error", err.Error()))
+	}
+	if !respBody.Success {
+		c.logger.Errorf("tuya_success_false")
+		return nil, fmt.Errorf("success false for response")
+	}
+
+	switch granularity {
+	case StatisticHour:
+		return respBody.Result.Hours, nil
+	case StatisticDay:
+		return respBody.Result.Days, nil
+	default:
+		return respBody.Result.Months, nil
+	}
+}
+
+/*
+Query the hourly statistics of a DP code, start and end are formatted as yyyyMMddHH
+*/
+func (c *TuyaClient) GetHourlyStatistics(deviceId, code, statType, startHour, endHour string) (map[string]string, error) {
+	return c.GetStatistics(deviceId, code, statType, StatisticHour, startHour, endHour)
+}
+
+/*
+Query the daily statistics of a DP code, start and end are formatted as yyyyMMdd
+*/
+func (c *TuyaClient) GetDailyStatistics(deviceId, code, statType, startDay, endDay string) (map[string]string, error) {
+	return c.GetStatistics(deviceId, code, statType, StatisticDay, startDay, endDay)
+}
+
+/*
+Query the monthly statistics of a DP code, start and end are formatted as yyyyMM
+*/
+func (c *TuyaClient) GetMonthlyStatistics(deviceId, code, statType, startMonth, endMonth string) (map[string]string, error) {
+	return c.GetStatistics(deviceId, code, statType, StatisticMonth, startMonth, endMonth)
+}
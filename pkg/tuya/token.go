@@ -3,6 +3,7 @@ package tuya
 import (
 	"bytes"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
@@ -12,8 +13,10 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/varjangn/tuya-middleware/config"
 	"go.uber.org/zap"
 )
 
@@ -25,40 +28,110 @@ type Token struct {
 }
 
 type TokenResponse struct {
-	Result  Token `json:"result"`
-	Success bool  `json:"success"`
-	T       int64 `json:"t"`
+	Result  Token  `json:"result"`
+	Success bool   `json:"success"`
+	Msg     string `json:"msg,omitempty"`
+	T       int64  `json:"t"`
+}
+
+// activeToken is the token requests are signed with and its expiry, swapped
+// as a whole as the reload and failover goroutines replace it
+type activeToken struct {
+	token     Token
+	expiresAt time.Time
 }
 
 var (
-	token           Token
-	TokenExpiringAt time.Time
-	RefreshingWg    sync.WaitGroup
+	active       atomic.Pointer[activeToken]
+	RefreshingWg sync.WaitGroup
 )
 
-func BuildRequestHeader(req *http.Request, body []byte, clientId, secret string) {
+func loadToken() *activeToken {
+	if t := active.Load(); t != nil {
+		return t
+	}
+	return &activeToken{}
+}
+
+// TokenExpiringAt is when the active token expires
+func TokenExpiringAt() time.Time {
+	return loadToken().expiresAt
+}
+
+// SignOptions are the optional parts of a signature
+type SignOptions struct {
+	// sent as the nonce header and signed when not empty, see NewNonce
+	Nonce string
+	// set on the request, signed and listed in Signature-Headers
+	Headers map[string]string
+}
+
+// NewNonce returns a random nonce for SignOptions
+func NewNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// BuildRequestHeader signs a business request with the active access token
+func BuildRequestHeader(req *http.Request, body []byte, clientId, secret string, opts ...SignOptions) {
+	signRequest(req, body, clientId, loadToken().token.AccessToken, secret, opts)
+}
+
+// buildTokenHeader signs the token requests, which carry no access token
+func buildTokenHeader(req *http.Request, body []byte, clientId, secret string, opts ...SignOptions) {
+	signRequest(req, body, clientId, "", secret, opts)
+}
+
+func signRequest(req *http.Request, body []byte, clientId, accessToken, secret string, opts []SignOptions) {
 	req.Header.Set("client_id", clientId)
 	req.Header.Set("sign_method", "HMAC-SHA256")
 
-	ts := fmt.Sprint(time.Now().UnixNano() / 1e6)
+	ts := fmt.Sprint(tuyaNow())
 	req.Header.Set("t", ts)
 
-	if token.AccessToken != "" {
-		req.Header.Set("access_token", token.AccessToken)
+	if accessToken != "" {
+		req.Header.Set("access_token", accessToken)
+	}
+
+	// the headers of every option are signed together, a later option wins
+	// for the nonce and for a header set twice
+	headers := map[string]string{}
+	for _, opt := range opts {
+		if opt.Nonce != "" {
+			req.Header.Set("nonce", opt.Nonce)
+		}
+		for key, value := range opt.Headers {
+			headers[key] = value
+		}
+	}
+	if len(headers) > 0 {
+		keys := make([]string, 0, len(headers))
+		for key, value := range headers {
+			keys = append(keys, key)
+			req.Header.Set(key, value)
+		}
+		sort.Strings(keys)
+		req.Header.Set("Signature-Headers", strings.Join(keys, ":"))
 	}
 
-	sign := buildSign(req, body, ts, clientId, secret)
-	req.Header.Set("sign", sign)
+	req.Header.Set("sign", Sign(req, body, secret))
 }
 
-func buildSign(req *http.Request, body []byte, t string, clientId string, secret string) string {
+// Sign computes the sign of a request from its client_id, access_token, t,
+// nonce and Signature-Headers headers, as Tuya verifies it. Token requests
+// have no access_token.
+func Sign(req *http.Request, body []byte, secret string) string {
+	signStr := req.Header.Get("client_id") + req.Header.Get("access_token") +
+		req.Header.Get("t") + req.Header.Get("nonce") + stringToSign(req, body)
+	return strings.ToUpper(HmacSha256(signStr, secret))
+}
+
+func stringToSign(req *http.Request, body []byte) string {
 	headers := getHeaderStr(req)
 	urlStr := getUrlStr(req)
 	contentSha256 := Sha256(body)
-	stringToSign := req.Method + "\n" + contentSha256 + "\n" + headers + "\n" + urlStr
-	signStr := clientId + token.AccessToken + t + stringToSign
-	sign := strings.ToUpper(HmacSha256(signStr, secret))
-	return sign
+	return req.Method + "\n" + contentSha256 + "\n" + headers + "\n" + urlStr
 }
 
 func Sha256(data []byte) string {
@@ -67,25 +140,26 @@ func Sha256(data []byte) string {
 	return hex.EncodeToString(sha256Contain.Sum(nil))
 }
 
+// getUrlStr is the path with the decoded query params sorted by key, every
+// value of a repeated key kept in order
 func getUrlStr(req *http.Request) string {
-	url := req.URL.Path
-	keys := make([]string, 0, 10)
-
 	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
 	for key := range query {
 		keys = append(keys, key)
 	}
-	if len(keys) > 0 {
-		url += "?"
-		sort.Strings(keys)
-		for _, keyName := range keys {
-			value := query.Get(keyName)
-			url += keyName + "=" + value + "&"
+	sort.Strings(keys)
+
+	params := make([]string, 0, len(keys))
+	for _, keyName := range keys {
+		for _, value := range query[keyName] {
+			params = append(params, keyName+"="+value)
 		}
 	}
 
-	if url[len(url)-1] == '&' {
-		url = url[:len(url)-1]
+	url := req.URL.Path
+	if len(params) > 0 {
+		url += "?" + strings.Join(params, "&")
 	}
 	return url
 }
@@ -112,16 +186,57 @@ func HmacSha256(message string, secret string) string {
 }
 
 func (c *TuyaClient) FetchToken() error {
+	ret, secondary, err := c.requestToken(c.credentials())
+	if err != nil {
+		return err
+	}
 
-	method := "GET"
+	if v := ret.Result.AccessToken; v != "" {
+		c.storeToken(ret, nil, secondary)
+		c.logger.Infow("token", zap.String("token", v), zap.String("secret", secretName(secondary)))
+	}
+
+	return nil
+}
+
+// requestToken gets a new token with the given credentials, leaving the
+// active token as is. The primary secret is tried first, then the secondary
+// one, secondary tells which one the token was signed with.
+func (c *TuyaClient) requestToken(creds *config.Tuya) (*TokenResponse, bool, error) {
+	return c.tokenWithFallback(creds, creds.Host+"/v1.0/token?grant_type=1")
+}
+
+// tokenWithFallback requests a token url with the primary secret, then the
+// secondary one when the primary is rejected
+func (c *TuyaClient) tokenWithFallback(creds *config.Tuya, tokenUrl string) (*TokenResponse, bool, error) {
+	secrets := tokenSecrets(creds)
+	for i, secret := range secrets {
+		ret, err := c.getToken(tokenUrl, creds.ClientId, secret)
+		if err != nil {
+			return nil, false, err
+		}
+		if ret.Result.AccessToken != "" || i == len(secrets)-1 {
+			return ret, i == 1, nil
+		}
+		c.logger.Warnf("token with the primary secret failed, trying the secondary one: %s", ret.Msg)
+	}
+	return nil, false, fmt.Errorf("no secret configured")
+}
+
+func (c *TuyaClient) getToken(tokenUrl, clientId, secret string) (*TokenResponse, error) {
 	body := []byte(``)
-	req, _ := http.NewRequest(method, c.cfg.Host+"/v1.0/token?grant_type=1", bytes.NewReader(body))
+	req, err := http.NewRequest("GET", tokenUrl, bytes.NewReader(body))
+	if err != nil {
+		c.logger.Infow("request err", zap.String("err", err.Error()))
+		return nil, err
+	}
 
-	BuildRequestHeader(req, body, c.cfg.ClientId, c.cfg.Secret)
+	buildTokenHeader(req, body, clientId, secret)
+	sent := time.Now()
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		c.logger.Infow("request err", zap.String("err", err.Error()))
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 	bs, _ := io.ReadAll(resp.Body)
@@ -129,75 +244,73 @@ func (c *TuyaClient) FetchToken() error {
 	err = json.Unmarshal(bs, &ret)
 	if err != nil {
 		c.logger.Infow("decode err", zap.String("err", err.Error()))
-		return err
+		return nil, err
 	}
+	c.observeServerTime(ret.T, sent, time.Now())
+	return &ret, nil
+}
 
-	if v := ret.Result.AccessToken; v != "" {
-		tkn := SetActiveToken(&ret)
-		expireTime := tkn.ExpireTime
-		// expireTime := 10
+// storeToken makes a token the active one, along with creds when switching
+// credentials and the secret it was signed with, once the requests being
+// signed are done
+func (c *TuyaClient) storeToken(tokenResp *TokenResponse, creds *config.Tuya, secondary bool) Token {
+	c.signing.Lock()
+	defer c.signing.Unlock()
 
-		TokenExpiringAt = time.Now().Local().Add(time.Second * time.Duration(expireTime))
-		c.logger.Infow("token", zap.String("token", v))
+	if creds != nil {
+		c.cfg.Store(creds)
+		c.generation.Add(1)
+	}
+	if c.secondaryActive.Swap(secondary) != secondary {
+		c.generation.Add(1)
+		c.logger.Warnf("now signing with the %s secret", secretName(secondary))
+	}
+	return SetActiveToken(tokenResp)
+}
+
+// UpdateCredentials switches to a new Host, ClientId and Secret. A token is
+// fetched with them first, requests keep going out with the old credentials
+// and token until it arrives, so none is failed by the switch. On error the
+// old credentials stay in use.
+func (c *TuyaClient) UpdateCredentials(creds config.Tuya) error {
+	ret, secondary, err := c.requestToken(&creds)
+	if err != nil {
+		return err
+	}
+	if ret.Result.AccessToken == "" {
+		return fmt.Errorf("no access token returned for client id %s: %s", creds.ClientId, ret.Msg)
 	}
 
+	c.storeToken(ret, &creds, secondary)
+	c.logger.Infof("switched to the credentials of client id %s, new expiry: %s", creds.ClientId, TokenExpiringAt())
 	return nil
 }
 
 func (c *TuyaClient) RefreshToken() error {
-	if token.RefreshToken == "" {
+	c.signing.RLock()
+	creds := c.credentials()
+	refreshToken := loadToken().token.RefreshToken
+	c.signing.RUnlock()
+
+	if refreshToken == "" {
 		return fmt.Errorf("initial token not found")
 	}
-	body := []byte(``)
 
-	tokenUrl := fmt.Sprintf("%s/v1.0/token/%s", c.cfg.Host, token.RefreshToken)
+	tokenUrl := fmt.Sprintf("%s/v1.0/token/%s", creds.Host, refreshToken)
 
 	c.logger.Infof("refresh token url: %s", tokenUrl)
 
-	req, err := http.NewRequest("GET", tokenUrl, bytes.NewReader(body))
-	if err != nil {
-		c.logger.Infow("request err", zap.String("err", err.Error()))
-		return err
-	}
-
-	req.Header.Set("client_id", c.cfg.ClientId)
-	req.Header.Set("sign_method", "HMAC-SHA256")
-
-	ts := fmt.Sprint(time.Now().UnixNano() / 1e6)
-	req.Header.Set("t", ts)
-	headers := getHeaderStr(req)
-	urlStr := getUrlStr(req)
-	contentSha256 := Sha256(body)
-	stringToSign := req.Method + "\n" + contentSha256 + "\n" + headers + "\n" + urlStr
-	signStr := c.cfg.ClientId + ts + "" + stringToSign
-	sign := strings.ToUpper(HmacSha256(signStr, c.cfg.Secret))
-	req.Header.Set("sign", sign)
-
-	resp, err := http.DefaultClient.Do(req)
+	tokenResponse, secondary, err := c.tokenWithFallback(creds, tokenUrl)
 	if err != nil {
 		c.logger.Infow("request err", zap.String("err", err.Error()))
 		return err
 	}
-	defer resp.Body.Close()
-	bs, _ := io.ReadAll(resp.Body)
-
-	tokenResponse := new(TokenResponse)
-	err = json.Unmarshal(bs, tokenResponse)
-	if err != nil {
-		c.logger.Infow("decode err", zap.String("err", err.Error()))
-		return err
-	}
 
 	if v := tokenResponse.Result.AccessToken; v != "" {
-		tkn := SetActiveToken(tokenResponse)
-
-		expireTime := tkn.ExpireTime
-		// expireTime := 10
-
-		TokenExpiringAt = time.Now().Local().Add(time.Second * time.Duration(expireTime))
+		tkn := c.storeToken(tokenResponse, nil, secondary)
 
 		c.logger.Infof("new access token: %s", tkn.AccessToken)
-		c.logger.Infof("new expiry: %s", TokenExpiringAt)
+		c.logger.Infof("new expiry: %s", TokenExpiringAt())
 		return nil
 	}
 
@@ -205,18 +318,25 @@ func (c *TuyaClient) RefreshToken() error {
 }
 
 func (c *TuyaClient) GetActiveToken() Token {
-	return token
+	return loadToken().token
 }
 
+// SetActiveToken makes a token the one requests are signed with, expiring
+// after its ExpireTime
 func SetActiveToken(tokenResp *TokenResponse) Token {
-	token = tokenResp.Result
-	return token
+	tkn := tokenResp.Result
+	active.Store(&activeToken{
+		token:     tkn,
+		expiresAt: time.Now().Local().Add(time.Second * time.Duration(tkn.ExpireTime)),
+	})
+	return tkn
 }
 
 func (c *TuyaClient) AutoRefreshToken() error {
 	for {
 		RefreshingWg.Add(1)
 
+		token := c.GetActiveToken()
 		if token.AccessToken == "" {
 			c.logger.Info("generating first token")
 			if err := c.FetchToken(); err != nil {
@@ -235,7 +355,7 @@ func (c *TuyaClient) AutoRefreshToken() error {
 		}
 
 		currentTime := time.Now().Local()
-		diff := TokenExpiringAt.Sub(currentTime)
+		diff := TokenExpiringAt().Sub(currentTime)
 		diff = diff - (time.Duration(120) * time.Second)
 		c.logger.Infof("sleeping for %f hours", diff.Hours())
 		RefreshingWg.Done()
@@ -0,0 +1,141 @@
+package tuya
+
+import (
+	"net/http"
+	"testing"
+)
+
+// the vectors of Tuya's signature documentation
+const (
+	testClientId    = "1KAD46OrT9HafiKdsXeg"
+	testSecret      = "4OHBOnWOqaEC1mWXOpVL3yV50s0qGSRC"
+	testT           = "1588925778000"
+	testNonce       = "5138cc3a9033d69856923fd07b491173"
+	testAccessToken = "3f4eda2bdec17232f67c0b188af3eec1"
+)
+
+func newSignedRequest(t *testing.T, url, accessToken string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("client_id", testClientId)
+	req.Header.Set("t", testT)
+	req.Header.Set("nonce", testNonce)
+	if accessToken != "" {
+		req.Header.Set("access_token", accessToken)
+	}
+	req.Header.Set("area_id", "29a33e8796834b1efa6")
+	req.Header.Set("call_id", "8afdb70ab2ed11eb85290242ac130003")
+	req.Header.Set("Signature-Headers", "area_id:call_id")
+	return req
+}
+
+func TestSignToken(t *testing.T) {
+	req := newSignedRequest(t, "https://openapi.tuyacn.com/v1.0/token?grant_type=1", "")
+	want := "9E48A3E93B302EEECC803C7241985D0A34EB944F40FB573C7B5C2A82158AF13E"
+	if got := Sign(req, nil, testSecret); got != want {
+		t.Fatalf("Sign = %s, want %s", got, want)
+	}
+}
+
+func TestSignBusiness(t *testing.T) {
+	req := newSignedRequest(t, "https://openapi.tuyacn.com/v2.0/apps/schema/users?page_no=1&page_size=50", testAccessToken)
+	want := "AE4481C692AA80B25F3A7E12C3A5FD9BBF6251539DD78E565A1A72A508A88784"
+	if got := Sign(req, nil, testSecret); got != want {
+		t.Fatalf("Sign = %s, want %s", got, want)
+	}
+}
+
+func TestStringToSign(t *testing.T) {
+	req := newSignedRequest(t, "https://openapi.tuyacn.com/v1.0/token?grant_type=1", "")
+	want := "GET\n" +
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855\n" +
+		"area_id:29a33e8796834b1efa6\n" +
+		"call_id:8afdb70ab2ed11eb85290242ac130003\n" +
+		"\n" +
+		"/v1.0/token?grant_type=1"
+	if got := stringToSign(req, nil); got != want {
+		t.Fatalf("stringToSign = %q, want %q", got, want)
+	}
+}
+
+func TestGetUrlStr(t *testing.T) {
+	for _, tt := range []struct {
+		url  string
+		want string
+	}{
+		{"https://host/v1.0/devices", "/v1.0/devices"},
+		{"https://host/v1.0/devices?page_size=20&last_row_key=a", "/v1.0/devices?last_row_key=a&page_size=20"},
+		// repeated keys keep the order of their values, values are decoded
+		{
+			"https://host/v1.0/devices?device_ids=b&a=x%20y&device_ids=a&c=%2B1&e",
+			"/v1.0/devices?a=x y&c=+1&device_ids=b&device_ids=a&e=",
+		},
+	} {
+		req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := getUrlStr(req); got != tt.want {
+			t.Errorf("getUrlStr(%s) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestSignRequestOptions(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "https://host/v1.0/devices?page_size=20", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buildTokenHeader(req, nil, testClientId, testSecret,
+		SignOptions{Nonce: testNonce, Headers: map[string]string{"call_id": "1"}},
+		SignOptions{Headers: map[string]string{"area_id": "2"}},
+	)
+
+	for key, want := range map[string]string{
+		"client_id":         testClientId,
+		"sign_method":       "HMAC-SHA256",
+		"nonce":             testNonce,
+		"area_id":           "2",
+		"call_id":           "1",
+		"Signature-Headers": "area_id:call_id",
+	} {
+		if got := req.Header.Get(key); got != want {
+			t.Errorf("header %s = %q, want %q", key, got, want)
+		}
+	}
+	if req.Header.Get("access_token") != "" {
+		t.Error("token request signed with an access token")
+	}
+	if got, want := req.Header.Get("sign"), Sign(req, nil, testSecret); got != want {
+		t.Errorf("sign = %s, want %s", got, want)
+	}
+}
+
+func TestSignRequestWithoutOptions(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "https://host/v1.0/devices", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buildTokenHeader(req, nil, testClientId, testSecret)
+	for _, key := range []string{"nonce", "Signature-Headers"} {
+		if req.Header.Get(key) != "" {
+			t.Errorf("header %s set without options", key)
+		}
+	}
+	if req.Header.Get("t") == "" || req.Header.Get("sign") == "" {
+		t.Error("t or sign missing")
+	}
+}
+
+func TestNewNonce(t *testing.T) {
+	nonce := NewNonce()
+	if len(nonce) != 32 {
+		t.Fatalf("nonce %q is not 32 hex characters", nonce)
+	}
+	if nonce == NewNonce() {
+		t.Fatal("nonces repeat")
+	}
+}
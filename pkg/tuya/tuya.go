@@ -1,15 +1,35 @@
 package tuya
 
 import (
+	"sync"
+	"sync/atomic"
+
 	"github.com/varjangn/tuya-middleware/config"
 	"github.com/varjangn/tuya-middleware/pkg/logger"
 )
 
 type TuyaClient struct {
 	logger *logger.AppLogger
-	cfg    *config.Tuya
+	cfg    atomic.Pointer[config.Tuya]
+	// the active token was fetched with the secondary secret
+	secondaryActive atomic.Bool
+	// incremented when the credentials or the active secret change
+	generation atomic.Uint64
+	// read locked while a request is signed, so the credentials and the
+	// token are switched together
+	signing sync.RWMutex
+	// a single token fetch after requests rejected for their signature
+	failoverMu sync.Mutex
 }
 
 func NewTuyaClient(logger *logger.AppLogger, cfg *config.Config) *TuyaClient {
-	return &TuyaClient{logger: logger, cfg: &cfg.Tuya}
+	c := &TuyaClient{logger: logger}
+	tuyaCfg := cfg.Tuya
+	c.cfg.Store(&tuyaCfg)
+	return c
+}
+
+// credentials returns the Host, ClientId and Secret in use
+func (c *TuyaClient) credentials() *config.Tuya {
+	return c.cfg.Load()
 }
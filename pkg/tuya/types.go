@@ -3,9 +3,15 @@ package tuya
 type TuyaTimestamp int64
 
 type DeviceStatus struct {
-	Code  string `json:"code"`
-	Value string `json:"value"`
-	Type  string `json:"type"`
+	Code  string      `json:"code"`
+	Value interface{} `json:"value"`
+	Type  string      `json:"type"`
+}
+
+// command sent to a device data point
+type Command struct {
+	Code  string      `json:"code"`
+	Value interface{} `json:"value"`
 }
 
 type Device struct {
@@ -45,10 +51,11 @@ type FactoryInfo struct {
 	Id   string `json:"id"`
 	UUID string `json:"uuid"`
 	Sn   string `json:"sn"`
-	Mac  string `json:"mav"`
+	Mac  string `json:"mac"`
 }
 
 type DeviceUser struct {
+	UserId   string        `json:"user_id"`
 	DeviceId string        `json:"device_id"`
 	NickName string        `json:"nick_name"`
 	Sex      int           `json:"sex"`
@@ -63,3 +70,160 @@ type MODeviceName struct {
 	Identifier string `json:"identifier"`
 	Name       string `json:"name"`
 }
+
+type Group struct {
+	Id         int64         `json:"id"`
+	Name       string        `json:"name"`
+	SpaceId    string        `json:"space_id"`
+	ProductId  string        `json:"product_id"`
+	Icon       string        `json:"icon,omitempty"`
+	CreateTime TuyaTimestamp `json:"create_time,omitempty"`
+	UpdateTime TuyaTimestamp `json:"update_time,omitempty"`
+}
+
+type GroupDevice struct {
+	DeviceId string `json:"device_id"`
+	Online   bool   `json:"online"`
+}
+
+type PasswordTicket struct {
+	TicketId   string `json:"ticket_id"`
+	TicketKey  string `json:"ticket_key"`
+	ExpireTime int64  `json:"expire_time"`
+}
+
+// parameters for creating a door lock temporary password,
+// Password is the plain password and is encrypted before it is sent.
+// EffectiveTime and InvalidTime are timestamps in seconds.
+type TempPasswordParams struct {
+	Name          string `json:"name"`
+	Password      string `json:"password"`
+	EffectiveTime int64  `json:"effective_time"`
+	InvalidTime   int64  `json:"invalid_time"`
+	Phone         string `json:"phone,omitempty"`
+	TimeZone      string `json:"time_zone,omitempty"`
+}
+
+type TempPassword struct {
+	Id            int64  `json:"id"`
+	Name          string `json:"name"`
+	Phone         string `json:"phone,omitempty"`
+	EffectiveTime int64  `json:"effective_time"`
+	InvalidTime   int64  `json:"invalid_time"`
+	Phase         int    `json:"phase"`
+	TimeZone      string `json:"time_zone,omitempty"`
+}
+
+type UnlockRecordStatus struct {
+	Code  string      `json:"code"`
+	Value interface{} `json:"value"`
+}
+
+type UnlockRecord struct {
+	Status     UnlockRecordStatus `json:"status"`
+	NickName   string             `json:"nick_name,omitempty"`
+	UpdateTime TuyaTimestamp      `json:"update_time"`
+}
+
+// remote bound to an IR hub
+type IrRemote struct {
+	RemoteId    string `json:"remote_id"`
+	RemoteName  string `json:"remote_name"`
+	CategoryId  int    `json:"category_id"`
+	BrandId     int    `json:"brand_id"`
+	BrandName   string `json:"brand_name,omitempty"`
+	RemoteIndex int    `json:"remote_index"`
+}
+
+type IrKey struct {
+	Key         string `json:"key"`
+	KeyId       int    `json:"key_id"`
+	KeyName     string `json:"key_name"`
+	StandardKey bool   `json:"standard_key"`
+}
+
+type IrRemoteKeys struct {
+	CategoryId     int     `json:"category_id"`
+	BrandId        int     `json:"brand_id"`
+	RemoteIndex    int     `json:"remote_index"`
+	SingleAir      bool    `json:"single_air"`
+	DuplicatePower bool    `json:"duplicate_power"`
+	KeyList        []IrKey `json:"key_list"`
+}
+
+// air conditioner state, Power is 0 (off) or 1 (on),
+// Mode, Temp and Wind use the values from the remote's key list
+type AcState struct {
+	Power int `json:"power"`
+	Mode  int `json:"mode"`
+	Temp  int `json:"temp"`
+	Wind  int `json:"wind"`
+}
+
+type IrLearningCode struct {
+	Success bool   `json:"success"`
+	Code    string `json:"code"`
+}
+
+type IrLearnedKey struct {
+	Code    string `json:"code"`
+	KeyName string `json:"key_name"`
+}
+
+// firmware upgrade status of a device module
+const (
+	FirmwareUpToDate         = 0
+	FirmwareUpgradeAvailable = 1
+	FirmwareUpgrading        = 2
+)
+
+type FirmwareInfo struct {
+	Type            int           `json:"type"`
+	TypeDesc        string        `json:"type_desc"`
+	CurrentVersion  string        `json:"current_version"`
+	Version         string        `json:"version"`
+	UpgradeStatus   int           `json:"upgrade_status"`
+	Desc            string        `json:"desc,omitempty"`
+	LastUpgradeTime TuyaTimestamp `json:"last_upgrade_time,omitempty"`
+	Timeout         int           `json:"timeout,omitempty"`
+}
+
+type StatisticType struct {
+	Code     string `json:"code"`
+	StatType string `json:"stat_type"`
+}
+
+type Statistics struct {
+	Hours  map[string]string `json:"hours,omitempty"`
+	Days   map[string]string `json:"days,omitempty"`
+	Months map[string]string `json:"months,omitempty"`
+}
+
+// data point of a device specification, Values is a JSON string such as
+// {"min":10,"max":1000,"scale":0,"step":1,"unit":""} or {"range":["white","colour"]}
+type SpecificationItem struct {
+	Code   string `json:"code"`
+	Type   string `json:"type"`
+	Values string `json:"values"`
+}
+
+type Specification struct {
+	Category  string              `json:"category"`
+	Functions []SpecificationItem `json:"functions"`
+	Status    []SpecificationItem `json:"status"`
+}
+
+// reported property of a device shadow, DpId is the data point id used by
+// the local protocol
+type DeviceProperty struct {
+	Code       string      `json:"code"`
+	CustomName string      `json:"custom_name"`
+	DpId       int         `json:"dp_id"`
+	Time       int64       `json:"time"`
+	Type       string      `json:"type"`
+	Value      interface{} `json:"value"`
+}
+
+type DeviceProperties struct {
+	Properties []DeviceProperty `json:"properties"`
+}
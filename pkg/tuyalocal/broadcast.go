@@ -0,0 +1,115 @@
+package tuyalocal
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+)
+
+// CmdUdpNew is the command of the discovery broadcasts
+const CmdUdpNew uint32 = 19
+
+// devices broadcast on 6666 in plaintext (3.1), on 6667 encrypted with the
+// well known key and on 7000 in 6699 frames (3.5)
+var DiscoveryPorts = []int{6666, 6667, 7000}
+
+// udpKey is the md5 of the key shared by every device for broadcasts
+var udpKey = func() []byte {
+	sum := md5.Sum([]byte("yGAdlopoPVldABfn"))
+	return sum[:]
+}()
+
+// Broadcast is the payload of a discovery packet
+type Broadcast struct {
+	Ip         string `json:"ip"`
+	GwId       string `json:"gwId"`
+	Active     int    `json:"active"`
+	Ability    int    `json:"ability"`
+	Mode       int    `json:"mode"`
+	Encrypt    bool   `json:"encrypt"`
+	ProductKey string `json:"productKey"`
+	Version    string `json:"version"`
+}
+
+// BroadcastPort is the port a device of the version broadcasts on
+func BroadcastPort(version string) int {
+	switch version {
+	case Version31:
+		return 6666
+	case Version35:
+		return 7000
+	default:
+		return 6667
+	}
+}
+
+// DecodeBroadcast decodes a discovery packet of any version
+func DecodeBroadcast(packet []byte) (*Broadcast, error) {
+	if len(packet) < 4 {
+		return nil, ErrInvalidFrame
+	}
+	var msg Message
+	var err error
+	switch binary.BigEndian.Uint32(packet[:4]) {
+	case prefix55AA:
+		msg, err = unpack55AA(packet, nil, false)
+	case prefix6699:
+		msg, err = unpack6699(packet, udpKey, false)
+	default:
+		return nil, fmt.Errorf("%w: unknown prefix", ErrInvalidFrame)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	payload := msg.Payload
+	// a return code precedes the payload on most firmwares
+	if len(payload) >= 4 && payload[0] == 0 && payload[1] == 0 && payload[2] == 0 {
+		payload = payload[4:]
+	}
+	if len(payload) > 0 && payload[0] != '{' {
+		if payload, err = tuya.AesEcbDecrypt(payload, udpKey); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFrame, err)
+		}
+	}
+
+	broadcast := &Broadcast{}
+	if err := json.Unmarshal(payload, broadcast); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFrame, err)
+	}
+	if broadcast.GwId == "" {
+		return nil, fmt.Errorf("%w: missing gwId", ErrInvalidFrame)
+	}
+	return broadcast, nil
+}
+
+// EncodeBroadcast builds the discovery packet a device of broadcast.Version sends
+func EncodeBroadcast(broadcast Broadcast) ([]byte, error) {
+	payload, err := json.Marshal(broadcast)
+	if err != nil {
+		return nil, err
+	}
+	msg := Message{Cmd: CmdUdpNew, HasRetCode: true}
+
+	switch broadcast.Version {
+	case Version31:
+		msg.Payload = payload
+		return pack55AA(msg, nil), nil
+	case Version35:
+		msg.Payload = payload
+		nonce := make([]byte, gcmNonceSize)
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		return pack6699(msg, udpKey, nonce)
+	default:
+		if msg.Payload, err = tuya.AesEcbEncrypt(payload, udpKey); err != nil {
+			return nil, err
+		}
+		return pack55AA(msg, nil), nil
+	}
+}
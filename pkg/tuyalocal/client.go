@@ -0,0 +1,284 @@
+package tuyalocal
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultPort    = "6668"
+	defaultTimeout = 5 * time.Second
+)
+
+var ErrClosed = errors.New("connection closed")
+
+// DeviceConfig describes how to reach a device on the LAN, the local key and
+// ip are the ones returned by GetDevice
+type DeviceConfig struct {
+	Id       string
+	Address  string // ip, or ip:port when the device doesn't listen on 6668
+	LocalKey string
+	Version  string
+	Timeout  time.Duration
+}
+
+// Client is a connection to one device. Requests are answered in the order
+// they are sent, STATUS frames pushed by the device go to OnStatus.
+type Client struct {
+	cfg     DeviceConfig
+	conn    net.Conn
+	codec   *codec
+	timeout time.Duration
+
+	// OnStatus receives the DPs reported by the device outside of a request,
+	// it must be set before the first request
+	OnStatus func(dps map[string]interface{})
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	seq     uint32
+	pending map[uint32]chan Message
+	err     error
+	done    chan struct{}
+}
+
+// Dial connects to the device and negotiates the session key for 3.4 and 3.5
+func Dial(ctx context.Context, cfg DeviceConfig) (*Client, error) {
+	address := cfg.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, DefaultPort)
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewClient(conn, cfg)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// NewClient speaks the protocol over an established connection, e.g. one
+// end of a net.Pipe served by a SimulatedDevice
+func NewClient(conn net.Conn, cfg DeviceConfig) (*Client, error) {
+	codec, err := newCodec(cfg.Version, cfg.LocalKey, false)
+	if err != nil {
+		return nil, err
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	c := &Client{
+		cfg:     cfg,
+		conn:    conn,
+		codec:   codec,
+		timeout: timeout,
+		pending: map[uint32]chan Message{},
+		done:    make(chan struct{}),
+	}
+	if codec.negotiated() {
+		if err := c.negotiate(); err != nil {
+			return nil, fmt.Errorf("session key negotiation: %w", err)
+		}
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+// negotiate exchanges nonces with the device, both sides prove they know the
+// local key with an HMAC of the other's nonce and derive the session key
+func (c *Client) negotiate() error {
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	localNonce := make([]byte, 16)
+	if _, err := rand.Read(localNonce); err != nil {
+		return err
+	}
+	if err := c.write(c.nextSeq(), CmdSessKeyNegStart, localNonce); err != nil {
+		return err
+	}
+
+	frame, err := readFrame(c.conn)
+	if err != nil {
+		return err
+	}
+	msg, err := c.codec.decode(frame)
+	if err != nil {
+		return err
+	}
+	if msg.Cmd != CmdSessKeyNegResp || len(msg.Payload) < 48 {
+		return fmt.Errorf("unexpected response, command %d", msg.Cmd)
+	}
+	remoteNonce := msg.Payload[:16]
+	if !bytes.Equal(msg.Payload[16:48], hmacSha256(c.codec.localKey, localNonce)) {
+		return fmt.Errorf("device failed to prove the local key")
+	}
+
+	if err := c.write(c.nextSeq(), CmdSessKeyNegFinish, hmacSha256(c.codec.localKey, remoteNonce)); err != nil {
+		return err
+	}
+	key, err := sessionKey(c.codec.version, c.codec.localKey, localNonce, remoteNonce)
+	if err != nil {
+		return err
+	}
+	c.codec.sessionKey = key
+	return nil
+}
+
+func (c *Client) nextSeq() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	return c.seq
+}
+
+func (c *Client) write(seq, cmd uint32, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	frame, err := c.codec.encode(seq, cmd, payload)
+	if err != nil {
+		return err
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	_, err = c.conn.Write(frame)
+	return err
+}
+
+func (c *Client) readLoop() {
+	for {
+		frame, err := readFrame(c.conn)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		msg, err := c.codec.decode(frame)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		if msg.Cmd != CmdStatus && msg.Cmd != CmdUpdateDps {
+			c.mu.Lock()
+			ch, ok := c.pending[msg.Seq]
+			delete(c.pending, msg.Seq)
+			c.mu.Unlock()
+			if ok {
+				ch <- msg
+				continue
+			}
+		}
+		if msg.Cmd == CmdStatus && c.OnStatus != nil && len(msg.Payload) > 0 {
+			if dps, err := parseDps(msg.Payload); err == nil {
+				c.OnStatus(dps)
+			}
+		}
+	}
+}
+
+// fail closes the connection and fails the pending requests
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+	c.conn.Close()
+}
+
+// request sends a command and waits for the frame answering it
+func (c *Client) request(cmd uint32, payload []byte) (Message, error) {
+	seq := c.nextSeq()
+	ch := make(chan Message, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return Message{}, ErrClosed
+	}
+	c.pending[seq] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, seq)
+		c.mu.Unlock()
+	}()
+
+	if err := c.write(seq, cmd, payload); err != nil {
+		c.fail(err)
+		return Message{}, err
+	}
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	select {
+	case msg := <-ch:
+		if msg.HasRetCode && msg.RetCode != 0 {
+			return msg, fmt.Errorf("device returned code %d: %s", msg.RetCode, msg.Payload)
+		}
+		return msg, nil
+	case <-timer.C:
+		return Message{}, fmt.Errorf("timed out waiting for command %d", cmd)
+	case <-c.done:
+		return Message{}, ErrClosed
+	}
+}
+
+// Status queries the current DPs, keyed by DP id
+func (c *Client) Status() (map[string]interface{}, error) {
+	payload, err := c.codec.queryPayload(c.cfg.Id)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := c.request(c.codec.queryCmd(), payload)
+	if err != nil {
+		return nil, err
+	}
+	return parseDps(msg.Payload)
+}
+
+// SetDPs sets DPs by id, the new values are then reported with a STATUS frame
+func (c *Client) SetDPs(dps map[string]interface{}) error {
+	payload, err := c.codec.controlPayload(c.cfg.Id, dps)
+	if err != nil {
+		return err
+	}
+	_, err = c.request(c.codec.controlCmd(), payload)
+	return err
+}
+
+func (c *Client) SetDP(dp string, value interface{}) error {
+	return c.SetDPs(map[string]interface{}{dp: value})
+}
+
+// Heartbeat keeps the connection open, devices drop idle connections after
+// about 30 seconds
+func (c *Client) Heartbeat() error {
+	payload, err := c.codec.heartbeatPayload(c.cfg.Id)
+	if err != nil {
+		return err
+	}
+	_, err = c.request(CmdHeartBeat, payload)
+	return err
+}
+
+// Done is closed when the connection is closed, devices close idle connections
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Client) Close() error {
+	c.fail(ErrClosed)
+	return nil
+}
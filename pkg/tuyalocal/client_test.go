@@ -0,0 +1,132 @@
+package tuyalocal
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+const (
+	testDeviceId = "bf1234567890abcdef"
+	testLocalKey = "0123456789abcdef"
+)
+
+var versions = []string{Version31, Version33, Version34, Version35}
+
+// pipeClient connects a client to a simulated device over net.Pipe
+func pipeClient(t *testing.T, device *SimulatedDevice, localKey string) (*Client, error) {
+	t.Helper()
+	clientConn, deviceConn := net.Pipe()
+	go device.ServeConn(deviceConn)
+	client, err := NewClient(clientConn, DeviceConfig{
+		Id:       device.Id,
+		LocalKey: localKey,
+		Version:  device.Version,
+		Timeout:  time.Second,
+	})
+	if err != nil {
+		clientConn.Close()
+		return nil, err
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, nil
+}
+
+func TestClientRoundTrip(t *testing.T) {
+	for _, version := range versions {
+		t.Run(version, func(t *testing.T) {
+			device := NewSimulatedDevice(testDeviceId, testLocalKey, version, map[string]interface{}{
+				"1": false,
+				"2": float64(25),
+			})
+			client, err := pipeClient(t, device, testLocalKey)
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+			pushed := make(chan map[string]interface{}, 1)
+			client.OnStatus = func(dps map[string]interface{}) { pushed <- dps }
+
+			dps, err := client.Status()
+			if err != nil {
+				t.Fatalf("Status: %v", err)
+			}
+			if dps["1"] != false || dps["2"] != float64(25) {
+				t.Fatalf("Status = %v", dps)
+			}
+
+			if err := client.SetDP("1", true); err != nil {
+				t.Fatalf("SetDP: %v", err)
+			}
+			select {
+			case dps := <-pushed:
+				if dps["1"] != true {
+					t.Fatalf("pushed status = %v", dps)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no status pushed after SetDP")
+			}
+			if dps := device.DPs(); dps["1"] != true {
+				t.Fatalf("device DPs = %v", dps)
+			}
+
+			if err := client.Heartbeat(); err != nil {
+				t.Fatalf("Heartbeat: %v", err)
+			}
+		})
+	}
+}
+
+func TestClientPushedStatus(t *testing.T) {
+	for _, version := range versions {
+		t.Run(version, func(t *testing.T) {
+			device := NewSimulatedDevice(testDeviceId, testLocalKey, version, map[string]interface{}{"1": false})
+			client, err := pipeClient(t, device, testLocalKey)
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+			pushed := make(chan map[string]interface{}, 1)
+			client.OnStatus = func(dps map[string]interface{}) { pushed <- dps }
+			// a request makes sure the 3.4/3.5 device finished the negotiation
+			if _, err := client.Status(); err != nil {
+				t.Fatalf("Status: %v", err)
+			}
+
+			go device.SetDPs(map[string]interface{}{"1": true})
+			select {
+			case dps := <-pushed:
+				if dps["1"] != true {
+					t.Fatalf("pushed status = %v", dps)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no status pushed")
+			}
+		})
+	}
+}
+
+func TestClientWrongLocalKey(t *testing.T) {
+	for _, version := range versions {
+		t.Run(version, func(t *testing.T) {
+			device := NewSimulatedDevice(testDeviceId, testLocalKey, version, map[string]interface{}{"1": false})
+			client, err := pipeClient(t, device, "fedcba9876543210")
+			if version == Version34 || version == Version35 {
+				// the device's proof of the local key doesn't verify
+				if err == nil {
+					t.Fatal("negotiation succeeded with the wrong local key")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+			// the device drops the connection on a payload it can't decrypt
+			if err := client.SetDP("1", true); !errors.Is(err, ErrClosed) {
+				t.Fatalf("SetDP = %v, want ErrClosed", err)
+			}
+			if dps := device.DPs(); dps["1"] != false {
+				t.Fatalf("device DPs changed to %v", dps)
+			}
+		})
+	}
+}
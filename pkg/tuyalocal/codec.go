@@ -0,0 +1,265 @@
+package tuyalocal
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+)
+
+// codec encodes and decodes the frames of one connection. The payload
+// encryption depends on the protocol version:
+//
+//	3.1  plaintext, CONTROL and STATUS are "3.1" + md5 + base64(AES-ECB)
+//	3.3  AES-ECB with the local key, version header before the ciphertext
+//	3.4  AES-ECB with the session key, version header inside the ciphertext, HMAC-SHA256 frames
+//	3.5  AES-GCM with the session key in 6699 frames
+type codec struct {
+	version  string
+	localKey []byte
+	// negotiated for 3.4 and 3.5, the local key is used until then
+	sessionKey []byte
+	// frames written by a device carry a return code
+	device bool
+}
+
+func newCodec(version, localKey string, device bool) (*codec, error) {
+	switch version {
+	case Version31, Version33, Version34, Version35:
+	default:
+		return nil, fmt.Errorf("unsupported protocol version %q", version)
+	}
+	if len(localKey) != 16 {
+		return nil, fmt.Errorf("local key must be 16 characters")
+	}
+	return &codec{version: version, localKey: []byte(localKey), device: device}, nil
+}
+
+func (c *codec) key() []byte {
+	if c.sessionKey != nil {
+		return c.sessionKey
+	}
+	return c.localKey
+}
+
+// negotiated tells whether the version needs a session key
+func (c *codec) negotiated() bool {
+	return c.version == Version34 || c.version == Version35
+}
+
+func (c *codec) encode(seq, cmd uint32, payload []byte) ([]byte, error) {
+	msg := Message{Seq: seq, Cmd: cmd, HasRetCode: c.device}
+	key := c.key()
+
+	switch c.version {
+	case Version31:
+		if (cmd == CmdControl || cmd == CmdStatus) && len(payload) > 0 {
+			encrypted, err := tuya.AesEcbEncrypt(payload, key)
+			if err != nil {
+				return nil, err
+			}
+			data := base64.StdEncoding.EncodeToString(encrypted)
+			payload = append([]byte(Version31+signature31(data, key)), data...)
+		}
+		msg.Payload = payload
+		return pack55AA(msg, nil), nil
+	case Version33:
+		if len(payload) > 0 {
+			encrypted, err := tuya.AesEcbEncrypt(payload, key)
+			if err != nil {
+				return nil, err
+			}
+			if !noVersionHeader[cmd] {
+				encrypted = append(versionHeader(c.version), encrypted...)
+			}
+			payload = encrypted
+		}
+		msg.Payload = payload
+		return pack55AA(msg, nil), nil
+	case Version34:
+		if !noVersionHeader[cmd] {
+			payload = append(versionHeader(c.version), payload...)
+		}
+		encrypted, err := tuya.AesEcbEncrypt(payload, key)
+		if err != nil {
+			return nil, err
+		}
+		msg.Payload = encrypted
+		return pack55AA(msg, key), nil
+	default:
+		if !noVersionHeader[cmd] {
+			payload = append(versionHeader(c.version), payload...)
+		}
+		msg.Payload = payload
+		nonce := make([]byte, gcmNonceSize)
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		return pack6699(msg, key, nonce)
+	}
+}
+
+func (c *codec) decode(frame []byte) (Message, error) {
+	key := c.key()
+	hasRetCode := !c.device
+
+	switch c.version {
+	case Version31:
+		msg, err := unpack55AA(frame, nil, hasRetCode)
+		if err != nil {
+			return msg, err
+		}
+		if bytes.HasPrefix(msg.Payload, []byte(Version31)) && len(msg.Payload) > 19 {
+			data := msg.Payload[19:]
+			if signature31(string(data), key) != string(msg.Payload[3:19]) {
+				return msg, fmt.Errorf("%w: signature mismatch", ErrInvalidFrame)
+			}
+			encrypted, err := base64.StdEncoding.DecodeString(string(data))
+			if err != nil {
+				return msg, fmt.Errorf("%w: %v", ErrInvalidFrame, err)
+			}
+			if msg.Payload, err = tuya.AesEcbDecrypt(encrypted, key); err != nil {
+				return msg, fmt.Errorf("%w: %v", ErrInvalidFrame, err)
+			}
+		}
+		return msg, nil
+	case Version33:
+		msg, err := unpack55AA(frame, nil, hasRetCode)
+		if err != nil {
+			return msg, err
+		}
+		payload := stripVersionHeader(msg.Payload, c.version)
+		// devices answer some errors in plaintext
+		if len(payload) > 0 && len(payload)%16 == 0 {
+			if payload, err = tuya.AesEcbDecrypt(payload, key); err != nil {
+				return msg, fmt.Errorf("%w: %v", ErrInvalidFrame, err)
+			}
+		}
+		msg.Payload = payload
+		return msg, nil
+	case Version34:
+		msg, err := unpack55AA(frame, key, hasRetCode)
+		if err != nil {
+			return msg, err
+		}
+		if len(msg.Payload) > 0 {
+			if msg.Payload, err = tuya.AesEcbDecrypt(msg.Payload, key); err != nil {
+				return msg, fmt.Errorf("%w: %v", ErrInvalidFrame, err)
+			}
+		}
+		msg.Payload = stripVersionHeader(msg.Payload, c.version)
+		return msg, nil
+	default:
+		msg, err := unpack6699(frame, key, hasRetCode)
+		if err != nil {
+			return msg, err
+		}
+		msg.Payload = stripVersionHeader(msg.Payload, c.version)
+		return msg, nil
+	}
+}
+
+// signature31 is the md5 part of 3.1 encrypted payloads
+func signature31(data string, key []byte) string {
+	sum := md5.Sum([]byte("data=" + data + "||lpv=" + Version31 + "||" + string(key)))
+	return hex.EncodeToString(sum[:])[8:24]
+}
+
+// controlCmd and queryCmd are the commands used by the version
+func (c *codec) controlCmd() uint32 {
+	if c.negotiated() {
+		return CmdControlNew
+	}
+	return CmdControl
+}
+
+func (c *codec) queryCmd() uint32 {
+	if c.negotiated() {
+		return CmdDpQueryNew
+	}
+	return CmdDpQuery
+}
+
+// payloads of the client requests
+
+func (c *codec) queryPayload(deviceId string) ([]byte, error) {
+	if c.negotiated() {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(map[string]string{
+		"gwId":  deviceId,
+		"devId": deviceId,
+		"uid":   deviceId,
+		"t":     timestamp(),
+	})
+}
+
+func (c *codec) controlPayload(deviceId string, dps map[string]interface{}) ([]byte, error) {
+	if c.negotiated() {
+		return json.Marshal(map[string]interface{}{
+			"protocol": 5,
+			"t":        time.Now().Unix(),
+			"data":     map[string]interface{}{"dps": dps},
+		})
+	}
+	return json.Marshal(map[string]interface{}{
+		"devId": deviceId,
+		"uid":   deviceId,
+		"t":     timestamp(),
+		"dps":   dps,
+	})
+}
+
+func (c *codec) heartbeatPayload(deviceId string) ([]byte, error) {
+	if c.negotiated() {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(map[string]string{"gwId": deviceId, "devId": deviceId})
+}
+
+// statusPayload is what a device reports, pushed is set for unsolicited STATUS
+func (c *codec) statusPayload(deviceId string, dps map[string]interface{}, pushed bool) ([]byte, error) {
+	if c.negotiated() && pushed {
+		return json.Marshal(map[string]interface{}{
+			"protocol": 4,
+			"t":        time.Now().Unix(),
+			"data":     map[string]interface{}{"dps": dps},
+		})
+	}
+	return json.Marshal(map[string]interface{}{
+		"devId": deviceId,
+		"dps":   dps,
+		"t":     time.Now().Unix(),
+	})
+}
+
+// parseDps extracts the DPs of a status or control payload, 3.4+ devices
+// nest them under data
+func parseDps(payload []byte) (map[string]interface{}, error) {
+	var body struct {
+		Dps  map[string]interface{} `json:"dps"`
+		Data struct {
+			Dps map[string]interface{} `json:"dps"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &body); err != nil {
+		return nil, fmt.Errorf("invalid payload %q: %w", payload, err)
+	}
+	if body.Dps != nil {
+		return body.Dps, nil
+	}
+	if body.Data.Dps != nil {
+		return body.Data.Dps, nil
+	}
+	return map[string]interface{}{}, nil
+}
+
+func timestamp() string {
+	return fmt.Sprint(time.Now().Unix())
+}
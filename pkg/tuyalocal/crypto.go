@@ -0,0 +1,67 @@
+package tuyalocal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+)
+
+func gcmSeal(key, nonce, plain, additional []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nil, nonce, plain, additional), nil
+}
+
+func gcmOpen(key, nonce, sealed, additional []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, nonce, sealed, additional)
+}
+
+func hmacSha256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// sessionKey derives the 3.4/3.5 session key from both nonces: their XOR is
+// encrypted with the local key, with AES-ECB for 3.4 and AES-GCM (nonce from
+// the local nonce) for 3.5
+func sessionKey(version string, localKey, localNonce, remoteNonce []byte) ([]byte, error) {
+	if len(localNonce) != 16 || len(remoteNonce) != 16 {
+		return nil, fmt.Errorf("nonces must be 16 bytes")
+	}
+	xored := make([]byte, 16)
+	for i := range xored {
+		xored[i] = localNonce[i] ^ remoteNonce[i]
+	}
+	if version == Version35 {
+		sealed, err := gcmSeal(localKey, localNonce[:gcmNonceSize], xored, nil)
+		if err != nil {
+			return nil, err
+		}
+		return sealed[:16], nil
+	}
+	// the XOR is a single block, so ECB comes down to one block encryption
+	block, err := aes.NewCipher(localKey)
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, 16)
+	block.Encrypt(key, xored)
+	return key, nil
+}
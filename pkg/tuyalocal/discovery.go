@@ -0,0 +1,263 @@
+package tuyalocal
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/pkg/logger"
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultStaleAfter        = 2 * time.Minute
+	defaultExpireAfter       = time.Hour
+	defaultInventoryInterval = 10 * time.Minute
+	// unknown devices trigger an inventory refresh at most this often
+	minInventoryRefresh = time.Minute
+	inventoryPageSize   = 100
+)
+
+// DeviceLister is the part of TuyaClient the discovery needs
+type DeviceLister interface {
+	GetDevices(pageNo, pageSize int, queryParams map[string]string) (*tuya.DevicesResult, error)
+}
+
+// LocalDevice is a device heard on the LAN, merged with the inventory. Known
+// is false for devices that aren't in the project.
+type LocalDevice struct {
+	Id         string    `json:"id"`
+	Ip         string    `json:"ip"`
+	Version    string    `json:"version"`
+	ProductKey string    `json:"product_key"`
+	Encrypted  bool      `json:"encrypted"`
+	Port       int       `json:"port"`
+	LastSeen   time.Time `json:"last_seen"`
+	Online     bool      `json:"online"`
+	Known      bool      `json:"known"`
+	Name       string    `json:"name,omitempty"`
+	Category   string    `json:"category,omitempty"`
+	ProductId  string    `json:"product_id,omitempty"`
+	LocalKey   string    `json:"-"`
+}
+
+type sighting struct {
+	broadcast Broadcast
+	// address the broadcast came from, with the port of the broadcast ip
+	address  string
+	port     int
+	lastSeen time.Time
+}
+
+// Discovery listens for the UDP broadcasts devices send every few seconds and
+// keeps track of their LAN address and protocol version
+type Discovery struct {
+	logger  *logger.AppLogger
+	cfg     *config.Local
+	client  DeviceLister
+	refresh chan struct{}
+
+	mu            sync.RWMutex
+	sightings     map[string]*sighting
+	inventory     map[string]tuya.Device
+	lastRefreshAt time.Time
+}
+
+func NewDiscovery(logger *logger.AppLogger, cfg *config.Config, client DeviceLister) *Discovery {
+	return &Discovery{
+		logger:    logger,
+		cfg:       &cfg.Local,
+		client:    client,
+		refresh:   make(chan struct{}, 1),
+		sightings: map[string]*sighting{},
+		inventory: map[string]tuya.Device{},
+	}
+}
+
+// Run listens on the discovery ports and refreshes the inventory until ctx is done
+func (d *Discovery) Run(ctx context.Context) error {
+	listening := 0
+	for _, port := range DiscoveryPorts {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: port})
+		if err != nil {
+			d.logger.Warnw("discovery_listen_err", zap.Int("port", port), zap.String("error", err.Error()))
+			continue
+		}
+		listening++
+		go d.listen(ctx, conn, port)
+	}
+	if listening == 0 {
+		return fmt.Errorf("no discovery port could be opened")
+	}
+
+	interval := d.cfg.InventoryInterval
+	if interval <= 0 {
+		interval = defaultInventoryInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := d.RefreshInventory(); err != nil {
+			d.logger.Warnw("discovery_inventory_err", zap.String("error", err.Error()))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			d.expire()
+		case <-d.refresh:
+		}
+	}
+}
+
+func (d *Discovery) listen(ctx context.Context, conn *net.UDPConn, port int) {
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, 4096)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				d.logger.Warnw("discovery_read_err", zap.Int("port", port), zap.String("error", err.Error()))
+			}
+			return
+		}
+		if err := d.HandlePacket(buf[:n], addr, port); err != nil {
+			d.logger.Debugw("discovery_packet_err", zap.String("from", addr.String()), zap.String("error", err.Error()))
+		}
+	}
+}
+
+// HandlePacket records the device a broadcast packet received on port from
+// the from address comes from
+func (d *Discovery) HandlePacket(packet []byte, from *net.UDPAddr, port int) error {
+	broadcast, err := DecodeBroadcast(packet)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.sightings[broadcast.GwId] = &sighting{
+		broadcast: *broadcast,
+		address:   deviceAddress(from, broadcast.Ip),
+		port:      port,
+		lastSeen:  time.Now(),
+	}
+	_, known := d.inventory[broadcast.GwId]
+	refresh := !known && time.Since(d.lastRefreshAt) > minInventoryRefresh
+	d.mu.Unlock()
+
+	// the device may have been added since the last refresh
+	if refresh {
+		select {
+		case d.refresh <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// deviceAddress is the source ip of a broadcast, the ip in the payload is
+// only what the device believes its address is and anyone on the LAN can
+// send one. A port in the payload ip, as the simulator sends, is kept.
+func deviceAddress(from *net.UDPAddr, payloadIp string) string {
+	host := from.IP.String()
+	if _, port, err := net.SplitHostPort(payloadIp); err == nil && port != DefaultPort {
+		return net.JoinHostPort(host, port)
+	}
+	return host
+}
+
+// expire forgets the devices not heard from for ExpireAfter
+func (d *Discovery) expire() {
+	expireAfter := d.cfg.ExpireAfter
+	if expireAfter <= 0 {
+		expireAfter = defaultExpireAfter
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for id, s := range d.sightings {
+		if time.Since(s.lastSeen) > expireAfter {
+			delete(d.sightings, id)
+		}
+	}
+}
+
+// RefreshInventory pages through the devices of the project
+func (d *Discovery) RefreshInventory() error {
+	inventory := map[string]tuya.Device{}
+	for pageNo := 1; ; pageNo++ {
+		page, err := d.client.GetDevices(pageNo, inventoryPageSize, map[string]string{})
+		if err != nil {
+			return err
+		}
+		for _, device := range page.Devices {
+			inventory[device.Id] = device
+		}
+		if len(page.Devices) < inventoryPageSize || int64(len(inventory)) >= page.Total {
+			break
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inventory = inventory
+	d.lastRefreshAt = time.Now()
+	return nil
+}
+
+func (d *Discovery) merge(s *sighting) LocalDevice {
+	staleAfter := d.cfg.StaleAfter
+	if staleAfter <= 0 {
+		staleAfter = defaultStaleAfter
+	}
+	device := LocalDevice{
+		Id:         s.broadcast.GwId,
+		Ip:         s.address,
+		Version:    s.broadcast.Version,
+		ProductKey: s.broadcast.ProductKey,
+		Encrypted:  s.broadcast.Encrypt,
+		Port:       s.port,
+		LastSeen:   s.lastSeen,
+		Online:     time.Since(s.lastSeen) < staleAfter,
+	}
+	if inv, ok := d.inventory[device.Id]; ok {
+		device.Known = true
+		device.Name = inv.Name
+		device.Category = inv.Category
+		device.ProductId = inv.ProductId
+		device.LocalKey = inv.LocalKey
+	}
+	return device
+}
+
+// Devices lists the devices heard on the LAN
+func (d *Discovery) Devices() []LocalDevice {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	devices := make([]LocalDevice, 0, len(d.sightings))
+	for _, s := range d.sightings {
+		devices = append(devices, d.merge(s))
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Id < devices[j].Id })
+	return devices
+}
+
+// Device returns a device heard on the LAN
+func (d *Discovery) Device(id string) (LocalDevice, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	s, ok := d.sightings[id]
+	if !ok {
+		return LocalDevice{}, false
+	}
+	return d.merge(s), true
+}
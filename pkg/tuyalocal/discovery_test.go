@@ -0,0 +1,217 @@
+package tuyalocal
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/varjangn/tuya-middleware/config"
+	"github.com/varjangn/tuya-middleware/pkg/logger"
+	"github.com/varjangn/tuya-middleware/pkg/tuya"
+)
+
+// craftBroadcast builds a discovery frame by hand: 55AA header, return code,
+// payload, crc32 and suffix
+func craftBroadcast(payload []byte) []byte {
+	frame := []byte{0, 0, 0x55, 0xaa, 0, 0, 0, 0, 0, 0, 0, 0x13}
+	frame = binary.BigEndian.AppendUint32(frame, uint32(4+len(payload)+8))
+	frame = append(frame, 0, 0, 0, 0)
+	frame = append(frame, payload...)
+	frame = binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE(frame))
+	return append(frame, 0, 0, 0xaa, 0x55)
+}
+
+// encryptBroadcast encrypts a payload as 3.3+ devices do, AES-ECB with PKCS#7
+// padding and the md5 of the broadcast key
+func encryptBroadcast(t *testing.T, payload []byte) []byte {
+	t.Helper()
+	key := md5.Sum([]byte("yGAdlopoPVldABfn"))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	padding := aes.BlockSize - len(payload)%aes.BlockSize
+	padded := append(append([]byte{}, payload...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	encrypted := make([]byte, len(padded))
+	for i := 0; i < len(padded); i += aes.BlockSize {
+		block.Encrypt(encrypted[i:i+aes.BlockSize], padded[i:i+aes.BlockSize])
+	}
+	return encrypted
+}
+
+func TestDecodeBroadcast(t *testing.T) {
+	payload31 := []byte(`{"ip":"192.168.1.20","gwId":"bf31","active":2,"encrypt":false,"productKey":"pk31","version":"3.1"}`)
+	payload33 := []byte(`{"ip":"192.168.1.21","gwId":"bf33","active":2,"encrypt":true,"productKey":"pk33","version":"3.3"}`)
+
+	for _, tt := range []struct {
+		name   string
+		packet []byte
+		want   Broadcast
+	}{
+		{"3.1 plaintext", craftBroadcast(payload31), Broadcast{Ip: "192.168.1.20", GwId: "bf31", Active: 2, ProductKey: "pk31", Version: "3.1"}},
+		{"3.3 encrypted", craftBroadcast(encryptBroadcast(t, payload33)), Broadcast{Ip: "192.168.1.21", GwId: "bf33", Active: 2, Encrypt: true, ProductKey: "pk33", Version: "3.3"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeBroadcast(tt.packet)
+			if err != nil {
+				t.Fatalf("DecodeBroadcast: %v", err)
+			}
+			if *got != tt.want {
+				t.Fatalf("DecodeBroadcast = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+
+	for _, version := range versions {
+		t.Run("encode "+version, func(t *testing.T) {
+			broadcast := Broadcast{Ip: "192.168.1.30", GwId: testDeviceId, Encrypt: version != Version31, Version: version}
+			packet, err := EncodeBroadcast(broadcast)
+			if err != nil {
+				t.Fatalf("EncodeBroadcast: %v", err)
+			}
+			got, err := DecodeBroadcast(packet)
+			if err != nil {
+				t.Fatalf("DecodeBroadcast: %v", err)
+			}
+			if *got != broadcast {
+				t.Fatalf("DecodeBroadcast = %+v, want %+v", *got, broadcast)
+			}
+		})
+	}
+
+	for _, tt := range []struct {
+		name   string
+		packet []byte
+	}{
+		{"short", []byte{0, 0}},
+		{"unknown prefix", bytes.Repeat([]byte{1}, 32)},
+		{"bad crc", append(craftBroadcast(payload31)[:20], bytes.Repeat([]byte{0}, 16)...)},
+		{"not json", craftBroadcast([]byte("{nope"))},
+		{"bad padding", craftBroadcast(bytes.Repeat([]byte{0x42}, 32))},
+		{"missing gwId", craftBroadcast([]byte(`{"ip":"192.168.1.20"}`))},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeBroadcast(tt.packet); !errors.Is(err, ErrInvalidFrame) {
+				t.Fatalf("err = %v, want ErrInvalidFrame", err)
+			}
+		})
+	}
+}
+
+type fakeLister struct {
+	devices []tuya.Device
+}
+
+func (f *fakeLister) GetDevices(pageNo, pageSize int, queryParams map[string]string) (*tuya.DevicesResult, error) {
+	return &tuya.DevicesResult{Total: int64(len(f.devices)), Devices: f.devices}, nil
+}
+
+func newTestDiscovery(t *testing.T, lister DeviceLister) *Discovery {
+	t.Helper()
+	cfg := &config.Config{Logger: config.Logger{Level: "fatal", Encoding: "console"}}
+	appLogger := logger.NewAppLogger(cfg)
+	appLogger.InitLogger()
+	return NewDiscovery(appLogger, cfg, lister)
+}
+
+func TestHandlePacket(t *testing.T) {
+	d := newTestDiscovery(t, &fakeLister{devices: []tuya.Device{
+		{Id: "bf33", Name: "plug", Category: "cz", ProductId: "p33", LocalKey: testLocalKey},
+	}})
+	if err := d.RefreshInventory(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the payload ip is ignored for the source address
+	packet := craftBroadcast(encryptBroadcast(t, []byte(`{"ip":"10.0.0.9","gwId":"bf33","encrypt":true,"version":"3.3"}`)))
+	if err := d.HandlePacket(packet, &net.UDPAddr{IP: net.IPv4(192, 168, 1, 21), Port: 49152}, 6667); err != nil {
+		t.Fatalf("HandlePacket: %v", err)
+	}
+	device, ok := d.Device("bf33")
+	if !ok {
+		t.Fatal("device not recorded")
+	}
+	want := LocalDevice{
+		Id: "bf33", Ip: "192.168.1.21", Version: "3.3", Encrypted: true, Port: 6667, Online: true, Known: true,
+		Name: "plug", Category: "cz", ProductId: "p33", LocalKey: testLocalKey, LastSeen: device.LastSeen,
+	}
+	if device != want {
+		t.Fatalf("Device = %+v, want %+v", device, want)
+	}
+	select {
+	case <-d.refresh:
+		t.Fatal("known device triggered an inventory refresh")
+	default:
+	}
+
+	// an unknown device may have been added since the last refresh
+	d.lastRefreshAt = time.Time{}
+	if err := d.HandlePacket(craftBroadcast([]byte(`{"ip":"10.0.0.10","gwId":"bf31","version":"3.1"}`)), &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20)}, 6666); err != nil {
+		t.Fatalf("HandlePacket: %v", err)
+	}
+	select {
+	case <-d.refresh:
+	default:
+		t.Fatal("unknown device didn't trigger an inventory refresh")
+	}
+	if devices := d.Devices(); len(devices) != 2 || devices[0].Id != "bf31" || devices[0].Known || devices[1].Id != "bf33" {
+		t.Fatalf("Devices = %+v", devices)
+	}
+
+	if err := d.HandlePacket([]byte("garbage"), &net.UDPAddr{IP: net.IPv4(192, 168, 1, 22)}, 6667); err == nil {
+		t.Fatal("garbage packet accepted")
+	}
+}
+
+func TestDeviceAddress(t *testing.T) {
+	from := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+	for _, tt := range []struct {
+		payloadIp string
+		want      string
+	}{
+		{"192.168.1.20", "127.0.0.1"},
+		{"", "127.0.0.1"},
+		{"127.0.0.1:17668", "127.0.0.1:17668"},
+		{"192.168.1.20:17668", "127.0.0.1:17668"},
+		{"127.0.0.1:" + DefaultPort, "127.0.0.1"},
+	} {
+		if got := deviceAddress(from, tt.payloadIp); got != tt.want {
+			t.Errorf("deviceAddress(%q) = %q, want %q", tt.payloadIp, got, tt.want)
+		}
+	}
+}
+
+func TestStaleAndExpiredSightings(t *testing.T) {
+	d := newTestDiscovery(t, &fakeLister{})
+	d.cfg.StaleAfter = time.Minute
+	d.cfg.ExpireAfter = time.Hour
+	from := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20)}
+	for _, id := range []string{"fresh", "stale", "expired"} {
+		if err := d.HandlePacket(craftBroadcast([]byte(`{"gwId":"`+id+`","version":"3.1"}`)), from, 6666); err != nil {
+			t.Fatalf("HandlePacket: %v", err)
+		}
+	}
+	d.sightings["stale"].lastSeen = time.Now().Add(-2 * time.Minute)
+	d.sightings["expired"].lastSeen = time.Now().Add(-2 * time.Hour)
+
+	if device, _ := d.Device("fresh"); !device.Online {
+		t.Fatal("fresh device reported offline")
+	}
+	if device, _ := d.Device("stale"); device.Online {
+		t.Fatal("stale device reported online")
+	}
+
+	d.expire()
+	if _, ok := d.Device("expired"); ok {
+		t.Fatal("expired device still listed")
+	}
+	if devices := d.Devices(); len(devices) != 2 {
+		t.Fatalf("Devices = %+v, want fresh and stale", devices)
+	}
+}
@@ -0,0 +1,242 @@
+package tuyalocal
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// protocol versions
+const (
+	Version31 = "3.1"
+	Version33 = "3.3"
+	Version34 = "3.4"
+	Version35 = "3.5"
+)
+
+// commands of the local protocol
+const (
+	CmdSessKeyNegStart  uint32 = 3
+	CmdSessKeyNegResp   uint32 = 4
+	CmdSessKeyNegFinish uint32 = 5
+	CmdControl          uint32 = 7
+	CmdStatus           uint32 = 8
+	CmdHeartBeat        uint32 = 9
+	CmdDpQuery          uint32 = 10
+	CmdControlNew       uint32 = 13
+	CmdDpQueryNew       uint32 = 16
+	CmdUpdateDps        uint32 = 18
+)
+
+const (
+	prefix55AA uint32 = 0x000055AA
+	suffix55AA uint32 = 0x0000AA55
+	prefix6699 uint32 = 0x00006699
+	suffix6699 uint32 = 0x00009966
+
+	header55AASize = 16
+	header6699Size = 18
+	gcmNonceSize   = 12
+	gcmTagSize     = 16
+	hmacSize       = 32
+	maxFrameSize   = 64 * 1024
+)
+
+// commands sent without the "3.x" version header
+var noVersionHeader = map[uint32]bool{
+	CmdDpQuery:          true,
+	CmdDpQueryNew:       true,
+	CmdUpdateDps:        true,
+	CmdHeartBeat:        true,
+	CmdSessKeyNegStart:  true,
+	CmdSessKeyNegResp:   true,
+	CmdSessKeyNegFinish: true,
+}
+
+var ErrInvalidFrame = errors.New("invalid frame")
+
+// Message is a decoded frame. Frames sent by devices carry a return code.
+type Message struct {
+	Seq        uint32
+	Cmd        uint32
+	HasRetCode bool
+	RetCode    uint32
+	Payload    []byte
+}
+
+// pack55AA frames a message with a CRC32, or an HMAC-SHA256 when hmacKey is set (3.4)
+func pack55AA(msg Message, hmacKey []byte) []byte {
+	body := []byte{}
+	if msg.HasRetCode {
+		body = binary.BigEndian.AppendUint32(body, msg.RetCode)
+	}
+	body = append(body, msg.Payload...)
+
+	checksumSize := 4
+	if hmacKey != nil {
+		checksumSize = hmacSize
+	}
+	frame := make([]byte, 0, header55AASize+len(body)+checksumSize+4)
+	frame = binary.BigEndian.AppendUint32(frame, prefix55AA)
+	frame = binary.BigEndian.AppendUint32(frame, msg.Seq)
+	frame = binary.BigEndian.AppendUint32(frame, msg.Cmd)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(body)+checksumSize+4))
+	frame = append(frame, body...)
+	if hmacKey != nil {
+		mac := hmac.New(sha256.New, hmacKey)
+		mac.Write(frame)
+		frame = mac.Sum(frame)
+	} else {
+		frame = binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE(frame))
+	}
+	return binary.BigEndian.AppendUint32(frame, suffix55AA)
+}
+
+// unpack55AA verifies and decodes a 55AA frame, hasRetCode tells whether the
+// sender is a device
+func unpack55AA(frame []byte, hmacKey []byte, hasRetCode bool) (Message, error) {
+	checksumSize := 4
+	if hmacKey != nil {
+		checksumSize = hmacSize
+	}
+	if len(frame) < header55AASize+checksumSize+4 {
+		return Message{}, ErrInvalidFrame
+	}
+	if binary.BigEndian.Uint32(frame[len(frame)-4:]) != suffix55AA {
+		return Message{}, fmt.Errorf("%w: bad suffix", ErrInvalidFrame)
+	}
+	checked := frame[:len(frame)-4-checksumSize]
+	checksum := frame[len(frame)-4-checksumSize : len(frame)-4]
+	if hmacKey != nil {
+		mac := hmac.New(sha256.New, hmacKey)
+		mac.Write(checked)
+		if !hmac.Equal(mac.Sum(nil), checksum) {
+			return Message{}, fmt.Errorf("%w: hmac mismatch", ErrInvalidFrame)
+		}
+	} else if crc32.ChecksumIEEE(checked) != binary.BigEndian.Uint32(checksum) {
+		return Message{}, fmt.Errorf("%w: crc mismatch", ErrInvalidFrame)
+	}
+
+	msg := Message{
+		Seq: binary.BigEndian.Uint32(frame[4:8]),
+		Cmd: binary.BigEndian.Uint32(frame[8:12]),
+	}
+	body := checked[header55AASize:]
+	if hasRetCode && len(body) >= 4 {
+		msg.HasRetCode = true
+		msg.RetCode = binary.BigEndian.Uint32(body[:4])
+		body = body[4:]
+	}
+	msg.Payload = body
+	return msg, nil
+}
+
+// pack6699 frames a 3.5 message, the return code and payload are encrypted
+// with AES-GCM using the header as additional data
+func pack6699(msg Message, key []byte, nonce []byte) ([]byte, error) {
+	plain := []byte{}
+	if msg.HasRetCode {
+		plain = binary.BigEndian.AppendUint32(plain, msg.RetCode)
+	}
+	plain = append(plain, msg.Payload...)
+
+	header := make([]byte, 0, header6699Size)
+	header = binary.BigEndian.AppendUint32(header, prefix6699)
+	header = binary.BigEndian.AppendUint16(header, 0)
+	header = binary.BigEndian.AppendUint32(header, msg.Seq)
+	header = binary.BigEndian.AppendUint32(header, msg.Cmd)
+	header = binary.BigEndian.AppendUint32(header, uint32(gcmNonceSize+len(plain)+gcmTagSize))
+
+	sealed, err := gcmSeal(key, nonce, plain, header[4:])
+	if err != nil {
+		return nil, err
+	}
+	frame := append(header, nonce...)
+	frame = append(frame, sealed...)
+	return binary.BigEndian.AppendUint32(frame, suffix6699), nil
+}
+
+func unpack6699(frame []byte, key []byte, hasRetCode bool) (Message, error) {
+	if len(frame) < header6699Size+gcmNonceSize+gcmTagSize+4 {
+		return Message{}, ErrInvalidFrame
+	}
+	if binary.BigEndian.Uint32(frame[len(frame)-4:]) != suffix6699 {
+		return Message{}, fmt.Errorf("%w: bad suffix", ErrInvalidFrame)
+	}
+	header := frame[:header6699Size]
+	nonce := frame[header6699Size : header6699Size+gcmNonceSize]
+	sealed := frame[header6699Size+gcmNonceSize : len(frame)-4]
+
+	plain, err := gcmOpen(key, nonce, sealed, header[4:])
+	if err != nil {
+		return Message{}, fmt.Errorf("%w: %v", ErrInvalidFrame, err)
+	}
+	msg := Message{
+		Seq: binary.BigEndian.Uint32(header[6:10]),
+		Cmd: binary.BigEndian.Uint32(header[10:14]),
+	}
+	if hasRetCode && len(plain) >= 4 {
+		msg.HasRetCode = true
+		msg.RetCode = binary.BigEndian.Uint32(plain[:4])
+		plain = plain[4:]
+	}
+	msg.Payload = plain
+	return msg, nil
+}
+
+// readFrame reads one complete 55AA or 6699 frame from r
+func readFrame(r io.Reader) ([]byte, error) {
+	prefix := make([]byte, 4)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, err
+	}
+
+	var header []byte
+	var length uint32
+	switch binary.BigEndian.Uint32(prefix) {
+	case prefix55AA:
+		header = make([]byte, header55AASize)
+		copy(header, prefix)
+		if _, err := io.ReadFull(r, header[4:]); err != nil {
+			return nil, err
+		}
+		length = binary.BigEndian.Uint32(header[12:16])
+	case prefix6699:
+		header = make([]byte, header6699Size)
+		copy(header, prefix)
+		if _, err := io.ReadFull(r, header[4:]); err != nil {
+			return nil, err
+		}
+		// the 6699 length excludes the suffix
+		length = binary.BigEndian.Uint32(header[14:18]) + 4
+	default:
+		return nil, fmt.Errorf("%w: unknown prefix %x", ErrInvalidFrame, prefix)
+	}
+	if length > maxFrameSize {
+		return nil, fmt.Errorf("%w: frame too large", ErrInvalidFrame)
+	}
+
+	frame := make([]byte, len(header)+int(length))
+	copy(frame, header)
+	if _, err := io.ReadFull(r, frame[len(header):]); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// versionHeader is the "3.x" header followed by 12 zero bytes
+func versionHeader(version string) []byte {
+	return append([]byte(version), make([]byte, 12)...)
+}
+
+func stripVersionHeader(payload []byte, version string) []byte {
+	if bytes.HasPrefix(payload, []byte(version)) && len(payload) >= 15 {
+		return payload[15:]
+	}
+	return payload
+}
@@ -0,0 +1,288 @@
+package tuyalocal
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestPack55AA(t *testing.T) {
+	hmacKey := []byte(testLocalKey)
+	for _, tt := range []struct {
+		name string
+		key  []byte
+	}{
+		{"crc", nil},
+		{"hmac", hmacKey},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := Message{Seq: 7, Cmd: CmdStatus, HasRetCode: true, RetCode: 1, Payload: []byte(`{"dps":{}}`)}
+			frame := pack55AA(msg, tt.key)
+
+			read, err := readFrame(bytes.NewReader(frame))
+			if err != nil {
+				t.Fatalf("readFrame: %v", err)
+			}
+			got, err := unpack55AA(read, tt.key, true)
+			if err != nil {
+				t.Fatalf("unpack55AA: %v", err)
+			}
+			if got.Seq != msg.Seq || got.Cmd != msg.Cmd || got.RetCode != msg.RetCode || !bytes.Equal(got.Payload, msg.Payload) {
+				t.Fatalf("unpack55AA = %+v, want %+v", got, msg)
+			}
+
+			tampered := append([]byte{}, frame...)
+			tampered[header55AASize] ^= 0xff
+			if _, err := unpack55AA(tampered, tt.key, true); !errors.Is(err, ErrInvalidFrame) {
+				t.Fatalf("tampered frame: err = %v, want ErrInvalidFrame", err)
+			}
+		})
+	}
+
+	frame := pack55AA(Message{Seq: 1, Cmd: CmdHeartBeat}, hmacKey)
+	if _, err := unpack55AA(frame, []byte("fedcba9876543210"), false); !errors.Is(err, ErrInvalidFrame) {
+		t.Fatalf("wrong hmac key: err = %v, want ErrInvalidFrame", err)
+	}
+}
+
+func TestPack6699(t *testing.T) {
+	key := []byte(testLocalKey)
+	nonce := bytes.Repeat([]byte{1}, gcmNonceSize)
+	msg := Message{Seq: 3, Cmd: CmdControlNew, Payload: []byte(`{"protocol":5}`)}
+	frame, err := pack6699(msg, key, nonce)
+	if err != nil {
+		t.Fatalf("pack6699: %v", err)
+	}
+
+	read, err := readFrame(bytes.NewReader(frame))
+	if err != nil {
+		t.Fatalf("readFrame: %v", err)
+	}
+	got, err := unpack6699(read, key, false)
+	if err != nil {
+		t.Fatalf("unpack6699: %v", err)
+	}
+	if got.Seq != msg.Seq || got.Cmd != msg.Cmd || !bytes.Equal(got.Payload, msg.Payload) {
+		t.Fatalf("unpack6699 = %+v, want %+v", got, msg)
+	}
+
+	// the header is authenticated along with the payload
+	tampered := append([]byte{}, frame...)
+	tampered[9] ^= 0xff
+	if _, err := unpack6699(tampered, key, false); !errors.Is(err, ErrInvalidFrame) {
+		t.Fatalf("tampered header: err = %v, want ErrInvalidFrame", err)
+	}
+	if _, err := unpack6699(frame, []byte("fedcba9876543210"), false); !errors.Is(err, ErrInvalidFrame) {
+		t.Fatalf("wrong key: err = %v, want ErrInvalidFrame", err)
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	payload := []byte(`{"devId":"` + testDeviceId + `","dps":{"1":true}}`)
+	for _, version := range versions {
+		t.Run(version, func(t *testing.T) {
+			client, err := newCodec(version, testLocalKey, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			device, err := newCodec(version, testLocalKey, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if client.negotiated() {
+				key, err := sessionKey(version, client.localKey, bytes.Repeat([]byte{1}, 16), bytes.Repeat([]byte{2}, 16))
+				if err != nil {
+					t.Fatal(err)
+				}
+				client.sessionKey, device.sessionKey = key, key
+			}
+
+			for _, cmd := range []uint32{CmdControl, CmdStatus} {
+				frame, err := client.encode(1, cmd, payload)
+				if err != nil {
+					t.Fatalf("encode: %v", err)
+				}
+				// only 3.1 queries and heartbeats travel in plaintext
+				if bytes.Contains(frame, payload) {
+					t.Fatalf("command %d sent in plaintext", cmd)
+				}
+				msg, err := device.decode(frame)
+				if err != nil {
+					t.Fatalf("decode: %v", err)
+				}
+				if msg.Cmd != cmd || !bytes.Equal(msg.Payload, payload) {
+					t.Fatalf("decode = %d %q, want %d %q", msg.Cmd, msg.Payload, cmd, payload)
+				}
+			}
+		})
+	}
+}
+
+func TestSessionKey(t *testing.T) {
+	localKey := []byte(testLocalKey)
+	localNonce := []byte("0123456789abcdef")
+	remoteNonce := []byte("fedcba9876543210")
+	for _, version := range []string{Version34, Version35} {
+		key, err := sessionKey(version, localKey, localNonce, remoteNonce)
+		if err != nil {
+			t.Fatalf("%s: %v", version, err)
+		}
+		if len(key) != 16 {
+			t.Fatalf("%s: key of %d bytes", version, len(key))
+		}
+		other, _ := sessionKey(version, localKey, remoteNonce, localNonce)
+		if version == Version34 && !bytes.Equal(key, other) {
+			t.Fatalf("3.4 key depends on the order of the nonces")
+		}
+	}
+	ecb, _ := sessionKey(Version34, localKey, localNonce, remoteNonce)
+	gcm, _ := sessionKey(Version35, localKey, localNonce, remoteNonce)
+	if bytes.Equal(ecb, gcm) {
+		t.Fatal("3.4 and 3.5 derive the same session key")
+	}
+	if _, err := sessionKey(Version34, localKey, localNonce[:8], remoteNonce); err == nil {
+		t.Fatal("short nonce accepted")
+	}
+}
+
+func TestCodecInvalidPadding(t *testing.T) {
+	device, err := newCodec(Version33, testLocalKey, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, _ := newCodec(Version33, testLocalKey, false)
+	frame, err := client.encode(1, CmdControl, []byte(`{"dps":{"1":true}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// decrypted with another key the PKCS#7 padding doesn't verify
+	other, _ := newCodec(Version33, "fedcba9876543210", true)
+	if _, err := other.decode(frame); !errors.Is(err, ErrInvalidFrame) {
+		t.Fatalf("decode with the wrong key: err = %v, want ErrInvalidFrame", err)
+	}
+	if _, err := device.decode(frame); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestKnownFrames checks the codec against frames built outside of this
+// package (node crypto, following the tinytuya framing) for the local key
+// 0123456789abcdef and the nonces 0123456789abcdef/fedcba9876543210
+func TestKnownFrames(t *testing.T) {
+	localNonce := []byte("0123456789abcdef")
+	remoteNonce := []byte("fedcba9876543210")
+	for _, tt := range []struct {
+		name       string
+		version    string
+		sessionKey string
+		// fromDevice frames carry a return code and are decoded by a client
+		fromDevice bool
+		seq, cmd   uint32
+		payload    string
+		frame      string
+	}{
+		{
+			name:       "3.3 status",
+			version:    Version33,
+			fromDevice: true,
+			seq:        1,
+			cmd:        CmdStatus,
+			payload:    `{"devId":"bf1234567890abcdef","dps":{"1":true},"t":1700000000}`,
+			frame: "000055aa00000001000000080000005b00000000332e33000000000000000000000000d652f952e5585d3b065bf07e1e6e0045" +
+				"9ec3ccc9469ffc8f7017a1e0efe6174a6461bcb36f4e233e0fd566e7957e386f89ad7933af7fbb611a23a225db505597c9917dca0000aa55",
+		},
+		{
+			name:    "3.3 control",
+			version: Version33,
+			seq:     2,
+			cmd:     CmdControl,
+			payload: `{"devId":"bf1234567890abcdef","dps":{"1":true},"t":"1700000000","uid":"bf1234567890abcdef"}`,
+			frame: "000055aa000000020000000700000077332e33000000000000000000000000d652f952e5585d3b065bf07e1e6e00459ec3ccc9" +
+				"469ffc8f7017a1e0efe6174a6461bcb36f4e233e0fd566e7957e386f77a161492d5232dcc0b97c0f2e2ad0e8a5fe81b4055414b1be30" +
+				"8b3fd42206d3e1a64ec2841b2bb350ac684083c8dc6f90d04f730000aa55",
+		},
+		{
+			name:       "3.4 control",
+			version:    Version34,
+			sessionKey: "6575cf6b37479d9215337ff9767fe786",
+			seq:        3,
+			cmd:        CmdControlNew,
+			payload:    `{"data":{"dps":{"1":true}},"protocol":5,"t":1700000000}`,
+			frame: "000055aa000000030000000d00000074746f1879e5e3003a5bd64e0aad1234de52d7318b3b317d115cc19c665066e0f03e31aaee" +
+				"b84713a1e91a1944c771e4cddd165b8814103680b602fd80c19664a6940743561f0a29bbf263bc4d1628b0704b08be87ee521832cd45" +
+				"bba6763d3797ca31489d53b1fddb15ce93c106972fac0000aa55",
+		},
+		{
+			name:       "3.5 status",
+			version:    Version35,
+			sessionKey: "34165bab783422860c0c23b2d48cf7a5",
+			fromDevice: true,
+			seq:        4,
+			cmd:        CmdStatus,
+			payload:    `{"data":{"dps":{"1":true}},"protocol":4,"t":1700000000}`,
+			frame: "000066990000000000040000000800000066000102030405060708090a0bfb49534481fed2f62acb6b5e4e758f620bba9ab53bfc" +
+				"8586fdadbcdd36c503eac478a4c2c5d2c52f5d12493386bed075642641945afc792d856f5a234dd1e65e2572dd6ab8376610b02fd4d5" +
+				"fdf435e3056661f8690c3910d90e00009966",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := mustHex(t, tt.frame)
+			sender, err := newCodec(tt.version, testLocalKey, tt.fromDevice)
+			if err != nil {
+				t.Fatal(err)
+			}
+			receiver, _ := newCodec(tt.version, testLocalKey, !tt.fromDevice)
+			if tt.sessionKey != "" {
+				key, err := sessionKey(tt.version, sender.localKey, localNonce, remoteNonce)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := mustHex(t, tt.sessionKey); !bytes.Equal(key, want) {
+					t.Fatalf("session key = %x, want %x", key, want)
+				}
+				sender.sessionKey, receiver.sessionKey = key, key
+			}
+
+			read, err := readFrame(bytes.NewReader(append(frame, 0, 0)))
+			if err != nil {
+				t.Fatalf("readFrame: %v", err)
+			}
+			if !bytes.Equal(read, frame) {
+				t.Fatalf("readFrame = %x, want %x", read, frame)
+			}
+			msg, err := receiver.decode(frame)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if msg.Seq != tt.seq || msg.Cmd != tt.cmd || msg.HasRetCode != tt.fromDevice || msg.RetCode != 0 || string(msg.Payload) != tt.payload {
+				t.Fatalf("decode = %+v (%q), want seq %d cmd %d payload %q", msg, msg.Payload, tt.seq, tt.cmd, tt.payload)
+			}
+
+			// 3.5 frames use a random nonce, repack with the one of the fixed frame
+			var encoded []byte
+			if tt.version == Version35 {
+				payload := append(versionHeader(tt.version), tt.payload...)
+				nonce := frame[header6699Size : header6699Size+gcmNonceSize]
+				encoded, err = pack6699(Message{Seq: tt.seq, Cmd: tt.cmd, HasRetCode: tt.fromDevice, Payload: payload}, sender.key(), nonce)
+			} else {
+				encoded, err = sender.encode(tt.seq, tt.cmd, []byte(tt.payload))
+			}
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			if !bytes.Equal(encoded, frame) {
+				t.Fatalf("encode = %x, want %x", encoded, frame)
+			}
+		})
+	}
+}
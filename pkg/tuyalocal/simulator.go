@@ -0,0 +1,185 @@
+package tuyalocal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"net"
+	"sync"
+)
+
+// SimulatedDevice is the device side of the protocol. It keeps DPs in memory,
+// answers queries, heartbeats and controls, and reports changes to every
+// connected client.
+type SimulatedDevice struct {
+	Id       string
+	LocalKey string
+	Version  string
+
+	mu    sync.Mutex
+	dps   map[string]interface{}
+	conns map[*simConn]struct{}
+	seq   uint32
+}
+
+type simConn struct {
+	conn  net.Conn
+	codec *codec
+	mu    sync.Mutex
+	ready bool
+}
+
+func NewSimulatedDevice(id, localKey, version string, dps map[string]interface{}) *SimulatedDevice {
+	state := make(map[string]interface{}, len(dps))
+	for dp, value := range dps {
+		state[dp] = value
+	}
+	return &SimulatedDevice{
+		Id:       id,
+		LocalKey: localKey,
+		Version:  version,
+		dps:      state,
+		conns:    map[*simConn]struct{}{},
+	}
+}
+
+// DPs returns a copy of the current DPs
+func (d *SimulatedDevice) DPs() map[string]interface{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dps := make(map[string]interface{}, len(d.dps))
+	for dp, value := range d.dps {
+		dps[dp] = value
+	}
+	return dps
+}
+
+// SetDPs changes DPs as if done on the device and reports them to the clients
+func (d *SimulatedDevice) SetDPs(dps map[string]interface{}) {
+	d.mu.Lock()
+	for dp, value := range dps {
+		d.dps[dp] = value
+	}
+	conns := make([]*simConn, 0, len(d.conns))
+	for conn := range d.conns {
+		conns = append(conns, conn)
+	}
+	d.mu.Unlock()
+
+	for _, conn := range conns {
+		payload, err := conn.codec.statusPayload(d.Id, dps, true)
+		if err == nil {
+			d.send(conn, d.nextSeq(), CmdStatus, payload)
+		}
+	}
+}
+
+func (d *SimulatedDevice) nextSeq() uint32 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.seq++
+	return d.seq
+}
+
+// Serve accepts connections until the listener is closed
+func (d *SimulatedDevice) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go d.ServeConn(conn)
+	}
+}
+
+// ServeConn handles one client connection until it is closed or sends an
+// invalid frame
+func (d *SimulatedDevice) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+	codec, err := newCodec(d.Version, d.LocalKey, true)
+	if err != nil {
+		return err
+	}
+	sc := &simConn{conn: conn, codec: codec, ready: !codec.negotiated()}
+
+	d.mu.Lock()
+	d.conns[sc] = struct{}{}
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.conns, sc)
+		d.mu.Unlock()
+	}()
+
+	var clientNonce, deviceNonce []byte
+	for {
+		frame, err := readFrame(conn)
+		if err != nil {
+			return err
+		}
+		sc.mu.Lock()
+		msg, err := codec.decode(frame)
+		sc.mu.Unlock()
+		if err != nil {
+			return err
+		}
+
+		switch msg.Cmd {
+		case CmdSessKeyNegStart:
+			if len(msg.Payload) != 16 {
+				return ErrInvalidFrame
+			}
+			clientNonce = msg.Payload
+			deviceNonce = make([]byte, 16)
+			rand.Read(deviceNonce)
+			d.send(sc, msg.Seq, CmdSessKeyNegResp,
+				append(append([]byte{}, deviceNonce...), hmacSha256(codec.localKey, clientNonce)...))
+		case CmdSessKeyNegFinish:
+			if deviceNonce == nil || !bytes.Equal(msg.Payload, hmacSha256(codec.localKey, deviceNonce)) {
+				return ErrInvalidFrame
+			}
+			key, err := sessionKey(d.Version, codec.localKey, clientNonce, deviceNonce)
+			if err != nil {
+				return err
+			}
+			sc.mu.Lock()
+			codec.sessionKey = key
+			sc.ready = true
+			sc.mu.Unlock()
+		case CmdDpQuery, CmdDpQueryNew:
+			payload, err := codec.statusPayload(d.Id, d.DPs(), false)
+			if err != nil {
+				return err
+			}
+			d.send(sc, msg.Seq, msg.Cmd, payload)
+		case CmdControl, CmdControlNew:
+			dps, err := parseDps(msg.Payload)
+			if err != nil {
+				return err
+			}
+			// applied before the ack, so a client reading the DPs once
+			// its command returned sees them
+			d.SetDPs(dps)
+			d.send(sc, msg.Seq, msg.Cmd, nil)
+		case CmdHeartBeat:
+			d.send(sc, msg.Seq, CmdHeartBeat, nil)
+		}
+	}
+}
+
+func (d *SimulatedDevice) send(sc *simConn, seq, cmd uint32, payload []byte) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	// status reports wait until the session key is negotiated
+	if !sc.ready && cmd == CmdStatus {
+		return
+	}
+	frame, err := sc.codec.encode(seq, cmd, payload)
+	if err != nil {
+		return
+	}
+	sc.conn.Write(frame)
+}